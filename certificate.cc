@@ -0,0 +1,51 @@
+/**
+ * C wrapper around rtc::RTCCertificate, for DTLS certificates which outlive
+ * a single PeerConnection.
+ */
+#include "certificate.h"
+
+#include <memory>
+#include <string.h>
+
+#include "rtc_base/rtccertificate.h"
+#include "rtc_base/rtccertificategenerator.h"
+#include "rtc_base/sslfingerprint.h"
+
+#define SUCCESS 0
+#define FAILURE -1
+
+using namespace std;
+
+int CGO_GenerateCertificate(int keyType, long long expires_ms,
+                            CGO_Certificate *out) {
+  rtc::KeyParams params = rtc::KT_RSA == keyType ?
+      rtc::KeyParams::RSA() : rtc::KeyParams::ECDSA();
+  rtc::Optional<uint64_t> expires;
+  if (expires_ms > 0)
+    expires = rtc::Optional<uint64_t>((uint64_t)expires_ms);
+  auto cert = rtc::RTCCertificateGenerator::GenerateCertificate(
+      params, expires);
+  if (!cert)
+    return FAILURE;
+  auto pem = cert->ToPEM();
+  out->privateKey = strdup(pem.private_key().c_str());
+  out->certificate = strdup(pem.certificate().c_str());
+  return SUCCESS;
+}
+
+int CGO_InspectCertificate(CGO_Certificate pem,
+                           unsigned long long *expires_ms,
+                           char **fingerprintAlgorithm, char **fingerprint) {
+  auto cert = rtc::RTCCertificate::FromPEM(
+      rtc::RTCCertificatePEM(pem.privateKey, pem.certificate));
+  if (!cert)
+    return FAILURE;
+  std::unique_ptr<rtc::SSLFingerprint> fp(
+      rtc::SSLFingerprint::CreateFromCertificate(cert.get()));
+  if (!fp)
+    return FAILURE;
+  *expires_ms = cert->Expires();
+  *fingerprintAlgorithm = strdup(fp->algorithm.c_str());
+  *fingerprint = strdup(fp->GetRfc4572Fingerprint().c_str());
+  return SUCCESS;
+}
@@ -0,0 +1,126 @@
+package webrtc
+
+// #include <stdlib.h>
+// #include "certificate.h"
+// #include "ctestenums.h"
+import "C"
+import (
+	"errors"
+	"time"
+	"unsafe"
+)
+
+// KeyType selects the algorithm of a generated Certificate's key.
+type KeyType int
+
+// These must match rtc::KeyType in rtc_base/sslidentity.h.
+const (
+	KeyTypeRSA KeyType = iota
+	KeyTypeECDSA
+)
+
+func (t KeyType) String() string {
+	return EnumToStringSafe(int(t), []string{
+		"RSA",
+		"ECDSA",
+	})
+}
+
+/*
+Certificate is a DTLS certificate and its private key, which a PeerConnection
+authenticates itself with, given through Configuration.Certificates.
+
+Without one, every PeerConnection generates a new certificate. Generating one
+instead, and persisting it with PEM, keeps the DTLS fingerprint in the SDP
+stable, for example across restarts of a service whose peers pin it.
+
+See: https://w3c.github.io/webrtc-pc/#dom-rtccertificate
+*/
+type Certificate struct {
+	privateKey  string
+	certificate string
+
+	expires              time.Time
+	fingerprintAlgorithm string
+	fingerprint          string
+}
+
+/*
+GenerateCertificate creates a new self-signed Certificate, with a key of
+|keyType|, which is valid for |expires|. libwebrtc clamps that to a year, and
+uses a default when it is zero.
+
+See: https://w3c.github.io/webrtc-pc/#dom-rtcpeerconnection-generatecertificate
+*/
+func GenerateCertificate(keyType KeyType, expires time.Duration) (*Certificate, error) {
+	if KeyTypeRSA != keyType && KeyTypeECDSA != keyType {
+		return nil, errors.New("GenerateCertificate: unknown KeyType")
+	}
+	var pem C.CGO_Certificate
+	r := C.CGO_GenerateCertificate(C.int(keyType),
+		C.longlong(expires/time.Millisecond), &pem)
+	if 0 != r {
+		return nil, errors.New("GenerateCertificate: failed")
+	}
+	defer C.free(unsafe.Pointer(pem.privateKey))
+	defer C.free(unsafe.Pointer(pem.certificate))
+	return NewCertificateFromPEM(C.GoString(pem.privateKey),
+		C.GoString(pem.certificate))
+}
+
+// NewCertificateFromPEM restores a Certificate from the PEM strings of its
+// private key and certificate, as returned by Certificate.PEM.
+func NewCertificateFromPEM(privateKey, certificate string) (*Certificate, error) {
+	c := &Certificate{
+		privateKey:  privateKey,
+		certificate: certificate,
+	}
+	pem := c._CGO()
+	defer freeCertificate(pem)
+	var expires C.ulonglong
+	var algorithm, fingerprint *C.char
+	if 0 != C.CGO_InspectCertificate(pem, &expires, &algorithm, &fingerprint) {
+		return nil, errors.New("Certificate: invalid PEM")
+	}
+	defer C.free(unsafe.Pointer(algorithm))
+	defer C.free(unsafe.Pointer(fingerprint))
+	c.expires = time.Unix(0, int64(expires)*int64(time.Millisecond))
+	c.fingerprintAlgorithm = C.GoString(algorithm)
+	c.fingerprint = C.GoString(fingerprint)
+	return c, nil
+}
+
+// PEM returns the private key and the certificate, for persisting the
+// Certificate. Keep the private key secret.
+func (c *Certificate) PEM() (privateKey, certificate string) {
+	return c.privateKey, c.certificate
+}
+
+// Expires returns when the Certificate stops being valid.
+func (c *Certificate) Expires() time.Time {
+	return c.expires
+}
+
+// Fingerprint returns the hash function and value of the fingerprint, as they
+// appear in the "a=fingerprint" line of the SDP, such as "sha-256" and
+// "AB:CD:...".
+func (c *Certificate) Fingerprint() (algorithm, value string) {
+	return c.fingerprintAlgorithm, c.fingerprint
+}
+
+// The returned struct must be released with freeCertificate.
+func (c *Certificate) _CGO() C.CGO_Certificate {
+	return C.CGO_Certificate{
+		privateKey:  C.CString(c.privateKey),
+		certificate: C.CString(c.certificate),
+	}
+}
+
+func freeCertificate(pem C.CGO_Certificate) {
+	C.free(unsafe.Pointer(pem.privateKey))
+	C.free(unsafe.Pointer(pem.certificate))
+}
+
+// Test helpers
+var _cgoKeyTypeRSA = int(C.CGO_KeyTypeRSA)
+var _cgoKeyTypeECDSA = int(C.CGO_KeyTypeECDSA)
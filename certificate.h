@@ -0,0 +1,36 @@
+#ifndef _C_CERTIFICATE_H_
+#define _C_CERTIFICATE_H_
+
+#define WEBRTC_POSIX 1
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+  // In order to present an interface cgo is happy with, nothing in this file
+  // can directly reference header files from libwebrtc / C++ world. All the
+  // casting must be hidden in the .cc file.
+
+  // An rtc::RTCCertificate, passed around as its PEM strings.
+  typedef struct {
+    char *privateKey;
+    char *certificate;
+  } CGO_Certificate;
+
+  // Generate a certificate with a key of |keyType|, which expires after
+  // |expires_ms|, or a default time if that is not positive. Fills |out| with
+  // strings which must be freed by Go. Returns 0 on success.
+  int CGO_GenerateCertificate(int keyType, long long expires_ms,
+                              CGO_Certificate *out);
+
+  // Check that |pem| holds a valid certificate, and describe it. The
+  // fingerprint strings must be freed by Go. Returns 0 on success.
+  int CGO_InspectCertificate(CGO_Certificate pem,
+                             unsigned long long *expires_ms,
+                             char **fingerprintAlgorithm, char **fingerprint);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif  // _C_CERTIFICATE_H_
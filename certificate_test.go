@@ -0,0 +1,72 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKeyTypeEnums(t *testing.T) {
+	Convey(`Enum: KeyType values should match
+C++ rtc::KeyType values`, t, func() {
+		So(KeyTypeRSA, ShouldEqual, _cgoKeyTypeRSA)
+		So(KeyTypeECDSA, ShouldEqual, _cgoKeyTypeECDSA)
+	})
+}
+
+func TestCertificate(t *testing.T) {
+	SetLoggingVerbosity(0)
+
+	Convey("Certificate", t, func() {
+		cert, err := GenerateCertificate(KeyTypeECDSA, time.Hour*24)
+		So(err, ShouldBeNil)
+		So(cert.Expires(), ShouldHappenWithin, time.Hour, time.Now().Add(time.Hour*24))
+		algorithm, fingerprint := cert.Fingerprint()
+		So(algorithm, ShouldEqual, "sha-256")
+		So(fingerprint, ShouldNotBeEmpty)
+
+		Convey("Persist with PEM", func() {
+			key, pem := cert.PEM()
+			So(key, ShouldContainSubstring, "PRIVATE KEY")
+			So(pem, ShouldContainSubstring, "BEGIN CERTIFICATE")
+			restored, err := NewCertificateFromPEM(key, pem)
+			So(err, ShouldBeNil)
+			So(restored, ShouldResemble, cert)
+
+			_, err = NewCertificateFromPEM("", pem)
+			So(err, ShouldNotBeNil)
+			_, err = NewCertificateFromPEM(key, "garbage")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("RSA", func() {
+			rsa, err := GenerateCertificate(KeyTypeRSA, 0)
+			So(err, ShouldBeNil)
+			_, other := rsa.Fingerprint()
+			So(other, ShouldNotEqual, fingerprint)
+			_, err = GenerateCertificate(KeyType(7), 0)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Used by a PeerConnection", func() {
+			config := NewConfiguration(OptionCertificate(cert))
+			So(len(config.Certificates), ShouldEqual, 1)
+			pc, err := NewPeerConnection(config)
+			So(err, ShouldBeNil)
+			channel, err := pc.CreateDataChannel("pinned")
+			So(err, ShouldBeNil)
+			offer, err := pc.CreateOffer()
+			So(err, ShouldBeNil)
+			So(offer.Sdp, ShouldContainSubstring,
+				"a=fingerprint:"+algorithm+" "+fingerprint)
+			pc.DeleteDataChannel(channel)
+			So(pc.Destroy(), ShouldBeNil)
+
+			// An invalid certificate fails the PeerConnection.
+			config.Certificates = []Certificate{{}}
+			_, err = NewPeerConnection(config)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
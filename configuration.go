@@ -7,6 +7,7 @@ import "C"
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"unsafe"
 )
@@ -21,7 +22,12 @@ type (
 	BundlePolicy       int
 	IceTransportPolicy int
 	RtcpMuxPolicy      int
+	SdpSemantics       int
 	IceCredentialType  int
+	TlsCertPolicy      int
+	NetworkType        int
+	TcpCandidatePolicy int
+	GatheringPolicy    int
 	SignalingState     int
 )
 
@@ -29,12 +35,52 @@ type Configuration struct {
 	IceServers []IceServer
 	IceTransportPolicy
 	BundlePolicy
-	// [ED] RtcpMuxPolicy        RtcpMuxPolicy
+	RtcpMuxPolicy
+	SdpSemantics
 	PeerIdentity string // Target peer identity
 
-	// This would allow key continuity.
-	// [ED] Certificates         []string
-	// [ED] IceCandidatePoolSize int
+	// Allows key continuity, instead of a new certificate per PeerConnection.
+	// These cannot be changed with SetConfiguration.
+	Certificates []Certificate
+
+	// How many ICE candidates to gather ahead of the first offer, from 0 (the
+	// default) to 255, for a faster start. Can only be changed through
+	// SetConfiguration before SetLocalDescription.
+	IceCandidatePoolSize int
+
+	// Not part of the spec. These control which local ports and networks ICE
+	// gathers candidates on, and cannot be changed with SetConfiguration.
+
+	// Restricts local candidates to ports from MinPort to MaxPort, inclusive.
+	// Zero for both allows any port.
+	MinPort int
+	MaxPort int
+	// Types of network to leave out, along with those of the Factory's
+	// FactoryOptions. Zero leaves out NetworkTypeLoopback, as libwebrtc does,
+	// and NetworkTypeNone leaves out nothing.
+	NetworkIgnoreMask NetworkType
+	// Names of network interfaces to leave out, such as "docker0".
+	IgnoredInterfaces []string
+	// If not empty, the only local IP addresses and interface names, such as
+	// "wg0", to gather candidates on. Loopback addresses are still left out
+	// unless NetworkIgnoreMask allows them.
+	LocalAddresses []string
+	DisableIPv6    bool
+	TcpCandidatePolicy
+	GatheringPolicy
+
+	// Public addresses of a 1:1 NAT to advertise candidates for, as
+	// IceCandidateTypeHost or IceCandidateTypeSrflx. See OptionNATMapping.
+	NATMappings      []NATMapping
+	NATCandidateType IceCandidateType
+
+	// Routes TURN allocations over TCP and TLS through a proxy. Fixed once
+	// the PeerConnection is created.
+	Proxy ProxyConfig
+
+	// Creates the sockets of the PeerConnection, in place of the host's. See
+	// OptionTransport.
+	Transport Transport
 }
 
 // These "Enum" consts must match order in: peerconnectioninterface.h
@@ -93,31 +139,142 @@ func (s SignalingState) String() string {
 	})
 }
 
-// TODO: [ED]
-/* const (
+const (
 	RtcpMuxPolicyNegotiate RtcpMuxPolicy = iota
 	RtcpMuxPolicyRequire
-) */
+)
+
+func (p RtcpMuxPolicy) String() string {
+	return EnumToStringSafe(int(p), []string{
+		"Negotiate",
+		"Require",
+	})
+}
+
+// SdpSemantics selects the SDP format for offers and answers. Unified Plan is
+// what modern browsers use, while Plan B is what this libwebrtc uses by
+// default, and is only needed for legacy implementations. Data-only
+// connections are compatible either way.
+const (
+	SdpSemanticsDefault SdpSemantics = iota
+	SdpSemanticsPlanB
+	SdpSemanticsUnifiedPlan
+)
+
+func (s SdpSemantics) String() string {
+	return EnumToStringSafe(int(s), []string{
+		"Default",
+		"PlanB",
+		"UnifiedPlan",
+	})
+}
+
+// Bits of Configuration.NetworkIgnoreMask, matching rtc::AdapterType.
+const (
+	NetworkTypeEthernet NetworkType = 1 << iota
+	NetworkTypeWifi
+	NetworkTypeCellular
+	NetworkTypeVpn
+	NetworkTypeLoopback
+
+	// In place of zero, which is the default of NetworkTypeLoopback, to leave
+	// out no networks at all.
+	NetworkTypeNone NetworkType = 1 << 30
+)
 
-// TODO: [ED]
-/* const (
+func (t NetworkType) String() string {
+	if NetworkTypeNone == t {
+		return "None"
+	}
+	names := []string{"Ethernet", "Wifi", "Cellular", "Vpn", "Loopback"}
+	var set []string
+	for i, name := range names {
+		if 0 != t&(1<<uint(i)) {
+			set = append(set, name)
+		}
+	}
+	if 0 == len(set) {
+		return "Default"
+	}
+	return strings.Join(set, "|")
+}
+
+const (
+	TcpCandidatePolicyEnabled TcpCandidatePolicy = iota
+	TcpCandidatePolicyDisabled
+)
+
+func (p TcpCandidatePolicy) String() string {
+	return EnumToStringSafe(int(p), []string{
+		"Enabled",
+		"Disabled",
+	})
+}
+
+// Whether to keep gathering candidates as networks change, after gathering
+// first completes.
+const (
+	GatheringPolicyOnce GatheringPolicy = iota
+	GatheringPolicyContinually
+)
+
+func (p GatheringPolicy) String() string {
+	return EnumToStringSafe(int(p), []string{
+		"Once",
+		"Continually",
+	})
+}
+
+// Only passwords are supported by libwebrtc.
+const (
 	IceCredentialTypePassword IceCredentialType = iota
-	IceCredentialTypeToken
-) */
+	IceCredentialTypeOauth
+)
+
+func (t IceCredentialType) String() string {
+	return EnumToStringSafe(int(t), []string{
+		"password",
+		"oauth",
+	})
+}
+
+// How the certificates of turns: servers are checked. There is no way to add
+// custom root certificates in this version of libwebrtc, so servers either
+// need a certificate trusted by the system, or no checking at all.
+const (
+	TlsCertPolicySecure TlsCertPolicy = iota
+	TlsCertPolicyInsecureNoCheck
+)
+
+func (p TlsCertPolicy) String() string {
+	return EnumToStringSafe(int(p), []string{
+		"Secure",
+		"InsecureNoCheck",
+	})
+}
 
 type IceServer struct {
-	Urls       []string // The only "required" element.
-	Username   string
-	Credential string
-	// [ED] CredentialType IceCredentialType
+	Urls           []string // The only "required" element.
+	Username       string
+	Credential     string
+	CredentialType IceCredentialType
+	// For turns: servers. The default, TlsCertPolicySecure, rejects
+	// self-signed certificates.
+	TlsCertPolicy TlsCertPolicy
+	// The name to check the certificate against, and to send with SNI, for
+	// turns: servers whose urls only contain IP addresses.
+	Hostname string
+	// If set, replaces Username and Credential with its own, which are
+	// refreshed before they expire on each PeerConnection using this server.
+	CredentialProvider CredentialProvider
 }
 
 // Create a new IceServer object.
 // Expects anywhere from one to three strings, in this order:
-// - comma-separated list of urls.
+// - comma-separated list of urls, each checked by ParseIceServerURL.
 // - username
 // - credential
-// TODO: For the ED version, may need to support CredentialType.
+// The urls are kept as given, so that libwebrtc sees what the caller passed.
 func NewIceServer(params ...string) (*IceServer, error) {
 	if len(params) < 1 {
 		return nil, errors.New("IceServer: missing first comma-separated Urls string.")
@@ -133,15 +290,11 @@ func NewIceServer(params ...string) (*IceServer, error) {
 	username := ""
 	credential := ""
 	for i, url := range urls {
-		url = strings.TrimSpace(url)
-		// TODO: Better url validation.
-		if !strings.HasPrefix(url, "stun:") &&
-			!strings.HasPrefix(url, "turn:") {
-			msg := fmt.Sprintf("IceServer: received malformed url: <%s>", url)
-			ERROR.Println(msg)
-			return nil, errors.New(msg)
+		urls[i] = strings.TrimSpace(url)
+		if _, err := ParseIceServerURL(urls[i]); nil != err {
+			ERROR.Println("IceServer: received malformed url:", err)
+			return nil, err
 		}
-		urls[i] = url
 	}
 	if len(params) > 1 {
 		username = params[1]
@@ -163,14 +316,15 @@ func NewConfiguration(options ...ConfigurationOption) *Configuration {
 	c := new(Configuration)
 	c.IceTransportPolicy = IceTransportPolicyAll
 	c.BundlePolicy = BundlePolicyBalanced
+	c.RtcpMuxPolicy = RtcpMuxPolicyRequire
+	c.SdpSemantics = SdpSemanticsDefault
+	c.NetworkIgnoreMask = NetworkTypeLoopback
 	for _, op := range options {
 		err := op(c)
 		if nil != err {
 			ERROR.Println(err)
 		}
 	}
-	// [ED] c.RtcpMuxPolicy = RtcpMuxPolicyRequire
-	// [ED] c.Certificates = make([]string, 0)
 	INFO.Println("Created Configuration at ", c)
 	// TODO: Determine whether the below is true.
 	// if 0 == len(c.IceServers) {
@@ -189,6 +343,24 @@ func OptionIceServer(params ...string) ConfigurationOption {
 	}
 }
 
+// OptionIceServerCredentials adds an IceServer for the comma-separated |urls|,
+// whose credentials come from |provider|.
+func OptionIceServerCredentials(urls string,
+	provider CredentialProvider) ConfigurationOption {
+	return func(config *Configuration) error {
+		if nil == provider {
+			return errors.New("OptionIceServerCredentials: nil CredentialProvider")
+		}
+		server, err := NewIceServer(urls)
+		if nil != err {
+			return err
+		}
+		server.CredentialProvider = provider
+		config.IceServers = append(config.IceServers, *server)
+		return nil
+	}
+}
+
 func OptionIceTransportPolicy(policy IceTransportPolicy) ConfigurationOption {
 	return func(config *Configuration) error {
 		INFO.Println("OptionIceTransportPolicy: ", policy)
@@ -204,6 +376,103 @@ func OptionBundlePolicy(policy BundlePolicy) ConfigurationOption {
 	}
 }
 
+func OptionRtcpMuxPolicy(policy RtcpMuxPolicy) ConfigurationOption {
+	return func(config *Configuration) error {
+		config.RtcpMuxPolicy = policy
+		return nil
+	}
+}
+
+func OptionSdpSemantics(semantics SdpSemantics) ConfigurationOption {
+	return func(config *Configuration) error {
+		config.SdpSemantics = semantics
+		return nil
+	}
+}
+
+func OptionIceCandidatePoolSize(size int) ConfigurationOption {
+	return func(config *Configuration) error {
+		if size < 0 || size > maxIceCandidatePoolSize {
+			return fmt.Errorf("OptionIceCandidatePoolSize: %d not in [0, %d]",
+				size, maxIceCandidatePoolSize)
+		}
+		config.IceCandidatePoolSize = size
+		return nil
+	}
+}
+
+const maxIceCandidatePoolSize = 255
+
+// OptionPortRange restricts local candidates to ports from |min| to |max|.
+func OptionPortRange(min, max int) ConfigurationOption {
+	return func(config *Configuration) error {
+		if min < 1 || max > 0xffff || min > max {
+			return fmt.Errorf("OptionPortRange: invalid range %d-%d", min, max)
+		}
+		config.MinPort = min
+		config.MaxPort = max
+		return nil
+	}
+}
+
+// OptionNetworkIgnoreMask replaces the types of network to leave out, for
+// example NetworkTypeLoopback | NetworkTypeVpn | NetworkTypeCellular, or
+// NetworkTypeNone to gather on every network, loopback included.
+func OptionNetworkIgnoreMask(mask NetworkType) ConfigurationOption {
+	return func(config *Configuration) error {
+		config.NetworkIgnoreMask = mask
+		return nil
+	}
+}
+
+// OptionIgnoreInterfaces leaves out the network interfaces named |names|.
+func OptionIgnoreInterfaces(names ...string) ConfigurationOption {
+	return func(config *Configuration) error {
+		config.IgnoredInterfaces = append(config.IgnoredInterfaces, names...)
+		return nil
+	}
+}
+
+// OptionLocalAddresses restricts gathering to the local IP addresses and
+// interface names in |addrsOrNames|.
+func OptionLocalAddresses(addrsOrNames ...string) ConfigurationOption {
+	return func(config *Configuration) error {
+		config.LocalAddresses = append(config.LocalAddresses, addrsOrNames...)
+		return nil
+	}
+}
+
+func OptionDisableIPv6(disable bool) ConfigurationOption {
+	return func(config *Configuration) error {
+		config.DisableIPv6 = disable
+		return nil
+	}
+}
+
+func OptionTcpCandidatePolicy(policy TcpCandidatePolicy) ConfigurationOption {
+	return func(config *Configuration) error {
+		config.TcpCandidatePolicy = policy
+		return nil
+	}
+}
+
+func OptionGatheringPolicy(policy GatheringPolicy) ConfigurationOption {
+	return func(config *Configuration) error {
+		config.GatheringPolicy = policy
+		return nil
+	}
+}
+
+func OptionCertificate(certificate *Certificate) ConfigurationOption {
+	return func(config *Configuration) error {
+		if nil == certificate {
+			return errors.New("OptionCertificate: nil Certificate")
+		}
+		config.Certificates = append(config.Certificates, *certificate)
+		return nil
+	}
+}
+
 func (config *Configuration) AddIceServer(params ...string) error {
 	server, err := NewIceServer(params...)
 	if nil != err {
@@ -213,6 +482,63 @@ func (config *Configuration) AddIceServer(params ...string) error {
 	return nil
 }
 
+// The NetworkIgnoreMask to pass to libwebrtc, with the loopback default
+// filled in for a Configuration which didn't set one.
+func (config *Configuration) networkIgnoreMask() NetworkType {
+	if 0 == config.NetworkIgnoreMask {
+		return NetworkTypeLoopback
+	}
+	return config.NetworkIgnoreMask &^ NetworkTypeNone
+}
+
+// The name of the first field of |next| which differs from |config| but can't
+// be changed once the PeerConnection is created, or "" if there is none.
+// DisableIPv6 and the rest libwebrtc itself keeps fixed are left to it.
+func (config *Configuration) fixedFieldChanged(next *Configuration) string {
+	switch {
+	case config.MinPort != next.MinPort || config.MaxPort != next.MaxPort:
+		return "MinPort and MaxPort"
+	case config.networkIgnoreMask() != next.networkIgnoreMask():
+		return "NetworkIgnoreMask"
+	case !sameStrings(config.IgnoredInterfaces, next.IgnoredInterfaces):
+		return "IgnoredInterfaces"
+	case !sameStrings(config.LocalAddresses, next.LocalAddresses):
+		return "LocalAddresses"
+	case config.Proxy != next.Proxy:
+		return "Proxy"
+	case !sameTransport(config.Transport, next.Transport):
+		return "Transport"
+	}
+	return ""
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Compares Transports without panicking on those of an uncomparable type.
+func sameTransport(a, b Transport) bool {
+	if nil == a || nil == b {
+		return a == b
+	}
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) {
+		return false
+	}
+	if !t.Comparable() {
+		return reflect.DeepEqual(a, b)
+	}
+	return a == b
+}
+
 // Helpers which prepare Go-side of cast to eventual C++ Configuration struct.
 func (server *IceServer) _CGO() C.CGO_IceServer {
 	cServer := new(C.CGO_IceServer)
@@ -231,6 +557,8 @@ func (server *IceServer) _CGO() C.CGO_IceServer {
 	cServer.numUrls = C.int(total)
 	cServer.username = C.CString(server.Username)
 	cServer.credential = C.CString(server.Credential)
+	cServer.tlsCertPolicy = C.int(server.TlsCertPolicy)
+	cServer.hostname = C.CString(server.Hostname)
 	return *cServer
 }
 
@@ -247,6 +575,7 @@ func freeIceServer(cServer C.CGO_IceServer) {
 	}
 	C.free(unsafe.Pointer(cServer.username))
 	C.free(unsafe.Pointer(cServer.credential))
+	C.free(unsafe.Pointer(cServer.hostname))
 	C.free(unsafe.Pointer(cServer.urls))
 }
 
@@ -272,14 +601,65 @@ func (config *Configuration) _CGO() *C.CGO_Configuration {
 	// c.iceServers = (*C.CGO_IceServer)(unsafe.Pointer(&config.IceServers))
 	c.iceTransportPolicy = C.int(config.IceTransportPolicy)
 	c.bundlePolicy = C.int(config.BundlePolicy)
-	// [ED] c.RtcpMuxPolicy = C.int(config.RtcpMuxPolicy)
+	c.rtcpMuxPolicy = C.int(config.RtcpMuxPolicy)
+	c.sdpSemantics = C.int(config.SdpSemantics)
 	c.peerIdentity = C.CString(config.PeerIdentity)
-	// [ED] c.Certificates = config.Certificates
-	// [ED] c.IceCandidatePoolSize = C.int(config.IceCandidatePoolSize)
+
+	total = len(config.Certificates)
+	if total > 0 {
+		sizeof := unsafe.Sizeof(C.CGO_Certificate{})
+		cCerts := unsafe.Pointer(C.malloc(C.size_t(sizeof * uintptr(total))))
+		for i, cert := range config.Certificates {
+			*(*C.CGO_Certificate)(unsafe.Pointer(uintptr(cCerts) + sizeof*uintptr(i))) = cert._CGO()
+		}
+		c.certificates = (*C.CGO_Certificate)(cCerts)
+	}
+	c.numCertificates = C.int(total)
+	c.iceCandidatePoolSize = C.int(config.IceCandidatePoolSize)
+
+	c.minPort = C.int(config.MinPort)
+	c.maxPort = C.int(config.MaxPort)
+	c.networkIgnoreMask = C.int(config.networkIgnoreMask())
+	c.ignoredInterfaces, c.numIgnoredInterfaces = cStrings(config.IgnoredInterfaces)
+	c.localAddresses, c.numLocalAddresses = cStrings(config.LocalAddresses)
+	c.proxy = config.Proxy._CGO()
+	// Only NewPeerConnection passes on the Transport.
+	c.transport = 0
+	c.transportAddresses, c.numTransportAddresses = nil, 0
+	c.disableIPv6 = C.int(boolToInt(config.DisableIPv6))
+	c.tcpCandidatePolicy = C.int(config.TcpCandidatePolicy)
+	c.continualGatheringPolicy = C.int(config.GatheringPolicy)
 	return c
 }
 
 const maxIceServers = 1 << 24
+const maxCertificates = 1 << 16
+
+// Copy |strs| into a malloc'd C array, to be freed with freeCStrings.
+func cStrings(strs []string) (**C.char, C.int) {
+	if 0 == len(strs) {
+		return nil, 0
+	}
+	sizeof := unsafe.Sizeof(uintptr(0))
+	cStrs := unsafe.Pointer(C.malloc(C.size_t(sizeof * uintptr(len(strs)))))
+	for i, s := range strs {
+		*(**C.char)(unsafe.Pointer(uintptr(cStrs) + sizeof*uintptr(i))) = C.CString(s)
+	}
+	return (**C.char)(cStrs), C.int(len(strs))
+}
+
+func freeCStrings(cStrs **C.char, num C.int) {
+	total := int(num)
+	if total > maxUrls {
+		panic("Too many strings. Something went wrong.")
+	} else if total > 0 {
+		strs := (*[maxUrls](*C.char))(unsafe.Pointer(cStrs))
+		for i := 0; i < total; i++ {
+			C.free(unsafe.Pointer(strs[i]))
+		}
+		C.free(unsafe.Pointer(cStrs))
+	}
+}
 
 func freeConfig(cConfig *C.CGO_Configuration) {
 	total := int(cConfig.numIceServers)
@@ -292,6 +672,20 @@ func freeConfig(cConfig *C.CGO_Configuration) {
 		}
 		C.free(unsafe.Pointer(cConfig.iceServers))
 	}
+	total = int(cConfig.numCertificates)
+	if total > maxCertificates {
+		panic("Too many certificates. Something went wrong.")
+	} else if total > 0 {
+		cCerts := (*[maxCertificates]C.CGO_Certificate)(unsafe.Pointer(cConfig.certificates))
+		for i := 0; i < total; i++ {
+			freeCertificate(cCerts[i])
+		}
+		C.free(unsafe.Pointer(cConfig.certificates))
+	}
+	freeCStrings(cConfig.ignoredInterfaces, cConfig.numIgnoredInterfaces)
+	freeCStrings(cConfig.localAddresses, cConfig.numLocalAddresses)
+	freeCStrings(cConfig.transportAddresses, cConfig.numTransportAddresses)
+	freeProxy(cConfig.proxy)
 	C.free(unsafe.Pointer(cConfig.peerIdentity))
 	C.free(unsafe.Pointer(cConfig))
 }
@@ -322,9 +716,27 @@ var _cgoBundlePolicyBalanced = int(C.CGO_BundlePolicyBalanced)
 var _cgoBundlePolicyMaxCompat = int(C.CGO_BundlePolicyMaxCompat)
 var _cgoBundlePolicyMaxBundle = int(C.CGO_BundlePolicyMaxBundle)
 
-// [ED]
-// var _cgoRtcpMuxPolicyNegotiate = int(C.CGO_RtcpMuxPolicyNegotiate)
-// var _cgoRtcpMuxPolicyRequire = int(C.CGO_RtcpMuxPolicyRequire)
+var _cgoRtcpMuxPolicyNegotiate = int(C.CGO_RtcpMuxPolicyNegotiate)
+var _cgoRtcpMuxPolicyRequire = int(C.CGO_RtcpMuxPolicyRequire)
+
+var _cgoSdpSemanticsDefault = int(C.CGO_SdpSemanticsDefault)
+var _cgoSdpSemanticsPlanB = int(C.CGO_SdpSemanticsPlanB)
+var _cgoSdpSemanticsUnifiedPlan = int(C.CGO_SdpSemanticsUnifiedPlan)
+
+var _cgoTlsCertPolicySecure = int(C.CGO_TlsCertPolicySecure)
+var _cgoTlsCertPolicyInsecureNoCheck = int(C.CGO_TlsCertPolicyInsecureNoCheck)
+
+var _cgoNetworkTypeEthernet = int(C.CGO_NetworkTypeEthernet)
+var _cgoNetworkTypeWifi = int(C.CGO_NetworkTypeWifi)
+var _cgoNetworkTypeCellular = int(C.CGO_NetworkTypeCellular)
+var _cgoNetworkTypeVpn = int(C.CGO_NetworkTypeVpn)
+var _cgoNetworkTypeLoopback = int(C.CGO_NetworkTypeLoopback)
+
+var _cgoTcpCandidatePolicyEnabled = int(C.CGO_TcpCandidatePolicyEnabled)
+var _cgoTcpCandidatePolicyDisabled = int(C.CGO_TcpCandidatePolicyDisabled)
+
+var _cgoGatheringPolicyOnce = int(C.CGO_GatheringPolicyOnce)
+var _cgoGatheringPolicyContinually = int(C.CGO_GatheringPolicyContinually)
 
 var _cgoSignalingStateStable = int(C.CGO_SignalingStateStable)
 var _cgoSignalingStateHaveLocalOffer = int(C.CGO_SignalingStateHaveLocalOffer)
@@ -1,8 +1,20 @@
 package webrtc
 
 import (
-	. "github.com/smartystreets/goconvey/convey"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
 	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
 )
 
 func TestConfiguration(t *testing.T) {
@@ -33,11 +45,42 @@ func TestConfiguration(t *testing.T) {
 			So(SignalingStateClosed, ShouldEqual, _cgoSignalingStateClosed)
 		})
 
-		// TODO: [ED]
-		// SkipConvey("Enum: RtcpMuxPolicy", func() {
-		// So(RtcpMuxPolicyNegotiate, ShouldEqual, _cgoRtcpMuxPolicyNegotiate)
-		// So(RtcpMuxPolicyRequire, ShouldEqual, _cgoRtcpMuxPolicyRequire)
-		// })
+		Convey("Enum: RtcpMuxPolicy", func() {
+			So(RtcpMuxPolicyNegotiate, ShouldEqual, _cgoRtcpMuxPolicyNegotiate)
+			So(RtcpMuxPolicyRequire, ShouldEqual, _cgoRtcpMuxPolicyRequire)
+		})
+
+		Convey("Enum: SdpSemantics", func() {
+			So(SdpSemanticsDefault, ShouldEqual, _cgoSdpSemanticsDefault)
+			So(SdpSemanticsPlanB, ShouldEqual, _cgoSdpSemanticsPlanB)
+			So(SdpSemanticsUnifiedPlan, ShouldEqual, _cgoSdpSemanticsUnifiedPlan)
+		})
+
+		Convey("Enum: TlsCertPolicy", func() {
+			So(TlsCertPolicySecure, ShouldEqual, _cgoTlsCertPolicySecure)
+			So(TlsCertPolicyInsecureNoCheck, ShouldEqual, _cgoTlsCertPolicyInsecureNoCheck)
+		})
+
+		Convey("Enum: NetworkType", func() {
+			So(NetworkTypeEthernet, ShouldEqual, _cgoNetworkTypeEthernet)
+			So(NetworkTypeWifi, ShouldEqual, _cgoNetworkTypeWifi)
+			So(NetworkTypeCellular, ShouldEqual, _cgoNetworkTypeCellular)
+			So(NetworkTypeVpn, ShouldEqual, _cgoNetworkTypeVpn)
+			So(NetworkTypeLoopback, ShouldEqual, _cgoNetworkTypeLoopback)
+			So((NetworkTypeVpn | NetworkTypeLoopback).String(), ShouldEqual, "Vpn|Loopback")
+			So(NetworkType(0).String(), ShouldEqual, "Default")
+			So(NetworkTypeNone.String(), ShouldEqual, "None")
+		})
+
+		Convey("Enum: TcpCandidatePolicy", func() {
+			So(TcpCandidatePolicyEnabled, ShouldEqual, _cgoTcpCandidatePolicyEnabled)
+			So(TcpCandidatePolicyDisabled, ShouldEqual, _cgoTcpCandidatePolicyDisabled)
+		})
+
+		Convey("Enum: GatheringPolicy", func() {
+			So(GatheringPolicyOnce, ShouldEqual, _cgoGatheringPolicyOnce)
+			So(GatheringPolicyContinually, ShouldEqual, _cgoGatheringPolicyContinually)
+		})
 
 	}) // Enums
 
@@ -96,5 +139,132 @@ func TestConfiguration(t *testing.T) {
 			OptionIceServer("stun:d"),
 			OptionBundlePolicy(BundlePolicyMaxCompat))
 		So(config.BundlePolicy, ShouldEqual, BundlePolicyMaxCompat)
+
+		config = NewConfiguration()
+		So(config.RtcpMuxPolicy, ShouldEqual, RtcpMuxPolicyRequire)
+		So(config.SdpSemantics, ShouldEqual, SdpSemanticsDefault)
+		config = NewConfiguration(
+			OptionRtcpMuxPolicy(RtcpMuxPolicyNegotiate),
+			OptionSdpSemantics(SdpSemanticsUnifiedPlan))
+		So(config.RtcpMuxPolicy, ShouldEqual, RtcpMuxPolicyNegotiate)
+		So(config.SdpSemantics, ShouldEqual, SdpSemanticsUnifiedPlan)
+
+		config = NewConfiguration(OptionIceCandidatePoolSize(4))
+		So(config.IceCandidatePoolSize, ShouldEqual, 4)
+		// Out of range sizes are ignored.
+		config = NewConfiguration(OptionIceCandidatePoolSize(256))
+		So(config.IceCandidatePoolSize, ShouldEqual, 0)
+		So(OptionIceCandidatePoolSize(-1)(config), ShouldNotBeNil)
+
+		config = NewConfiguration()
+		So(config.NetworkIgnoreMask, ShouldEqual, NetworkTypeLoopback)
+		config = NewConfiguration(
+			OptionPortRange(40000, 40100),
+			OptionNetworkIgnoreMask(NetworkTypeLoopback|NetworkTypeVpn),
+			OptionIgnoreInterfaces("docker0", "br-1234"),
+			OptionLocalAddresses("10.0.0.5", "wg0"),
+			OptionDisableIPv6(true),
+			OptionTcpCandidatePolicy(TcpCandidatePolicyDisabled),
+			OptionGatheringPolicy(GatheringPolicyContinually))
+		So(config.MinPort, ShouldEqual, 40000)
+		So(config.MaxPort, ShouldEqual, 40100)
+		So(config.NetworkIgnoreMask, ShouldEqual, NetworkTypeLoopback|NetworkTypeVpn)
+		So(config.IgnoredInterfaces, ShouldResemble, []string{"docker0", "br-1234"})
+		So(config.LocalAddresses, ShouldResemble, []string{"10.0.0.5", "wg0"})
+		So(config.DisableIPv6, ShouldBeTrue)
+		So(config.TcpCandidatePolicy, ShouldEqual, TcpCandidatePolicyDisabled)
+		So(config.GatheringPolicy, ShouldEqual, GatheringPolicyContinually)
+		So(OptionPortRange(2000, 1000)(config), ShouldNotBeNil)
+		So(OptionPortRange(0, 1000)(config), ShouldNotBeNil)
+		So(OptionPortRange(1000, 70000)(config), ShouldNotBeNil)
+	})
+
+	Convey("TlsCertPolicy against a self-signed turns: server", t, func() {
+		for policy, expected := range map[TlsCertPolicy]string{
+			TlsCertPolicySecure:          "rejected",
+			TlsCertPolicyInsecureNoCheck: "allocate",
+		} {
+			// A fresh server each time, as clients may retry.
+			listener, results := turnsStandIn(t)
+			url := "turns:" + listener.Addr().String() + "?transport=tcp"
+			server, err := NewIceServer(url, "user", "secret")
+			So(err, ShouldBeNil)
+			server.TlsCertPolicy = policy
+			config := NewConfiguration(
+				OptionIceTransportPolicy(IceTransportPolicyRelay))
+			config.IceServers = []IceServer{*server}
+			pc, err := NewPeerConnection(config)
+			So(err, ShouldBeNil)
+			channel, err := pc.CreateDataChannel("turns")
+			So(err, ShouldBeNil)
+			offer, err := pc.CreateOffer()
+			So(err, ShouldBeNil)
+			So(pc.SetLocalDescription(offer), ShouldBeNil)
+			select {
+			case result := <-results:
+				So(result, ShouldEqual, expected)
+			case <-time.After(10 * time.Second):
+				t.Fatal("Timed out waiting for", policy)
+			}
+			pc.DeleteDataChannel(channel)
+			So(pc.Destroy(), ShouldBeNil)
+			listener.Close()
+		}
+	})
+}
+
+// A stand-in for a TURN server over TLS, with a self-signed certificate. It
+// reports "rejected" for each client which fails the TLS handshake, and
+// "allocate" for each which goes on to send a TURN Allocate request.
+func turnsStandIn(t *testing.T) (net.Listener, chan string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != err {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "turns.invalid"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if nil != err {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
 	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	results := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if nil != err {
+				return
+			}
+			go func(conn *tls.Conn) {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				if err := conn.Handshake(); nil != err {
+					results <- "rejected"
+					return
+				}
+				// A STUN header: type, length, then the magic cookie.
+				header := make([]byte, 20)
+				if _, err := io.ReadFull(conn, header); nil != err {
+					results <- "rejected"
+					return
+				}
+				if 0x0003 == binary.BigEndian.Uint16(header[0:2]) &&
+					0x2112A442 == binary.BigEndian.Uint32(header[4:8]) {
+					results <- "allocate"
+				}
+			}(conn.(*tls.Conn))
+		}
+	}()
+	return listener, results
 }
@@ -0,0 +1,147 @@
+package webrtc
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Credentials for an IceServer, as supplied by a CredentialProvider.
+type Credentials struct {
+	Username   string
+	Credential string
+	// When the credentials stop being accepted. Zero if they never expire.
+	Expires time.Time
+}
+
+// CredentialProvider supplies the credentials of an IceServer, typically for
+// TURN servers which only accept time-limited credentials.
+//
+// Credentials is called when a PeerConnection is created or configured, and
+// again before the previous credentials expire. It may be called from any
+// goroutine.
+type CredentialProvider interface {
+	Credentials() (Credentials, error)
+}
+
+/*
+TurnRestCredentialProvider generates time-limited credentials for TURN servers
+sharing |secret| with the application, following the "TURN REST API" scheme
+which coturn and most other TURN servers implement:
+
+	username   = <expiry UNIX timestamp>[:<user>]
+	credential = base64(HMAC-SHA1(secret, username))
+
+See: https://tools.ietf.org/html/draft-uberti-behave-turn-rest-00
+*/
+type TurnRestCredentialProvider struct {
+	secret []byte
+	user   string
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewTurnRestCredentialProvider returns a provider of credentials valid for
+// |ttl|. |user| is optional, and only identifies the user to the TURN server.
+func NewTurnRestCredentialProvider(secret string, user string,
+	ttl time.Duration) *TurnRestCredentialProvider {
+	return &TurnRestCredentialProvider{
+		secret: []byte(secret),
+		user:   user,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+func (p *TurnRestCredentialProvider) Credentials() (Credentials, error) {
+	if p.ttl < time.Second {
+		return Credentials{}, fmt.Errorf(
+			"TurnRestCredentialProvider: ttl %v is under a second", p.ttl)
+	}
+	expires := p.now().Add(p.ttl).Truncate(time.Second)
+	username := strconv.FormatInt(expires.Unix(), 10)
+	if "" != p.user {
+		username += ":" + p.user
+	}
+	mac := hmac.New(sha1.New, p.secret)
+	mac.Write([]byte(username))
+	return Credentials{
+		Username:   username,
+		Credential: base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		Expires:    expires,
+	}, nil
+}
+
+// Credentials are refreshed once this fraction of their remaining lifetime has
+// passed, and retried at this interval if that fails.
+const (
+	credentialRefreshFraction = 0.8
+	credentialRetryInterval   = 10 * time.Second
+)
+
+// Fill in the Username and Credential of each of the |config|'s IceServers
+// which has a CredentialProvider. Returns a copy of |config| for the native
+// code, and the earliest time any of the credentials expire.
+func resolveCredentials(op string, config Configuration) (
+	Configuration, time.Time, error) {
+	var expires time.Time
+	servers := make([]IceServer, len(config.IceServers))
+	for i, server := range config.IceServers {
+		if IceCredentialTypePassword != server.CredentialType {
+			return config, expires, &Error{ErrorTypeUnsupportedParameter, op,
+				fmt.Sprintf("unsupported credential type %s", server.CredentialType)}
+		}
+		if nil != server.CredentialProvider {
+			c, err := server.CredentialProvider.Credentials()
+			if nil != err {
+				return config, expires, &Error{ErrorTypeInvalidParameter, op,
+					err.Error()}
+			}
+			server.Username = c.Username
+			server.Credential = c.Credential
+			if !c.Expires.IsZero() &&
+				(expires.IsZero() || c.Expires.Before(expires)) {
+				expires = c.Expires
+			}
+		}
+		servers[i] = server
+	}
+	config.IceServers = servers
+	return config, expires, nil
+}
+
+// Arrange to refresh the credentials before |expires|, replacing any earlier
+// arrangement. Must be called with |configLock| held.
+func (pc *PeerConnection) scheduleCredentialRefresh(expires time.Time) {
+	if nil != pc.credentialTimer {
+		pc.credentialTimer.Stop()
+		pc.credentialTimer = nil
+	}
+	if expires.IsZero() {
+		return
+	}
+	delay := time.Duration(float64(time.Until(expires)) * credentialRefreshFraction)
+	if delay < time.Second {
+		delay = time.Second
+	}
+	pc.credentialTimer = time.AfterFunc(delay, pc.refreshCredentials)
+}
+
+// Reapply the current Configuration, which fetches new credentials from the
+// CredentialProviders.
+func (pc *PeerConnection) refreshCredentials() {
+	pc.configLock.Lock()
+	defer pc.configLock.Unlock()
+	if PeerConnectionStateClosed == pc.ConnectionState() {
+		return
+	}
+	INFO.Println("Refreshing ICE server credentials.")
+	if err := pc.setConfiguration(pc.config); nil != err {
+		WARN.Println("Failed to refresh ICE server credentials:", err)
+		pc.credentialTimer = time.AfterFunc(credentialRetryInterval,
+			pc.refreshCredentials)
+	}
+}
@@ -0,0 +1,111 @@
+package webrtc
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// Hands out numbered credentials which expire after |ttl|.
+type countingProvider struct {
+	calls int32
+	ttl   time.Duration
+}
+
+func (p *countingProvider) Credentials() (Credentials, error) {
+	n := atomic.AddInt32(&p.calls, 1)
+	return Credentials{
+		Username:   "user",
+		Credential: string('0' + n),
+		Expires:    time.Now().Add(p.ttl),
+	}, nil
+}
+
+type failingProvider struct{}
+
+func (failingProvider) Credentials() (Credentials, error) {
+	return Credentials{}, errors.New("unavailable")
+}
+
+func TestCredentials(t *testing.T) {
+	SetLoggingVerbosity(0)
+
+	Convey("Credentials", t, func() {
+
+		Convey("IceCredentialType", func() {
+			So(IceCredentialTypePassword.String(), ShouldEqual, "password")
+			So(IceCredentialTypeOauth.String(), ShouldEqual, "oauth")
+		})
+
+		Convey("TURN REST API credentials", func() {
+			p := NewTurnRestCredentialProvider("north", "alice", time.Hour)
+			p.now = func() time.Time { return time.Unix(1700000000, 0) }
+			c, err := p.Credentials()
+			So(err, ShouldBeNil)
+			So(c.Username, ShouldEqual, "1700003600:alice")
+			So(c.Credential, ShouldEqual, "wjwSXO2ch1B6VaLTLMy2Avn5O9o=")
+			So(c.Expires.Unix(), ShouldEqual, 1700003600)
+
+			p = NewTurnRestCredentialProvider("north", "", time.Hour)
+			p.now = func() time.Time { return time.Unix(1700000000, 0) }
+			c, err = p.Credentials()
+			So(err, ShouldBeNil)
+			So(c.Username, ShouldEqual, "1700003600")
+			So(c.Credential, ShouldEqual, "xF4I6gruVt/PjGbjajGu2UlidPk=")
+
+			p = NewTurnRestCredentialProvider("north", "alice", 0)
+			_, err = p.Credentials()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Resolve into the configuration", func() {
+			provider := &countingProvider{ttl: time.Hour}
+			config := NewConfiguration(
+				OptionIceServer("stun:a"),
+				OptionIceServerCredentials("turn:b", provider))
+			So(len(config.IceServers), ShouldEqual, 2)
+			resolved, expires, err := resolveCredentials("Test", *config)
+			So(err, ShouldBeNil)
+			So(expires, ShouldHappenWithin, time.Minute, time.Now().Add(time.Hour))
+			So(resolved.IceServers[1].Username, ShouldEqual, "user")
+			So(resolved.IceServers[1].Credential, ShouldEqual, "1")
+			// The original keeps only the provider.
+			So(config.IceServers[1].Username, ShouldEqual, "")
+
+			config.IceServers[0].CredentialType = IceCredentialTypeOauth
+			_, _, err = resolveCredentials("Test", *config)
+			So(errors.Is(err, ErrUnsupportedParameter), ShouldBeTrue)
+
+			config = NewConfiguration(
+				OptionIceServerCredentials("turn:b", failingProvider{}))
+			_, err = NewPeerConnection(config)
+			So(errors.Is(err, ErrInvalidParameter), ShouldBeTrue)
+		})
+
+		Convey("Refresh before expiry on live PeerConnections", func() {
+			provider := &countingProvider{ttl: 1500 * time.Millisecond}
+			config := NewConfiguration(
+				OptionIceServerCredentials("turn:127.0.0.1", provider))
+			pc, err := NewPeerConnection(config)
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&provider.calls), ShouldEqual, 1)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for atomic.LoadInt32(&provider.calls) < 3 && time.Now().Before(deadline) {
+				time.Sleep(100 * time.Millisecond)
+			}
+			So(atomic.LoadInt32(&provider.calls), ShouldBeGreaterThanOrEqualTo, 3)
+			So(pc.GetConfiguration().IceServers[0].CredentialProvider,
+				ShouldEqual, provider)
+
+			// No more refreshes once closed.
+			So(pc.Destroy(), ShouldBeNil)
+			calls := atomic.LoadInt32(&provider.calls)
+			time.Sleep(2 * time.Second)
+			So(atomic.LoadInt32(&provider.calls), ShouldEqual, calls)
+		})
+	})
+}
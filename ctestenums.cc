@@ -1,5 +1,10 @@
 #include "ctestenums.h"
 #include "api/peerconnectioninterface.h"
+#include "api/rtcerror.h"
+#include "rtc_base/network_constants.h"
+#include "rtc_base/proxyinfo.h"
+#include "rtc_base/sslidentity.h"
+#include "rtc_base/sslstreamadapter.h"
 
 using namespace webrtc;
 
@@ -24,11 +29,39 @@ const int CGO_BundlePolicyMaxBundle =
 const int CGO_BundlePolicyMaxCompat =
     PeerConnectionInterface::BundlePolicy::kBundlePolicyMaxCompat;
 
-// TODO: [ED]
-// const int CGO_RtcpMuxPolicyNegotiate =
-    // PeerConnectionInterface::RtcpMuxPolicy::kRtcpMuxPolicyNegotiate;
-// const int CGO_RtcpMuxPolicyRequire =
-    // PeerConnectionInterface::RtcpMuxPolicy::kRtcpMuxPolicyRequire;
+const int CGO_RtcpMuxPolicyNegotiate =
+    PeerConnectionInterface::RtcpMuxPolicy::kRtcpMuxPolicyNegotiate;
+const int CGO_RtcpMuxPolicyRequire =
+    PeerConnectionInterface::RtcpMuxPolicy::kRtcpMuxPolicyRequire;
+
+const int CGO_SdpSemanticsDefault = (int)SdpSemantics::kDefault;
+const int CGO_SdpSemanticsPlanB = (int)SdpSemantics::kPlanB;
+const int CGO_SdpSemanticsUnifiedPlan = (int)SdpSemantics::kUnifiedPlan;
+
+const int CGO_TlsCertPolicySecure =
+    PeerConnectionInterface::TlsCertPolicy::kTlsCertPolicySecure;
+const int CGO_TlsCertPolicyInsecureNoCheck =
+    PeerConnectionInterface::TlsCertPolicy::kTlsCertPolicyInsecureNoCheck;
+
+const int CGO_NetworkTypeEthernet = rtc::ADAPTER_TYPE_ETHERNET;
+const int CGO_NetworkTypeWifi = rtc::ADAPTER_TYPE_WIFI;
+const int CGO_NetworkTypeCellular = rtc::ADAPTER_TYPE_CELLULAR;
+const int CGO_NetworkTypeVpn = rtc::ADAPTER_TYPE_VPN;
+const int CGO_NetworkTypeLoopback = rtc::ADAPTER_TYPE_LOOPBACK;
+
+const int CGO_TcpCandidatePolicyEnabled =
+    PeerConnectionInterface::TcpCandidatePolicy::kTcpCandidatePolicyEnabled;
+const int CGO_TcpCandidatePolicyDisabled =
+    PeerConnectionInterface::TcpCandidatePolicy::kTcpCandidatePolicyDisabled;
+
+const int CGO_GatheringPolicyOnce =
+    PeerConnectionInterface::ContinualGatheringPolicy::GATHER_ONCE;
+const int CGO_GatheringPolicyContinually =
+    PeerConnectionInterface::ContinualGatheringPolicy::GATHER_CONTINUALLY;
+
+const int CGO_ProxyTypeNone = rtc::PROXY_NONE;
+const int CGO_ProxyTypeHttps = rtc::PROXY_HTTPS;
+const int CGO_ProxyTypeSocks5 = rtc::PROXY_SOCKS5;
 
 const int CGO_SignalingStateStable =
     PeerConnectionInterface::SignalingState::kStable;
@@ -64,3 +97,32 @@ const int CGO_IceGatheringStateGathering =
     PeerConnectionInterface::IceGatheringState::kIceGatheringGathering;
 const int CGO_IceGatheringStateComplete =
     PeerConnectionInterface::IceGatheringState::kIceGatheringComplete;
+
+const int CGO_ErrorTypeNone =
+    (int)RTCErrorType::NONE;
+const int CGO_ErrorTypeUnsupportedOperation =
+    (int)RTCErrorType::UNSUPPORTED_OPERATION;
+const int CGO_ErrorTypeUnsupportedParameter =
+    (int)RTCErrorType::UNSUPPORTED_PARAMETER;
+const int CGO_ErrorTypeInvalidParameter =
+    (int)RTCErrorType::INVALID_PARAMETER;
+const int CGO_ErrorTypeInvalidRange =
+    (int)RTCErrorType::INVALID_RANGE;
+const int CGO_ErrorTypeSyntaxError =
+    (int)RTCErrorType::SYNTAX_ERROR;
+const int CGO_ErrorTypeInvalidState =
+    (int)RTCErrorType::INVALID_STATE;
+const int CGO_ErrorTypeInvalidModification =
+    (int)RTCErrorType::INVALID_MODIFICATION;
+const int CGO_ErrorTypeNetworkError =
+    (int)RTCErrorType::NETWORK_ERROR;
+const int CGO_ErrorTypeResourceExhausted =
+    (int)RTCErrorType::RESOURCE_EXHAUSTED;
+const int CGO_ErrorTypeInternalError =
+    (int)RTCErrorType::INTERNAL_ERROR;
+
+const int CGO_KeyTypeRSA = rtc::KT_RSA;
+const int CGO_KeyTypeECDSA = rtc::KT_ECDSA;
+
+const int CGO_SSLProtocolDTLS10 = rtc::SSL_PROTOCOL_DTLS_10;
+const int CGO_SSLProtocolDTLS12 = rtc::SSL_PROTOCOL_DTLS_12;
@@ -18,9 +18,33 @@ extern "C" {
   extern const int CGO_BundlePolicyMaxBundle;
   extern const int CGO_BundlePolicyMaxCompat;
 
-  // TODO: [ED]
-  // extern const int CGO_RtcpMuxPolicyNegotiate;
-  // extern const int CGO_RtcpMuxPolicyRequire;
+  extern const int CGO_RtcpMuxPolicyNegotiate;
+  extern const int CGO_RtcpMuxPolicyRequire;
+
+  extern const int CGO_SdpSemanticsDefault;
+  extern const int CGO_SdpSemanticsPlanB;
+  extern const int CGO_SdpSemanticsUnifiedPlan;
+
+  extern const int CGO_TlsCertPolicySecure;
+  extern const int CGO_TlsCertPolicyInsecureNoCheck;
+
+  // See rtc_base/network_constants.h
+  extern const int CGO_NetworkTypeEthernet;
+  extern const int CGO_NetworkTypeWifi;
+  extern const int CGO_NetworkTypeCellular;
+  extern const int CGO_NetworkTypeVpn;
+  extern const int CGO_NetworkTypeLoopback;
+
+  extern const int CGO_TcpCandidatePolicyEnabled;
+  extern const int CGO_TcpCandidatePolicyDisabled;
+
+  extern const int CGO_GatheringPolicyOnce;
+  extern const int CGO_GatheringPolicyContinually;
+
+  // See rtc_base/proxyinfo.h
+  extern const int CGO_ProxyTypeNone;
+  extern const int CGO_ProxyTypeHttps;
+  extern const int CGO_ProxyTypeSocks5;
 
   extern const int CGO_SignalingStateStable;
   extern const int CGO_SignalingStateHaveLocalOffer;
@@ -41,6 +65,27 @@ extern "C" {
   extern const int CGO_IceGatheringStateGathering;
   extern const int CGO_IceGatheringStateComplete;
 
+  // See api/rtcerror.h
+  extern const int CGO_ErrorTypeNone;
+  extern const int CGO_ErrorTypeUnsupportedOperation;
+  extern const int CGO_ErrorTypeUnsupportedParameter;
+  extern const int CGO_ErrorTypeInvalidParameter;
+  extern const int CGO_ErrorTypeInvalidRange;
+  extern const int CGO_ErrorTypeSyntaxError;
+  extern const int CGO_ErrorTypeInvalidState;
+  extern const int CGO_ErrorTypeInvalidModification;
+  extern const int CGO_ErrorTypeNetworkError;
+  extern const int CGO_ErrorTypeResourceExhausted;
+  extern const int CGO_ErrorTypeInternalError;
+
+  // See rtc_base/sslidentity.h
+  extern const int CGO_KeyTypeRSA;
+  extern const int CGO_KeyTypeECDSA;
+
+  // See rtc_base/sslstreamadapter.h
+  extern const int CGO_SSLProtocolDTLS10;
+  extern const int CGO_SSLProtocolDTLS12;
+
 #ifdef __cplusplus
 }
 #endif
@@ -35,7 +35,8 @@ const (
 
 //
 // Preparing SDP messages for signaling.
-// generateOffer and generateAnswer are expected to be called within goroutines.
+// generateOffer and generateAnswer block, but may be called directly from
+// within PeerConnection callbacks.
 // It is possible to send the serialized offers or answers immediately upon
 // creation, followed by subsequent individual ICE candidates.
 //
@@ -206,7 +207,7 @@ func start(instigator bool) {
 	// the state of PeerConnection (such as creating a new data channel), in which
 	// case a new SDP offer must be prepared and sent to the remote peer.
 	pc.OnNegotiationNeeded = func() {
-		go generateOffer()
+		generateOffer()
 	}
 	// Once all ICE candidates are prepared, they need to be sent to the remote
 	// peer which will attempt reaching the local peer through NATs.
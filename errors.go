@@ -0,0 +1,128 @@
+package webrtc
+
+// #include <stdlib.h>
+// #include "peerconnection.h"
+// #include "ctestenums.h"
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// ErrorType corresponds to webrtc::RTCErrorType in include/api/rtcerror.h,
+// and so its values must match the order there.
+type ErrorType int
+
+const (
+	ErrorTypeNone ErrorType = iota
+	ErrorTypeUnsupportedOperation
+	ErrorTypeUnsupportedParameter
+	ErrorTypeInvalidParameter
+	ErrorTypeInvalidRange
+	ErrorTypeSyntaxError
+	ErrorTypeInvalidState
+	ErrorTypeInvalidModification
+	ErrorTypeNetworkError
+	ErrorTypeResourceExhausted
+	ErrorTypeInternalError
+	// Not part of native code. Used when libwebrtc didn't respond in time.
+	ErrorTypeTimeout
+)
+
+func (t ErrorType) String() string {
+	return EnumToStringSafe(int(t), []string{
+		"None",
+		"UnsupportedOperation",
+		"UnsupportedParameter",
+		"InvalidParameter",
+		"InvalidRange",
+		"SyntaxError",
+		"InvalidState",
+		"InvalidModification",
+		"NetworkError",
+		"ResourceExhausted",
+		"InternalError",
+		"Timeout",
+	})
+}
+
+// Error is returned by the PeerConnection methods when the native code fails,
+// carrying the RTCErrorType-style code, the failing operation, and the message
+// from libwebrtc, if there was one.
+//
+// Errors compare by Type with errors.Is, so that for example
+//
+//	errors.Is(err, ErrInvalidState)
+//
+// holds for any operation called in the wrong signaling state. If the target
+// also has an Op, that must match too.
+type Error struct {
+	Type    ErrorType
+	Op      string // Such as "SetLocalDescription".
+	Message string // As reported by libwebrtc. Not guaranteed to be stable.
+}
+
+func (e *Error) Error() string {
+	if "" == e.Message {
+		return fmt.Sprintf("webrtc: %s: %s", e.Op, e.Type)
+	}
+	return fmt.Sprintf("webrtc: %s: %s: %s", e.Op, e.Type, e.Message)
+}
+
+// Is allows errors.Is to match an *Error by its Type, and by its Op if set.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.Type == e.Type && ("" == t.Op || t.Op == e.Op)
+}
+
+// Sentinels for use with errors.Is.
+var (
+	ErrUnsupportedOperation = &Error{Type: ErrorTypeUnsupportedOperation}
+	ErrUnsupportedParameter = &Error{Type: ErrorTypeUnsupportedParameter}
+	ErrInvalidParameter     = &Error{Type: ErrorTypeInvalidParameter}
+	ErrInvalidRange         = &Error{Type: ErrorTypeInvalidRange}
+	ErrSyntax               = &Error{Type: ErrorTypeSyntaxError}
+	ErrInvalidState         = &Error{Type: ErrorTypeInvalidState}
+	ErrInvalidModification  = &Error{Type: ErrorTypeInvalidModification}
+	ErrNetwork              = &Error{Type: ErrorTypeNetworkError}
+	ErrResourceExhausted    = &Error{Type: ErrorTypeResourceExhausted}
+	ErrInternal             = &Error{Type: ErrorTypeInternalError}
+
+	// ErrTimeout matches errors where libwebrtc did not complete an operation
+	// within the allowed time.
+	ErrTimeout = &Error{Type: ErrorTypeTimeout}
+)
+
+// Build an *Error for |op| out of what the native code reported, and release
+// the native message.
+func newNativeError(op string, cErr *C.CGO_Error) *Error {
+	e := &Error{
+		Type: ErrorType(cErr._type),
+		Op:   op,
+	}
+	if nil != cErr.message {
+		e.Message = C.GoString(cErr.message)
+		C.free(unsafe.Pointer(cErr.message))
+		cErr.message = nil
+	}
+	if ErrorTypeNone == e.Type {
+		e.Type = ErrorTypeInternalError
+	}
+	return e
+}
+
+// Test helpers
+var _cgoErrorTypeNone = int(C.CGO_ErrorTypeNone)
+var _cgoErrorTypeUnsupportedOperation = int(C.CGO_ErrorTypeUnsupportedOperation)
+var _cgoErrorTypeUnsupportedParameter = int(C.CGO_ErrorTypeUnsupportedParameter)
+var _cgoErrorTypeInvalidParameter = int(C.CGO_ErrorTypeInvalidParameter)
+var _cgoErrorTypeInvalidRange = int(C.CGO_ErrorTypeInvalidRange)
+var _cgoErrorTypeSyntaxError = int(C.CGO_ErrorTypeSyntaxError)
+var _cgoErrorTypeInvalidState = int(C.CGO_ErrorTypeInvalidState)
+var _cgoErrorTypeInvalidModification = int(C.CGO_ErrorTypeInvalidModification)
+var _cgoErrorTypeNetworkError = int(C.CGO_ErrorTypeNetworkError)
+var _cgoErrorTypeResourceExhausted = int(C.CGO_ErrorTypeResourceExhausted)
+var _cgoErrorTypeInternalError = int(C.CGO_ErrorTypeInternalError)
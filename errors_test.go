@@ -0,0 +1,74 @@
+package webrtc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestErrorTypeEnums(t *testing.T) {
+	Convey(`Enum: ErrorType values should match
+C++ webrtc::RTCErrorType values`, t, func() {
+		So(ErrorTypeNone, ShouldEqual, _cgoErrorTypeNone)
+		So(ErrorTypeUnsupportedOperation, ShouldEqual, _cgoErrorTypeUnsupportedOperation)
+		So(ErrorTypeUnsupportedParameter, ShouldEqual, _cgoErrorTypeUnsupportedParameter)
+		So(ErrorTypeInvalidParameter, ShouldEqual, _cgoErrorTypeInvalidParameter)
+		So(ErrorTypeInvalidRange, ShouldEqual, _cgoErrorTypeInvalidRange)
+		So(ErrorTypeSyntaxError, ShouldEqual, _cgoErrorTypeSyntaxError)
+		So(ErrorTypeInvalidState, ShouldEqual, _cgoErrorTypeInvalidState)
+		So(ErrorTypeInvalidModification, ShouldEqual, _cgoErrorTypeInvalidModification)
+		So(ErrorTypeNetworkError, ShouldEqual, _cgoErrorTypeNetworkError)
+		So(ErrorTypeResourceExhausted, ShouldEqual, _cgoErrorTypeResourceExhausted)
+		So(ErrorTypeInternalError, ShouldEqual, _cgoErrorTypeInternalError)
+	})
+}
+
+func TestError(t *testing.T) {
+	SetLoggingVerbosity(0)
+
+	Convey("Error", t, func() {
+		err := error(&Error{ErrorTypeInvalidState, "SetLocalDescription", "nope"})
+		So(err.Error(), ShouldEqual,
+			"webrtc: SetLocalDescription: InvalidState: nope")
+		So(errors.Is(err, ErrInvalidState), ShouldBeTrue)
+		So(errors.Is(err, ErrSyntax), ShouldBeFalse)
+		So(errors.Is(err, &Error{Type: ErrorTypeInvalidState, Op: "SetLocalDescription"}),
+			ShouldBeTrue)
+		So(errors.Is(err, &Error{Type: ErrorTypeInvalidState, Op: "CreateOffer"}),
+			ShouldBeFalse)
+		var e *Error
+		So(errors.As(err, &e), ShouldBeTrue)
+		So(e.Op, ShouldEqual, "SetLocalDescription")
+
+		timeout := &Error{Type: ErrorTypeTimeout, Op: "CreateOffer"}
+		So(errors.Is(timeout, ErrTimeout), ShouldBeTrue)
+		So(errors.Is(timeout, context.DeadlineExceeded), ShouldBeFalse)
+	})
+
+	Convey("Native failures map to Errors", t, func() {
+		pc, err := NewPeerConnection(NewConfiguration())
+		So(err, ShouldBeNil)
+
+		_, err = NewPeerConnection(nil)
+		So(errors.Is(err, ErrInvalidParameter), ShouldBeTrue)
+
+		// Answering without a remote offer is a wrong-state call.
+		answer, err := pc.CreateAnswer()
+		So(answer, ShouldBeNil)
+		So(errors.Is(err, ErrInvalidState), ShouldBeTrue)
+
+		err = pc.SetRemoteDescription(&SessionDescription{"offer", "not sdp"})
+		So(errors.Is(err, ErrSyntax), ShouldBeTrue)
+		So(errors.Is(err, &Error{Type: ErrorTypeSyntaxError, Op: "SetRemoteDescription"}),
+			ShouldBeTrue)
+
+		err = pc.AddIceCandidate(IceCandidate{"fixme", "", 0})
+		So(errors.Is(err, ErrSyntax), ShouldBeTrue)
+
+		err = pc.SetLocalDescription(nil)
+		So(errors.Is(err, ErrInvalidParameter), ShouldBeTrue)
+		pc.Destroy()
+	})
+}
@@ -0,0 +1,34 @@
+#include "factory.h"
+#include "factory.hpp"
+
+#include "rtc_base/refcountedobject.h"
+
+// Returns a Factory holding one reference, for Go, which
+// CGO_ReleaseFactory releases.
+CGO_Factory CGO_NewFactory(int dataOnly) {
+  rtc::scoped_refptr<Factory> factory = new rtc::RefCountedObject<Factory>();
+  if (!factory->Initialize(dataOnly))
+    return NULL;
+  return factory.release();
+}
+
+void CGO_Factory_SetOptions(CGO_Factory cgoFactory,
+                            CGO_FactoryOptions cgoOptions) {
+  webrtc::PeerConnectionFactoryInterface::Options options;
+  options.disable_encryption = cgoOptions.disableEncryption;
+  options.network_ignore_mask = cgoOptions.networkIgnoreMask;
+  options.ssl_max_version = (rtc::SSLProtocolVersion)cgoOptions.sslMaxVersion;
+  Factory *factory = (Factory*)cgoFactory;
+  std::lock_guard<std::mutex> lock(factory->options_lock);
+  factory->options = options;
+}
+
+void CGO_ReleaseFactory(CGO_Factory cgoFactory) {
+  ((Factory*)cgoFactory)->Release();
+}
+
+int CGO_fakeFactoryHasOneRef(CGO_Factory cgoFactory) {
+  // Every Factory is made by CGO_NewFactory.
+  return static_cast<rtc::RefCountedObject<Factory>*>(
+      (Factory*)cgoFactory)->HasOneRef();
+}
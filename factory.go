@@ -0,0 +1,209 @@
+package webrtc
+
+// #include "peerconnection.h"
+// #include "ctestenums.h"
+import "C"
+import (
+	"errors"
+	"sync"
+)
+
+/*
+Factory creates PeerConnections, which share its native signaling, worker and
+network threads, and its audio engine. Without one, NewPeerConnection uses the
+DefaultFactory, so that a process serving thousands of peers needs only three
+threads for all of them.
+
+Every callback of a Factory's PeerConnections fires on its one signaling
+thread, so a slow handler holds up the rest. Give a Factory of its own to
+PeerConnections which must not wait on each other.
+*/
+type Factory struct {
+	cgoFactory C.CGO_Factory // Nil once destroyed.
+	options    FactoryOptions
+	lock       sync.Mutex
+	isDefault  bool
+}
+
+// SSLProtocolVersion selects a version of DTLS.
+type SSLProtocolVersion int
+
+// These must match rtc::SSLProtocolVersion in rtc_base/sslstreamadapter.h,
+// where each DTLS version shares the value of the TLS version it is based on.
+// Zero is left for the default.
+const (
+	SSLProtocolDTLS10 SSLProtocolVersion = iota + 1
+	SSLProtocolDTLS12
+)
+
+func (v SSLProtocolVersion) String() string {
+	return EnumToStringSafe(int(v), []string{
+		"Default",
+		"DTLS10",
+		"DTLS12",
+	})
+}
+
+/*
+FactoryOptions apply to every PeerConnection a Factory creates, as opposed to
+a Configuration, which applies to one. The zero value keeps libwebrtc's
+defaults.
+*/
+type FactoryOptions struct {
+	// Turns DTLS off, for protocol debugging and throughput tests between
+	// peers which both disable it. Never use this in production: anyone on
+	// the path can read and alter everything sent.
+	//
+	// libwebrtc only runs SCTP over DTLS, so DataChannels are carried over
+	// RTP instead, which is unreliable, unordered and limited in bandwidth.
+	// CreateDataChannel warns of this each time, and fails for a
+	// DataChannelInit asking for retransmits, a packet lifetime or an ID.
+	DisableEncryption bool
+	// Types of network to leave out, on top of the NetworkIgnoreMask of each
+	// PeerConnection's Configuration.
+	NetworkIgnoreMask NetworkType
+	// The highest version of DTLS to negotiate, or zero for DTLS 1.2. Peers
+	// settle on the highest version both support.
+	SSLMaxVersion SSLProtocolVersion
+}
+
+func (o FactoryOptions) _CGO() C.CGO_FactoryOptions {
+	version := o.SSLMaxVersion
+	if 0 == version {
+		version = SSLProtocolDTLS12
+	}
+	return C.CGO_FactoryOptions{
+		disableEncryption: C.int(boolToInt(o.DisableEncryption)),
+		networkIgnoreMask: C.int(o.NetworkIgnoreMask &^ NetworkTypeNone),
+		sslMaxVersion:     C.int(version),
+	}
+}
+
+// Logged loudly, since it must never go unnoticed.
+const encryptionDisabledWarning = "ENCRYPTION IS DISABLED. Anyone on the " +
+	"path can read and alter what is sent."
+
+// NewFactory starts the threads of a new Factory.
+func NewFactory() (*Factory, error) {
+	return newFactory("NewFactory", false)
+}
+
+/*
+NewDataOnlyFactory starts a Factory without an audio engine, whose
+PeerConnections only support DataChannels. It leaves out the fake audio device,
+its thread, and the audio codecs, which every PeerConnection of a NewFactory
+carries even though this package has no media API.
+
+Its PeerConnections cannot negotiate audio or video, so OfferToReceiveAudio
+and OfferToReceiveVideo are of no use with it.
+*/
+func NewDataOnlyFactory() (*Factory, error) {
+	return newFactory("NewDataOnlyFactory", true)
+}
+
+func newFactory(op string, dataOnly bool) (*Factory, error) {
+	cgoFactory := C.CGO_NewFactory(C.int(boolToInt(dataOnly)))
+	if nil == cgoFactory {
+		return nil, errors.New(op + ": could not create PeerConnectionFactory")
+	}
+	INFO.Println("Created Factory: ", cgoFactory, "data only:", dataOnly)
+	return &Factory{cgoFactory: cgoFactory}, nil
+}
+
+var defaultFactory struct {
+	once    sync.Once
+	factory *Factory
+	err     error
+}
+
+// DefaultFactory returns the Factory shared by every PeerConnection created
+// without one, starting it on first use. It is never destroyed.
+func DefaultFactory() (*Factory, error) {
+	defaultFactory.once.Do(func() {
+		defaultFactory.factory, defaultFactory.err = NewFactory()
+		if nil == defaultFactory.err {
+			defaultFactory.factory.isDefault = true
+		}
+	})
+	return defaultFactory.factory, defaultFactory.err
+}
+
+/*
+Destroy releases |f|, after which it cannot create more PeerConnections. Its
+threads stop once the PeerConnections it already created are destroyed too.
+
+The DefaultFactory cannot be destroyed.
+*/
+func (f *Factory) Destroy() error {
+	if f.isDefault {
+		return errors.New("Factory.Destroy: cannot destroy the DefaultFactory")
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if nil != f.cgoFactory {
+		C.CGO_ReleaseFactory(f.cgoFactory)
+		f.cgoFactory = nil
+	}
+	return nil
+}
+
+/*
+SetOptions applies |options| to the PeerConnections |f| creates from now on.
+Those it already created keep the options they were created with.
+
+The DefaultFactory cannot disable encryption, so that it is only ever off
+for PeerConnections created from a Factory of their own.
+*/
+func (f *Factory) SetOptions(options FactoryOptions) error {
+	if options.SSLMaxVersion < 0 || options.SSLMaxVersion > SSLProtocolDTLS12 {
+		return &Error{ErrorTypeInvalidParameter, "Factory.SetOptions",
+			"Unknown SSLMaxVersion."}
+	}
+	if options.DisableEncryption && f.isDefault {
+		return &Error{ErrorTypeInvalidParameter, "Factory.SetOptions",
+			"Cannot disable encryption for the DefaultFactory."}
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if nil == f.cgoFactory {
+		return &Error{ErrorTypeInvalidState, "Factory.SetOptions",
+			"Factory is destroyed."}
+	}
+	if options.DisableEncryption {
+		WARN.Println("Factory.SetOptions:", encryptionDisabledWarning)
+	}
+	C.CGO_Factory_SetOptions(f.cgoFactory, options._CGO())
+	f.options = options
+	return nil
+}
+
+// Options returns what SetOptions last set.
+func (f *Factory) Options() FactoryOptions {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.options
+}
+
+// Creates the native Peer for |pc| from |f|, unless |f| is destroyed.
+func (f *Factory) initializePeer(pc *PeerConnection) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if nil == f.cgoFactory {
+		return &Error{ErrorTypeInvalidState, "NewPeerConnection",
+			"Factory is destroyed."}
+	}
+	if f.options.DisableEncryption {
+		WARN.Println("NewPeerConnection:", encryptionDisabledWarning)
+	}
+	pc.encryptionDisabled = f.options.DisableEncryption
+	pc.cgoPeer = C.CGO_InitializePeer(C.int(pc.index), f.cgoFactory)
+	return nil
+}
+
+// Test helpers
+var _cgoSSLProtocolDTLS10 = int(C.CGO_SSLProtocolDTLS10)
+var _cgoSSLProtocolDTLS12 = int(C.CGO_SSLProtocolDTLS12)
+
+func cgoFactoryHasOneRef(f *Factory) bool {
+	return 0 != C.CGO_fakeFactoryHasOneRef(f.cgoFactory)
+}
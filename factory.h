@@ -0,0 +1,41 @@
+#ifndef _C_FACTORY_H_
+#define _C_FACTORY_H_
+
+#define WEBRTC_POSIX 1
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+  // In order to present an interface cgo is happy with, nothing in this file
+  // can directly reference header files from libwebrtc / C++ world. All the
+  // casting must be hidden in the .cc file.
+
+  // A webrtc::PeerConnectionFactory, and the threads it runs on, for any
+  // number of Peers.
+  typedef void* CGO_Factory;
+
+  // Mirrors the parts of webrtc::PeerConnectionFactoryInterface::Options
+  // which Go can set.
+  typedef struct {
+    int disableEncryption;
+    int networkIgnoreMask;
+    int sslMaxVersion;
+  } CGO_FactoryOptions;
+
+  // Returns NULL on failure. A data only Factory has no audio engine.
+  CGO_Factory CGO_NewFactory(int dataOnly);
+  // For the PeerConnections created after.
+  void CGO_Factory_SetOptions(CGO_Factory, CGO_FactoryOptions);
+  // Releases Go's reference. The Factory lives on until its last Peer is
+  // destroyed too.
+  void CGO_ReleaseFactory(CGO_Factory);
+
+  // Test helper: whether Go holds the only reference, with no Peers left.
+  int CGO_fakeFactoryHasOneRef(CGO_Factory);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif  // _C_FACTORY_H_
@@ -0,0 +1,99 @@
+#ifndef _FACTORY_H_
+#define _FACTORY_H_
+
+#include <memory>
+#include <mutex>
+
+#include "api/audio_codecs/builtin_audio_decoder_factory.h"
+#include "api/audio_codecs/builtin_audio_encoder_factory.h"
+#include "api/peerconnectioninterface.h"
+#include "media/base/mediaengine.h"
+#include "pc/test/fakeaudiocapturemodule.h"
+#include "rtc_base/refcount.h"
+#include "rtc_base/thread.h"
+
+// Owns a webrtc::PeerConnectionFactory along with its signaling, worker and
+// network threads, which every Peer created from it shares. Peers hold a
+// reference, so that the threads outlive them.
+class Factory : public rtc::RefCountInterface {
+ public:
+  // Expected to be called before anything else happens for Factory. Without
+  // media, there is no audio engine, and the PeerConnections only support
+  // DataChannels.
+  bool Initialize(bool data_only) {
+    // Each Peer adds the mask of its own Configuration to this.
+    options.network_ignore_mask = 0;
+
+    // Due to the different threading model, in order for
+    // PeerConnectionFactory to be able to post async messages without getting
+    // blocked, we need to use external threads, accounted for in this class.
+    network_thread_ = rtc::Thread::CreateWithSocketServer();
+    worker_thread_ = rtc::Thread::Create();
+    signalling_thread_ = rtc::Thread::Create();
+    network_thread_->SetName("CGO Network", NULL);
+    worker_thread_->SetName("CGO Worker", NULL);
+    signalling_thread_->SetName("CGO Signalling", NULL);
+    network_thread_->Start();     // Must start before being passed to
+    worker_thread_->Start();      // PeerConnectionFactory.
+    signalling_thread_->Start();
+
+    if (data_only) {
+      pc_factory_ = webrtc::CreateModularPeerConnectionFactory(
+        network_thread_.get(),
+        worker_thread_.get(),
+        signalling_thread_.get(),
+        nullptr,  // media engine
+        nullptr,  // call factory
+        nullptr);  // event log factory
+      return nullptr != pc_factory_.get();
+    }
+    fake_audio_ = FakeAudioCaptureModule::Create();
+    pc_factory_ = webrtc::CreatePeerConnectionFactory(
+      network_thread_.get(),
+      worker_thread_.get(),
+      signalling_thread_.get(),
+      fake_audio_.get(),
+      webrtc::CreateBuiltinAudioEncoderFactory(),
+      webrtc::CreateBuiltinAudioDecoderFactory(),
+      NULL, NULL);
+    return nullptr != pc_factory_.get();
+  }
+
+  rtc::Thread* signalling_thread() {
+    return signalling_thread_.get();
+  }
+
+  rtc::Thread* network_thread() {
+    return network_thread_.get();
+  }
+
+  webrtc::PeerConnectionFactoryInterface* pc_factory() {
+    return pc_factory_.get();
+  }
+
+  // PeerConnectionFactory applies its Options to each PeerConnection as it
+  // creates them, so Peers hold this from SetOptions until created, with
+  // their own network_ignore_mask added to |options|.
+  std::mutex options_lock;
+  webrtc::PeerConnectionFactoryInterface::Options options;
+
+ protected:
+  ~Factory() {
+    // NOTE: Clears this explicitly first since it uses the threads
+    pc_factory_ = nullptr;
+    fake_audio_ = nullptr;
+
+    signalling_thread_->Stop();
+    worker_thread_->Stop();
+    network_thread_->Stop();
+  }
+
+ private:
+  std::unique_ptr<rtc::Thread> network_thread_;
+  std::unique_ptr<rtc::Thread> worker_thread_;
+  std::unique_ptr<rtc::Thread> signalling_thread_;
+  rtc::scoped_refptr<webrtc::AudioDeviceModule> fake_audio_;  // Or null.
+  rtc::scoped_refptr<webrtc::PeerConnectionFactoryInterface> pc_factory_;
+};  // class Factory
+
+#endif  // _FACTORY_H_
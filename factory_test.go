@@ -0,0 +1,395 @@
+package webrtc
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// Returns a field of /proc/self/status, such as "Threads", or -1 where there
+// is none. "VmRSS" is in kB.
+func procStatus(field string) int {
+	f, err := os.Open("/proc/self/status")
+	if nil != err {
+		return -1
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if 2 <= len(fields) && field+":" == fields[0] {
+			n, err := strconv.Atoi(fields[1])
+			if nil != err {
+				return -1
+			}
+			return n
+		}
+	}
+	return -1
+}
+
+func TestSSLProtocolVersionEnums(t *testing.T) {
+	Convey(`Enum: SSLProtocolVersion values should match
+C++ rtc::SSLProtocolVersion values`, t, func() {
+		So(SSLProtocolDTLS10, ShouldEqual, _cgoSSLProtocolDTLS10)
+		So(SSLProtocolDTLS12, ShouldEqual, _cgoSSLProtocolDTLS12)
+	})
+}
+
+func TestFactory(t *testing.T) {
+	SetLoggingVerbosity(0)
+
+	Convey("DefaultFactory", t, func() {
+		factory, err := DefaultFactory()
+		So(err, ShouldBeNil)
+		again, err := DefaultFactory()
+		So(err, ShouldBeNil)
+		So(again, ShouldEqual, factory)
+		So(factory.Destroy(), ShouldNotBeNil)
+
+		pc, err := NewPeerConnection(NewConfiguration(), factory)
+		So(err, ShouldBeNil)
+		So(pc.Destroy(), ShouldBeNil)
+	})
+
+	Convey("NewPeerConnection takes at most one Factory", t, func() {
+		factory, err := NewFactory()
+		So(err, ShouldBeNil)
+		defer factory.Destroy()
+		_, err = NewPeerConnection(NewConfiguration(), factory, factory)
+		So(err, ShouldNotBeNil)
+		_, err = NewPeerConnection(NewConfiguration(), nil)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A destroyed Factory", t, func() {
+		factory, err := NewFactory()
+		So(err, ShouldBeNil)
+		pc, err := NewPeerConnection(NewConfiguration(), factory)
+		So(err, ShouldBeNil)
+		defer pc.Destroy()
+		So(factory.Destroy(), ShouldBeNil)
+		So(factory.Destroy(), ShouldBeNil)
+
+		Convey("Creates no more PeerConnections", func() {
+			_, err := NewPeerConnection(NewConfiguration(), factory)
+			So(err, ShouldNotBeNil)
+			So(err.(*Error).Type, ShouldEqual, ErrorTypeInvalidState)
+		})
+
+		Convey("Keeps running those it already created", func() {
+			channel, err := pc.CreateDataChannel("factory")
+			So(err, ShouldBeNil)
+			defer pc.DeleteDataChannel(channel)
+			offer, err := pc.CreateOffer()
+			So(err, ShouldBeNil)
+			So(offer, ShouldNotBeNil)
+		})
+	})
+
+	Convey("A Factory whose PeerConnections failed to create", t, func() {
+		// Counts the entries of |m|.
+		size := func(m *CGOMap) int {
+			m.lock.Lock()
+			defer m.lock.Unlock()
+			return len(m.pointers)
+		}
+		pcs, transports := size(&PCMap), size(&transportMap)
+		factory, err := NewFactory()
+		So(err, ShouldBeNil)
+		defer factory.Destroy()
+
+		badPorts := NewConfiguration(
+			OptionTransport(newMemNetwork().transport("10.0.0.1")))
+		badPorts.MinPort, badPorts.MaxPort = 40100, 40000
+		badProxy := NewConfiguration()
+		badProxy.Proxy = ProxyConfig{Type: ProxyTypeSocks5, Address: "nowhere"}
+		for _, config := range []*Configuration{badPorts, badProxy} {
+			pc, err := NewPeerConnection(config, factory)
+			So(pc, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		}
+		So(size(&PCMap), ShouldEqual, pcs)
+		So(size(&transportMap), ShouldEqual, transports)
+		// No Peer is left holding on to the Factory, so that Destroy stops
+		// its threads.
+		So(cgoFactoryHasOneRef(factory), ShouldBeTrue)
+	})
+
+	Convey("PeerConnections of a Factory share its threads", t, func() {
+		if -1 == procStatus("Threads") {
+			SkipSo("Needs /proc/self/status.")
+			return
+		}
+		factory, err := NewFactory()
+		So(err, ShouldBeNil)
+		defer factory.Destroy()
+		before := procStatus("Threads")
+		var pcs []*PeerConnection
+		for i := 0; i < 20; i++ {
+			pc, err := NewPeerConnection(NewConfiguration(), factory)
+			So(err, ShouldBeNil)
+			pcs = append(pcs, pc)
+		}
+		// Leaves room for the Go runtime's own threads.
+		So(procStatus("Threads")-before, ShouldBeLessThan, 10)
+		for _, pc := range pcs {
+			So(pc.Destroy(), ShouldBeNil)
+		}
+	})
+
+	Convey("PeerConnections of different Factories connect", t, func() {
+		network := newMemNetwork()
+		aliceFactory, err := NewDataOnlyFactory()
+		So(err, ShouldBeNil)
+		defer aliceFactory.Destroy()
+		alice, err := NewPeerConnection(NewConfiguration(
+			OptionTransport(network.transport("10.0.0.1"))), aliceFactory)
+		So(err, ShouldBeNil)
+		defer alice.Destroy()
+		bob, err := NewPeerConnection(NewConfiguration(
+			OptionTransport(network.transport("10.0.0.2"))))
+		So(err, ShouldBeNil)
+		defer bob.Destroy()
+
+		channel, err := alice.CreateDataChannel("factory")
+		So(err, ShouldBeNil)
+		defer alice.DeleteDataChannel(channel)
+		opened := make(chan bool, 1)
+		channel.OnOpen = func() {
+			opened <- true
+		}
+		exchangeDescriptions(alice, bob)
+		select {
+		case <-opened:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timed out waiting for the DataChannel.")
+		}
+	})
+
+	Convey("Factory.SetOptions", t, func() {
+		factory, err := NewFactory()
+		So(err, ShouldBeNil)
+		So(factory.Options(), ShouldResemble, FactoryOptions{})
+		options := FactoryOptions{
+			NetworkIgnoreMask: NetworkTypeVpn,
+			SSLMaxVersion:     SSLProtocolDTLS10,
+		}
+		So(factory.SetOptions(options), ShouldBeNil)
+		So(factory.Options(), ShouldResemble, options)
+
+		err = factory.SetOptions(FactoryOptions{SSLMaxVersion: 3})
+		So(err.(*Error).Type, ShouldEqual, ErrorTypeInvalidParameter)
+		So(factory.Options(), ShouldResemble, options)
+
+		defaultFactory, err := DefaultFactory()
+		So(err, ShouldBeNil)
+		err = defaultFactory.SetOptions(FactoryOptions{DisableEncryption: true})
+		So(err.(*Error).Type, ShouldEqual, ErrorTypeInvalidParameter)
+
+		So(factory.Destroy(), ShouldBeNil)
+		err = factory.SetOptions(options)
+		So(err.(*Error).Type, ShouldEqual, ErrorTypeInvalidState)
+	})
+
+	// Connects two PeerConnections of |factory| over an in-memory network,
+	// and returns the offer they negotiated with.
+	connect := func(factory *Factory) *SessionDescription {
+		network := newMemNetwork()
+		alice, err := NewPeerConnection(NewConfiguration(
+			OptionTransport(network.transport("10.0.0.1"))), factory)
+		So(err, ShouldBeNil)
+		defer alice.Destroy()
+		bob, err := NewPeerConnection(NewConfiguration(
+			OptionTransport(network.transport("10.0.0.2"))), factory)
+		So(err, ShouldBeNil)
+		defer bob.Destroy()
+
+		received := make(chan string, 1)
+		bob.OnDataChannel = func(channel *DataChannel) {
+			channel.OnMessage = func(msg []byte) {
+				received <- string(msg)
+			}
+		}
+		channel, err := alice.CreateDataChannel("options")
+		So(err, ShouldBeNil)
+		defer alice.DeleteDataChannel(channel)
+		opened := make(chan bool, 1)
+		channel.OnOpen = func() {
+			opened <- true
+		}
+		exchangeDescriptions(alice, bob)
+		select {
+		case <-opened:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timed out waiting for the DataChannel.")
+		}
+		channel.SendText("options")
+		select {
+		case msg := <-received:
+			So(msg, ShouldEqual, "options")
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a message.")
+		}
+		return alice.LocalDescription()
+	}
+
+	Convey("PeerConnections of a Factory without encryption", t, func() {
+		factory, err := NewFactory()
+		So(err, ShouldBeNil)
+		defer factory.Destroy()
+		So(factory.SetOptions(FactoryOptions{DisableEncryption: true}),
+			ShouldBeNil)
+		offer := connect(factory)
+		So(offer.Sdp, ShouldNotContainSubstring, "a=fingerprint")
+
+		Convey("Warn of each DataChannel going over RTP", func() {
+			pc, err := NewPeerConnection(NewConfiguration(), factory)
+			So(err, ShouldBeNil)
+			defer pc.Destroy()
+			var warnings bytes.Buffer
+			WARN.SetOutput(&warnings)
+			channel, err := pc.CreateDataChannel("rtp")
+			// Stops any more writes before reading.
+			WARN.SetOutput(ioutil.Discard)
+			So(err, ShouldBeNil)
+			defer pc.DeleteDataChannel(channel)
+			So(warnings.String(), ShouldContainSubstring, `"rtp" goes over RTP`)
+			So(warnings.String(), ShouldContainSubstring, "ENCRYPTION IS DISABLED")
+
+			// RTP can't retransmit.
+			_, err = pc.CreateDataChannel("reliable", MaxRetransmits(3))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("PeerConnections of a Factory limited to DTLS 1.0", t, func() {
+		factory, err := NewFactory()
+		So(err, ShouldBeNil)
+		defer factory.Destroy()
+		So(factory.SetOptions(FactoryOptions{SSLMaxVersion: SSLProtocolDTLS10}),
+			ShouldBeNil)
+		offer := connect(factory)
+		So(offer.Sdp, ShouldContainSubstring, "a=fingerprint")
+	})
+
+	Convey("A data only Factory offers DataChannels alone", t, func() {
+		factory, err := NewDataOnlyFactory()
+		So(err, ShouldBeNil)
+		defer factory.Destroy()
+		pc, err := NewPeerConnection(NewConfiguration(), factory)
+		So(err, ShouldBeNil)
+		defer pc.Destroy()
+		channel, err := pc.CreateDataChannel("data")
+		So(err, ShouldBeNil)
+		defer pc.DeleteDataChannel(channel)
+		offer, err := pc.CreateOffer()
+		So(err, ShouldBeNil)
+		So(offer.Sdp, ShouldContainSubstring, "m=application")
+		So(offer.Sdp, ShouldNotContainSubstring, "m=audio")
+		So(offer.Sdp, ShouldNotContainSubstring, "m=video")
+	})
+}
+
+// Returns the CPU time used by the whole process so far, on every thread.
+func processCPU() time.Duration {
+	var usage syscall.Rusage
+	if nil != syscall.Getrusage(syscall.RUSAGE_SELF, &usage) {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// Creates b.N PeerConnections, each with a DataChannel, through |factory|,
+// and reports the threads and resident memory each adds, and the CPU time
+// each takes, including a second of idling in the background afterwards. Run
+// with a fixed count, such as -benchtime 500x, since nothing is freed until
+// the end.
+func benchmarkPeerConnections(b *testing.B, factory func() *Factory) {
+	SetLoggingVerbosity(0)
+	if -1 == procStatus("Threads") {
+		b.Skip("Needs /proc/self/status.")
+	}
+	threads, rss := procStatus("Threads"), procStatus("VmRSS")
+	cpu := processCPU()
+	pcs := make([]*PeerConnection, 0, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pc, err := NewPeerConnection(NewConfiguration(), factory())
+		if nil != err {
+			b.Fatal(err)
+		}
+		if _, err = pc.CreateDataChannel("benchmark"); nil != err {
+			b.Fatal(err)
+		}
+		pcs = append(pcs, pc)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(procStatus("Threads")-threads)/float64(b.N),
+		"threads/op")
+	b.ReportMetric(float64(procStatus("VmRSS")-rss)*1024/float64(b.N),
+		"rss-bytes/op")
+	time.Sleep(time.Second)
+	b.ReportMetric(float64(processCPU()-cpu)/float64(b.N), "cpu-ns/op")
+	for _, pc := range pcs {
+		pc.Destroy()
+	}
+}
+
+func BenchmarkPeerConnectionsSharedFactory(b *testing.B) {
+	factory, err := NewFactory()
+	if nil != err {
+		b.Fatal(err)
+	}
+	defer factory.Destroy()
+	benchmarkPeerConnections(b, func() *Factory {
+		return factory
+	})
+}
+
+func BenchmarkPeerConnectionsSharedDataOnlyFactory(b *testing.B) {
+	factory, err := NewDataOnlyFactory()
+	if nil != err {
+		b.Fatal(err)
+	}
+	defer factory.Destroy()
+	benchmarkPeerConnections(b, func() *Factory {
+		return factory
+	})
+}
+
+// Gives every PeerConnection a Factory from |newFactory| of its own.
+func benchmarkFactoryEach(b *testing.B, newFactory func() (*Factory, error)) {
+	var factories []*Factory
+	defer func() {
+		for _, factory := range factories {
+			factory.Destroy()
+		}
+	}()
+	benchmarkPeerConnections(b, func() *Factory {
+		factory, err := newFactory()
+		if nil != err {
+			b.Fatal(err)
+		}
+		factories = append(factories, factory)
+		return factory
+	})
+}
+
+// How every PeerConnection ran before Factory, for comparison.
+func BenchmarkPeerConnectionsFactoryEach(b *testing.B) {
+	benchmarkFactoryEach(b, NewFactory)
+}
+
+// What leaving out the audio engine saves per Factory.
+func BenchmarkPeerConnectionsDataOnlyFactoryEach(b *testing.B) {
+	benchmarkFactoryEach(b, NewDataOnlyFactory)
+}
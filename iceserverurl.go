@@ -0,0 +1,256 @@
+package webrtc
+
+// #include <stdlib.h>
+// #include "peerconnection.h"
+import "C"
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+type IceServerScheme int
+
+const (
+	IceServerSchemeStun IceServerScheme = iota
+	IceServerSchemeStuns
+	IceServerSchemeTurn
+	IceServerSchemeTurns
+)
+
+func (s IceServerScheme) String() string {
+	return EnumToStringSafe(int(s), []string{
+		"stun",
+		"stuns",
+		"turn",
+		"turns",
+	})
+}
+
+// Secure reports whether the scheme runs over TLS.
+func (s IceServerScheme) Secure() bool {
+	return IceServerSchemeStuns == s || IceServerSchemeTurns == s
+}
+
+// Default ports from RFC 7064 and RFC 7065. Like libwebrtc, only turns uses
+// DefaultSecureIceServerPort, and stuns defaults to DefaultIceServerPort.
+const (
+	DefaultIceServerPort       = 3478
+	DefaultSecureIceServerPort = 5349
+)
+
+/*
+IceServerURL is a STUN or TURN URL, as defined by RFC 7064 and RFC 7065:
+
+	stun:host[:port]
+	stuns:host[:port]
+	turn:host[:port][?transport=udp|tcp]
+	turns:host[:port][?transport=tcp]
+
+The host may be a hostname, an IPv4 address, or an IPv6 address in brackets.
+As libwebrtc does, the host may be preceded by a percent-encoded username and
+"@", which then takes the place of the IceServer's Username.
+*/
+type IceServerURL struct {
+	Scheme IceServerScheme
+	Host   string // Without the brackets around an IPv6 address.
+	Port   int
+	// For TURN, the transport to the server. For stuns and turns it is always
+	// tcp, carrying TLS.
+	Transport IceProtocol
+	// From the deprecated user@host form, decoded. Empty if there was none.
+	Username string
+}
+
+// ParseIceServerURL parses |rawurl| according to RFC 7064 and RFC 7065,
+// filling in the default port and transport where the URL has none.
+//
+// Malformed URLs fail with ErrSyntax. A turns URL asking for udp, which would
+// mean DTLS, fails with ErrUnsupportedParameter.
+func ParseIceServerURL(rawurl string) (*IceServerURL, error) {
+	fail := func(t ErrorType, format string, a ...interface{}) error {
+		return &Error{t, "ParseIceServerURL",
+			fmt.Sprintf("<%s>: %s", rawurl, fmt.Sprintf(format, a...))}
+	}
+	colon := strings.Index(rawurl, ":")
+	if colon < 0 {
+		return nil, fail(ErrorTypeSyntaxError, "missing scheme")
+	}
+	u := new(IceServerURL)
+	switch strings.ToLower(rawurl[:colon]) {
+	case "stun":
+		u.Scheme = IceServerSchemeStun
+	case "stuns":
+		u.Scheme = IceServerSchemeStuns
+	case "turn":
+		u.Scheme = IceServerSchemeTurn
+	case "turns":
+		u.Scheme = IceServerSchemeTurns
+	default:
+		return nil, fail(ErrorTypeSyntaxError,
+			"unknown scheme %q, expected stun, stuns, turn or turns",
+			rawurl[:colon])
+	}
+	u.Port = DefaultIceServerPort
+	u.Transport = IceProtocolUPD
+	if u.Scheme.Secure() {
+		u.Transport = IceProtocolTCP
+	}
+	if IceServerSchemeTurns == u.Scheme {
+		u.Port = DefaultSecureIceServerPort
+	}
+
+	hostport, query := rawurl[colon+1:], ""
+	if q := strings.Index(hostport, "?"); q >= 0 {
+		hostport, query = hostport[:q], hostport[q+1:]
+		if !u.isTurn() {
+			return nil, fail(ErrorTypeSyntaxError,
+				"%s URLs take no query, such as a transport", u.Scheme)
+		}
+		if err := u.parseTransport(query); "" != err {
+			return nil, fail(ErrorTypeSyntaxError, "%s", err)
+		}
+		if IceServerSchemeTurns == u.Scheme && IceProtocolUPD == u.Transport {
+			return nil, fail(ErrorTypeUnsupportedParameter,
+				"turns over udp (DTLS) is not supported")
+		}
+	}
+	if strings.HasPrefix(hostport, "//") {
+		return nil, fail(ErrorTypeSyntaxError,
+			"unexpected \"//\", the URL has no authority part")
+	}
+	if at := strings.Index(hostport, "@"); at >= 0 {
+		if strings.Count(hostport, "@") > 1 {
+			return nil, fail(ErrorTypeSyntaxError, "more than one \"@\"")
+		}
+		if 0 == at {
+			return nil, fail(ErrorTypeSyntaxError, "empty user info before \"@\"")
+		}
+		username, err := url.QueryUnescape(hostport[:at])
+		if nil != err {
+			return nil, fail(ErrorTypeSyntaxError, "invalid user info: %s", err)
+		}
+		u.Username, hostport = username, hostport[at+1:]
+	}
+
+	host, port := hostport, ""
+	if strings.HasPrefix(hostport, "[") {
+		end := strings.Index(hostport, "]")
+		if end < 0 {
+			return nil, fail(ErrorTypeSyntaxError, "missing \"]\" after IPv6 address")
+		}
+		host = hostport[1:end]
+		if ip := net.ParseIP(host); nil == ip || !strings.Contains(host, ":") {
+			return nil, fail(ErrorTypeSyntaxError, "invalid IPv6 address %q", host)
+		}
+		rest := hostport[end+1:]
+		if "" != rest && !strings.HasPrefix(rest, ":") {
+			return nil, fail(ErrorTypeSyntaxError,
+				"unexpected %q after IPv6 address", rest)
+		}
+		port = strings.TrimPrefix(rest, ":")
+		if "" == port && "" != rest {
+			return nil, fail(ErrorTypeSyntaxError, "missing port after \":\"")
+		}
+	} else {
+		if c := strings.LastIndex(hostport, ":"); c >= 0 {
+			host, port = hostport[:c], hostport[c+1:]
+			if "" == port {
+				return nil, fail(ErrorTypeSyntaxError, "missing port after \":\"")
+			}
+		}
+		if "" == host {
+			return nil, fail(ErrorTypeSyntaxError, "missing host")
+		}
+		if i := strings.IndexFunc(host, notRegName); i >= 0 {
+			return nil, fail(ErrorTypeSyntaxError,
+				"invalid character %q in host", host[i])
+		}
+	}
+	u.Host = host
+
+	if "" != port {
+		if strings.IndexFunc(port, notDigit) >= 0 {
+			return nil, fail(ErrorTypeSyntaxError, "invalid port %q", port)
+		}
+		p, err := strconv.Atoi(port)
+		if nil != err || p < 1 || p > 0xffff {
+			return nil, fail(ErrorTypeSyntaxError,
+				"port %s is out of range 1-65535", port)
+		}
+		u.Port = p
+	}
+	return u, nil
+}
+
+// Parse the "transport=" query of a TURN URL, returning what's wrong with it.
+func (u *IceServerURL) parseTransport(query string) string {
+	kv := strings.SplitN(query, "=", 2)
+	if "transport" != kv[0] {
+		return fmt.Sprintf("unknown query %q, expected transport=", kv[0])
+	}
+	if len(kv) < 2 || "" == kv[1] {
+		return "missing transport value"
+	}
+	switch kv[1] {
+	case "udp":
+		u.Transport = IceProtocolUPD
+	case "tcp":
+		u.Transport = IceProtocolTCP
+	default:
+		return fmt.Sprintf("unsupported transport %q, expected udp or tcp", kv[1])
+	}
+	return ""
+}
+
+func (u *IceServerURL) isTurn() bool {
+	return IceServerSchemeTurn == u.Scheme || IceServerSchemeTurns == u.Scheme
+}
+
+// String formats the URL in its shortest form, leaving out the port and
+// transport when they are the defaults.
+func (u *IceServerURL) String() string {
+	s := u.Scheme.String() + ":"
+	if "" != u.Username {
+		s += url.QueryEscape(u.Username) + "@"
+	}
+	if strings.Contains(u.Host, ":") {
+		s += "[" + u.Host + "]"
+	} else {
+		s += u.Host
+	}
+	port := DefaultIceServerPort
+	if IceServerSchemeTurns == u.Scheme {
+		port = DefaultSecureIceServerPort
+	}
+	if port != u.Port {
+		s += ":" + strconv.Itoa(u.Port)
+	}
+	if IceServerSchemeTurn == u.Scheme && IceProtocolTCP == u.Transport {
+		s += "?transport=tcp"
+	}
+	return s
+}
+
+// The characters allowed in a reg-name or IPv4 address of RFC 3986.
+func notRegName(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("-._~%!$&'()*+,;=", r)
+}
+
+func notDigit(r rune) bool {
+	return r < '0' || r > '9'
+}
+
+// Test helpers
+func cgoParseIceServerURL(url string) ErrorType {
+	cUrl := C.CString(url)
+	defer C.free(unsafe.Pointer(cUrl))
+	return ErrorType(C.CGO_ParseIceServerURL(cUrl))
+}
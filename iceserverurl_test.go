@@ -0,0 +1,207 @@
+package webrtc
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseIceServerURL(t *testing.T) {
+	SetLoggingVerbosity(0)
+
+	Convey("ParseIceServerURL", t, func() {
+
+		Convey("Parses each scheme with its defaults", func() {
+			u, err := ParseIceServerURL("stun:stun.example.org")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, IceServerURL{
+				IceServerSchemeStun, "stun.example.org", 3478, IceProtocolUPD, ""})
+
+			u, err = ParseIceServerURL("stuns:stun.example.org")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, IceServerURL{
+				IceServerSchemeStuns, "stun.example.org", 3478, IceProtocolTCP, ""})
+
+			u, err = ParseIceServerURL("turn:turn.example.org")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, IceServerURL{
+				IceServerSchemeTurn, "turn.example.org", 3478, IceProtocolUPD, ""})
+
+			u, err = ParseIceServerURL("turns:turn.example.org")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, IceServerURL{
+				IceServerSchemeTurns, "turn.example.org", 5349, IceProtocolTCP, ""})
+		})
+
+		Convey("Parses ports, transports and IP addresses", func() {
+			u, err := ParseIceServerURL("turns:turn.example.org:443?transport=tcp")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, IceServerURL{
+				IceServerSchemeTurns, "turn.example.org", 443, IceProtocolTCP, ""})
+
+			u, err = ParseIceServerURL("turn:192.0.2.1:80?transport=tcp")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, IceServerURL{
+				IceServerSchemeTurn, "192.0.2.1", 80, IceProtocolTCP, ""})
+
+			u, err = ParseIceServerURL("turn:[2001:db8::1]:3479?transport=udp")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, IceServerURL{
+				IceServerSchemeTurn, "2001:db8::1", 3479, IceProtocolUPD, ""})
+
+			u, err = ParseIceServerURL("stun:[::1]")
+			So(err, ShouldBeNil)
+			So(u.Host, ShouldEqual, "::1")
+			So(u.Port, ShouldEqual, 3478)
+
+			u, err = ParseIceServerURL("STUN:stun.example.org")
+			So(err, ShouldBeNil)
+			So(u.Scheme, ShouldEqual, IceServerSchemeStun)
+
+			u, err = ParseIceServerURL("turns:alice%40example.org@turn.example.org")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, IceServerURL{
+				IceServerSchemeTurns, "turn.example.org", 5349, IceProtocolTCP,
+				"alice@example.org"})
+		})
+
+		Convey("Formats in the shortest form", func() {
+			for in, out := range map[string]string{
+				"stun:a":                        "stun:a",
+				"STUN:a:3478":                   "stun:a",
+				"stuns:a:3478":                  "stuns:a",
+				"stuns:a:5349":                  "stuns:a:5349",
+				"turn:alice@a:3478":             "turn:alice@a",
+				"turn:a?transport=udp":          "turn:a",
+				"turn:a:80?transport=tcp":       "turn:a:80?transport=tcp",
+				"turns:a:5349?transport=tcp":    "turns:a",
+				"turn:[2001:db8::1]:1234":       "turn:[2001:db8::1]:1234",
+				"turns:[::1]:443?transport=tcp": "turns:[::1]:443",
+			} {
+				u, err := ParseIceServerURL(in)
+				So(err, ShouldBeNil)
+				So(u.String(), ShouldEqual, out)
+			}
+		})
+
+		Convey("Fails precisely on malformed URLs", func() {
+			for url, msg := range map[string]string{
+				"stun.example.org":      "missing scheme",
+				"http:example.org":      "unknown scheme",
+				"stun:":                 "missing host",
+				"stun::3478":            "missing host",
+				"stun:a:":               "missing port",
+				"stun:a:b":              "invalid port",
+				"stun:a:0":              "out of range",
+				"stun:a:65536":          "out of range",
+				"stun:a b":              "invalid character",
+				"stun://a":              "no authority",
+				"turn:@a":               "empty user info",
+				"turn:alice@":           "missing host",
+				"turn:a@b@c":            "more than one",
+				"turn:a%zz@b":           "invalid user info",
+				"stun:a?transport=udp":  "take no query",
+				"turn:a?transport=sctp": "unsupported transport",
+				"turn:a?transport=":     "missing transport",
+				"turn:a?proto=tcp":      "unknown query",
+				"turn:[2001:db8::1":     "missing \"]\"",
+				"turn:[a.example.org]":  "invalid IPv6",
+				"turn:[192.0.2.1]":      "invalid IPv6",
+				"turn:[::1]x":           "after IPv6",
+				"turn:[::1]:":           "missing port",
+			} {
+				_, err := ParseIceServerURL(url)
+				So(err, ShouldNotBeNil)
+				So(errors.Is(err, ErrSyntax), ShouldBeTrue)
+				So(err.Error(), ShouldContainSubstring, url)
+				So(err.Error(), ShouldContainSubstring, msg)
+			}
+
+			_, err := ParseIceServerURL("turns:a?transport=udp")
+			So(errors.Is(err, ErrUnsupportedParameter), ShouldBeTrue)
+		})
+
+		Convey("Agrees with libwebrtc", func() {
+			valid := []string{
+				"stun:stun.example.org",
+				"stun:192.0.2.1:19302",
+				"stun:[2001:db8::1]",
+				"stun:[2001:db8::1]:1234",
+				"stuns:stun.example.org",
+				"turn:turn.example.org",
+				"turn:turn.example.org:80?transport=tcp",
+				"turn:turn.example.org?transport=udp",
+				"turns:turn.example.org:443",
+				"turns:turn.example.org:443?transport=tcp",
+				"turns:[::1]:443",
+				"turn:alice@turn.example.org",
+				"turns:alice%40example.org@turn.example.org:443",
+			}
+			for _, url := range valid {
+				_, err := ParseIceServerURL(url)
+				So(err, ShouldBeNil)
+				So(cgoParseIceServerURL(url), ShouldEqual, ErrorTypeNone)
+			}
+			invalid := []string{
+				"stun.example.org",
+				"http:example.org",
+				"stun:",
+				"stun::3478",
+				"stun:a:",
+				"stun:a:b",
+				"stun:a:0",
+				"stun:a:65536",
+				"turn:a?transport=sctp",
+				"turn:a?transport=",
+				"turn:a?proto=tcp",
+				"turn:[2001:db8::1",
+				"turn:@a",
+				"turn:alice@",
+			}
+			for _, url := range invalid {
+				_, err := ParseIceServerURL(url)
+				So(err, ShouldNotBeNil)
+				So(cgoParseIceServerURL(url), ShouldEqual, ErrorTypeSyntaxError)
+			}
+		})
+
+		Convey("Is stricter than libwebrtc where it deviates from the RFCs", func() {
+			// libwebrtc ignores these queries, takes the host from before a
+			// second "@", and doesn't check what is in brackets or after them.
+			for _, url := range []string{
+				"stun:a?transport=udp",
+				"turns:a?transport=udp",
+				"turn:a@b@c",
+				"turn:[a.example.org]",
+				"turn:[::1]x",
+			} {
+				_, err := ParseIceServerURL(url)
+				So(err, ShouldNotBeNil)
+				So(cgoParseIceServerURL(url), ShouldEqual, ErrorTypeNone)
+			}
+		})
+
+		Convey("Is used by NewIceServer", func() {
+			s, err := NewIceServer(
+				"turns:turn.example.org:443?transport=tcp, stun:[2001:db8::1]:1234",
+				"alice", "secret")
+			So(err, ShouldBeNil)
+			// Only checked, not rewritten.
+			So(s.Urls, ShouldResemble, []string{
+				"turns:turn.example.org:443?transport=tcp",
+				"stun:[2001:db8::1]:1234",
+			})
+
+			s, err = NewIceServer("stun:a, turn:alice@")
+			So(s, ShouldBeNil)
+			So(errors.Is(err, ErrSyntax), ShouldBeTrue)
+
+			config := NewConfiguration()
+			err = config.AddIceServer("turn:[::1]:3478?transport=tcp", "a", "b")
+			So(err, ShouldBeNil)
+			So(config.IceServers[0].Urls, ShouldResemble,
+				[]string{"turn:[::1]:3478?transport=tcp"})
+		})
+	})
+}
@@ -0,0 +1,119 @@
+package webrtc
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// NATMapping pairs the address of a local interface with the public address
+// which a 1:1 NAT, such as a cloud provider's, forwards to it.
+type NATMapping struct {
+	Local  net.IP
+	Public net.IP
+}
+
+/*
+OptionNATMapping advertises |public| for candidates gathered on |local|, for a
+PeerConnection behind a 1:1 NAT with a known public address, so that no STUN
+server is needed to learn it.
+
+Each host candidate on |local| is followed by a copy for |public|, of
+Configuration.NATCandidateType, through both OnIceCandidate and the local
+descriptions. libwebrtc itself does not know of these candidates, which works
+because the NAT forwards everything sent to them to the host candidate.
+*/
+func OptionNATMapping(local, public string) ConfigurationOption {
+	return func(config *Configuration) error {
+		l, p := net.ParseIP(local), net.ParseIP(public)
+		if nil == l || nil == p {
+			return fmt.Errorf("OptionNATMapping: invalid IP in %q -> %q",
+				local, public)
+		}
+		config.NATMappings = append(config.NATMappings, NATMapping{l, p})
+		return nil
+	}
+}
+
+// OptionNATCandidateType sets the type of candidate advertised for the public
+// addresses of OptionNATMapping, which is IceCandidateTypeHost by default.
+func OptionNATCandidateType(t IceCandidateType) ConfigurationOption {
+	return func(config *Configuration) error {
+		if IceCandidateTypeHost != t && IceCandidateTypeSrflx != t {
+			return fmt.Errorf("OptionNATCandidateType: %s must be host or srflx", t)
+		}
+		config.NATCandidateType = t
+		return nil
+	}
+}
+
+// Type preferences from RFC 8445 section 5.1.2.2, as libwebrtc uses them.
+const (
+	hostTypePreference  = 126
+	srflxTypePreference = 100
+)
+
+// Return the candidates to advertise for the public addresses of |candidate|,
+// which is an "a=candidate:" or "candidate:" attribute.
+func (config *Configuration) natCandidates(candidate string) []string {
+	if 0 == len(config.NATMappings) {
+		return nil
+	}
+	prefix := ""
+	if strings.HasPrefix(candidate, "a=") {
+		prefix = "a="
+	}
+	fields := strings.Fields(strings.TrimPrefix(candidate, prefix))
+	// foundation component protocol priority address port "typ" type ...
+	if len(fields) < 8 || "typ" != fields[6] || "host" != fields[7] {
+		return nil
+	}
+	ip := net.ParseIP(fields[4])
+	priority, err := strconv.ParseUint(fields[3], 10, 32)
+	if nil == ip || nil != err {
+		return nil
+	}
+	var mapped []string
+	for _, m := range config.NATMappings {
+		if !m.Local.Equal(ip) {
+			continue
+		}
+		f := append([]string(nil), fields...)
+		f[4] = m.Public.String()
+		// A different foundation, as the candidates differ in address.
+		h := fnv.New32a()
+		h.Write([]byte(config.NATCandidateType.String() + f[2] + f[4]))
+		f[0] = "candidate:" + strconv.FormatUint(uint64(h.Sum32()), 10)
+		if IceCandidateTypeSrflx == config.NATCandidateType {
+			f[3] = strconv.FormatUint(
+				srflxTypePreference<<24|priority&0xffffff, 10)
+			f[7] = "srflx"
+			f = append(f[:8], append([]string{
+				"raddr", fields[4], "rport", fields[5]}, f[8:]...)...)
+		} else if priority > hostTypePreference<<24|0xff {
+			// Just below the host candidate, by local preference.
+			f[3] = strconv.FormatUint(priority-1<<8, 10)
+		}
+		mapped = append(mapped, prefix+strings.Join(f, " "))
+	}
+	return mapped
+}
+
+// Add the candidates for public addresses after their local ones in |sdp|.
+func (config *Configuration) natSdp(sdp string) string {
+	if 0 == len(config.NATMappings) {
+		return sdp
+	}
+	lines := strings.SplitAfter(sdp, "\r\n")
+	var out []string
+	for _, line := range lines {
+		out = append(out, line)
+		candidate := strings.TrimSuffix(line, "\r\n")
+		for _, mapped := range config.natCandidates(candidate) {
+			out = append(out, mapped+"\r\n")
+		}
+	}
+	return strings.Join(out, "")
+}
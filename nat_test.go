@@ -0,0 +1,114 @@
+package webrtc
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNATMapping(t *testing.T) {
+	SetLoggingVerbosity(0)
+
+	host := "candidate:842163049 1 udp 2122260223 10.0.0.5 50000 typ host " +
+		"generation 0 ufrag abcd network-id 1"
+
+	Convey("NATMapping", t, func() {
+		config := NewConfiguration(OptionNATMapping("10.0.0.5", "203.0.113.7"))
+		So(config.NATMappings, ShouldHaveLength, 1)
+		So(config.NATCandidateType, ShouldEqual, IceCandidateTypeHost)
+
+		Convey("Options", func() {
+			So(OptionNATMapping("10.0.0.5", "nope")(config), ShouldNotBeNil)
+			So(OptionNATCandidateType(IceCandidateTypeRelay)(config), ShouldNotBeNil)
+			So(OptionNATCandidateType(IceCandidateTypeSrflx)(config), ShouldBeNil)
+			So(config.NATCandidateType, ShouldEqual, IceCandidateTypeSrflx)
+		})
+
+		Convey("Host candidates", func() {
+			mapped := config.natCandidates(host)
+			So(mapped, ShouldHaveLength, 1)
+			fields := strings.Fields(mapped[0])
+			So(fields[0], ShouldStartWith, "candidate:")
+			So(fields[0], ShouldNotEqual, "candidate:842163049")
+			So(fields[3], ShouldEqual, "2122259967")
+			So(fields[4], ShouldEqual, "203.0.113.7")
+			So(fields[5], ShouldEqual, "50000")
+			So(strings.Join(fields[6:], " "), ShouldEqual,
+				"typ host generation 0 ufrag abcd network-id 1")
+		})
+
+		Convey("Server reflexive candidates", func() {
+			config.NATCandidateType = IceCandidateTypeSrflx
+			mapped := config.natCandidates("a=" + host)
+			So(mapped, ShouldHaveLength, 1)
+			So(mapped[0], ShouldStartWith, "a=candidate:")
+			fields := strings.Fields(mapped[0])
+			So(fields[3], ShouldEqual, "1686052607")
+			So(strings.Join(fields[4:], " "), ShouldEqual,
+				"203.0.113.7 50000 typ srflx raddr 10.0.0.5 rport 50000 "+
+					"generation 0 ufrag abcd network-id 1")
+		})
+
+		Convey("Other candidates are left alone", func() {
+			So(config.natCandidates(strings.Replace(host, "10.0.0.5", "10.0.0.6", 1)),
+				ShouldBeEmpty)
+			So(config.natCandidates("candidate:1 1 udp 1686052607 198.51.100.1 "+
+				"50001 typ srflx raddr 10.0.0.5 rport 50000"), ShouldBeEmpty)
+			So(config.natCandidates("a=mid:data"), ShouldBeEmpty)
+			So(NewConfiguration().natCandidates(host), ShouldBeEmpty)
+		})
+
+		Convey("Descriptions", func() {
+			sdp := "v=0\r\na=mid:data\r\na=" + host + "\r\na=end-of-candidates\r\n"
+			lines := strings.Split(config.natSdp(sdp), "\r\n")
+			So(lines, ShouldHaveLength, 6)
+			So(lines[2], ShouldEqual, "a="+host)
+			So(lines[3], ShouldContainSubstring, " 203.0.113.7 50000 typ host ")
+			So(lines[4], ShouldEqual, "a=end-of-candidates")
+		})
+
+		Convey("Advertised by a PeerConnection", func() {
+			config := NewConfiguration(
+				OptionNATCandidateType(IceCandidateTypeSrflx))
+			addrs, _ := net.InterfaceAddrs()
+			for _, addr := range addrs {
+				if ipnet, ok := addr.(*net.IPNet); ok {
+					OptionNATMapping(ipnet.IP.String(), "203.0.113.7")(config)
+				}
+			}
+			pc, err := NewPeerConnection(config)
+			So(err, ShouldBeNil)
+			mapped := make(chan string, 64)
+			complete := make(chan bool, 1)
+			pc.OnIceCandidate = func(ic IceCandidate) {
+				if strings.Contains(ic.Candidate, " 203.0.113.7 ") {
+					mapped <- ic.Candidate
+				}
+			}
+			pc.OnIceGatheringStateChange = func(s IceGatheringState) {
+				if IceGatheringStateComplete == s {
+					complete <- true
+				}
+			}
+			channel, err := pc.CreateDataChannel("nat")
+			So(err, ShouldBeNil)
+			offer, err := pc.CreateOffer()
+			So(err, ShouldBeNil)
+			So(pc.SetLocalDescription(offer), ShouldBeNil)
+			select {
+			case <-complete:
+			case <-time.After(10 * time.Second):
+				t.Fatal("Timed out.")
+			}
+			So(len(mapped), ShouldBeGreaterThan, 0)
+			So(<-mapped, ShouldContainSubstring, " typ srflx raddr ")
+			So(pc.LocalDescription().Sdp, ShouldContainSubstring,
+				" 203.0.113.7 ")
+			pc.DeleteDataChannel(channel)
+			So(pc.Destroy(), ShouldBeNil)
+		})
+	})
+}
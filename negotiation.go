@@ -0,0 +1,186 @@
+package webrtc
+
+import (
+	"sync"
+)
+
+// SignalMessage is what a PerfectNegotiator exchanges with its remote
+// counterpart over the application's signaling channel. Only one of the fields
+// is set on each message.
+type SignalMessage struct {
+	Description *SessionDescription `json:"description,omitempty"`
+	Candidate   *IceCandidate       `json:"candidate,omitempty"`
+}
+
+/*
+PerfectNegotiator drives the signaling of a PeerConnection following the
+"perfect negotiation" pattern, so that either peer may renegotiate at any time
+without coordinating with the other.
+
+The two peers take different roles. When both send an offer at once, the
+polite peer rolls back its own offer and answers the remote one, while the
+impolite peer ignores the remote offer and waits for its answer.
+
+Rollback is emulated on this version of libwebrtc, and so is only possible
+once an initial offer/answer exchange has completed. Only one peer should
+start the initial negotiation. Nor is the emulated rollback atomic: should it
+fail part way, Receive returns an ErrorTypeInternalError and the polite peer
+is left with its last negotiated offer pending.
+
+See: https://w3c.github.io/webrtc-pc/#perfect-negotiation-example
+*/
+type PerfectNegotiator struct {
+	// Called with any error from negotiation which happens in the background,
+	// such as after OnNegotiationNeeded. If nil, errors are logged instead.
+	OnError func(error)
+
+	pc     *PeerConnection
+	polite bool
+	send   func(SignalMessage) error
+
+	// Serializes the signaling operations, like the operations chain of a
+	// browser's RTCPeerConnection, and guards the fields below. The native
+	// callbacks never take it, as they may fire while it is held.
+	ops         sync.Mutex
+	ignoreOffer bool
+	// Set when Negotiate is called mid-exchange, to offer once it completes,
+	// with the options of every such call.
+	negotiationNeeded bool
+	pendingOptions    []func(*OfferOptions)
+}
+
+// NewPerfectNegotiator wraps |pc|, which should be freshly created, taking
+// over its OnNegotiationNeeded and OnIceCandidate callbacks. Handlers already
+// set on |pc| are still called before the negotiator's own.
+//
+// |send| must deliver each SignalMessage to the remote peer, whose negotiator
+// should pass them to Receive in the same order.
+func NewPerfectNegotiator(pc *PeerConnection, polite bool,
+	send func(SignalMessage) error) *PerfectNegotiator {
+	n := &PerfectNegotiator{
+		pc:     pc,
+		polite: polite,
+		send:   send,
+	}
+	onNegotiationNeeded := pc.OnNegotiationNeeded
+	pc.OnNegotiationNeeded = func() {
+		if nil != onNegotiationNeeded {
+			onNegotiationNeeded()
+		}
+		// SDP operations are fine from here, but n.ops may be held by a
+		// Receive whose SDP operation waits for this callback to return.
+		go func() {
+			n.report(n.Negotiate())
+		}()
+	}
+	onIceCandidate := pc.OnIceCandidate
+	pc.OnIceCandidate = func(ic IceCandidate) {
+		if nil != onIceCandidate {
+			onIceCandidate(ic)
+		}
+		n.report(n.send(SignalMessage{Candidate: &ic}))
+	}
+	return n
+}
+
+// Polite reports whether this peer gives way when offers collide.
+func (n *PerfectNegotiator) Polite() bool {
+	return n.polite
+}
+
+// Negotiate creates and sends an offer with |options|. This happens
+// automatically on OnNegotiationNeeded, but may also be called directly, for
+// example with IceRestart(true).
+//
+// If an offer/answer exchange is already in progress, the offer is sent once
+// it completes instead.
+func (n *PerfectNegotiator) Negotiate(options ...func(*OfferOptions)) error {
+	n.ops.Lock()
+	defer n.ops.Unlock()
+	n.negotiationNeeded = true
+	n.pendingOptions = append(n.pendingOptions, options...)
+	return n.negotiateIfStable()
+}
+
+// Sends an offer if one is owed and no exchange is in progress. Must be
+// called with n.ops held.
+func (n *PerfectNegotiator) negotiateIfStable() error {
+	if !n.negotiationNeeded ||
+		SignalingStateStable != n.pc.SignalingState() {
+		return nil
+	}
+	options := n.pendingOptions
+	n.negotiationNeeded, n.pendingOptions = false, nil
+	offer, err := n.pc.CreateOffer(options...)
+	if nil != err {
+		return err
+	}
+	if err := n.pc.SetLocalDescription(offer); nil != err {
+		return err
+	}
+	return n.send(SignalMessage{Description: n.pc.LocalDescription()})
+}
+
+// Receive applies a SignalMessage sent by the remote peer's negotiator,
+// answering offers and resolving collisions according to this peer's role.
+func (n *PerfectNegotiator) Receive(msg SignalMessage) error {
+	n.ops.Lock()
+	defer n.ops.Unlock()
+	if nil != msg.Description {
+		if err := n.receiveDescription(msg.Description); nil != err {
+			return err
+		}
+		// The exchange may have just completed.
+		return n.negotiateIfStable()
+	}
+	if nil != msg.Candidate {
+		err := n.pc.AddIceCandidate(*msg.Candidate)
+		// Candidates for an ignored offer are expected to fail.
+		if nil != err && !n.ignoreOffer {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *PerfectNegotiator) receiveDescription(desc *SessionDescription) error {
+	offerCollision := "offer" == desc.Type &&
+		SignalingStateStable != n.pc.SignalingState()
+	n.ignoreOffer = !n.polite && offerCollision
+	if n.ignoreOffer {
+		INFO.Println("Ignoring colliding offer.")
+		return nil
+	}
+	if offerCollision {
+		INFO.Println("Rolling back local offer for colliding remote offer.")
+		rollback := &SessionDescription{Type: "rollback"}
+		if err := n.pc.SetLocalDescription(rollback); nil != err {
+			return err
+		}
+	}
+	if err := n.pc.SetRemoteDescription(desc); nil != err {
+		return err
+	}
+	if "offer" != desc.Type {
+		return nil
+	}
+	answer, err := n.pc.CreateAnswer()
+	if nil != err {
+		return err
+	}
+	if err := n.pc.SetLocalDescription(answer); nil != err {
+		return err
+	}
+	return n.send(SignalMessage{Description: n.pc.LocalDescription()})
+}
+
+func (n *PerfectNegotiator) report(err error) {
+	if nil == err {
+		return
+	}
+	if nil != n.OnError {
+		n.OnError(err)
+		return
+	}
+	WARN.Println("PerfectNegotiator:", err)
+}
@@ -0,0 +1,153 @@
+package webrtc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A signaling channel to a PerfectNegotiator, which records the types of
+// the descriptions sent over it.
+type pipe struct {
+	msgs chan SignalMessage
+	// Held to stop delivery, so that messages pile up in flight.
+	hold  sync.Mutex
+	lock  sync.Mutex
+	types []string
+}
+
+// Deliver each SignalMessage passed to send to |n|, in order.
+func pipeTo(n **PerfectNegotiator, errs chan error) *pipe {
+	p := &pipe{msgs: make(chan SignalMessage, 64)}
+	go func() {
+		for msg := range p.msgs {
+			p.hold.Lock()
+			p.hold.Unlock()
+			if err := (*n).Receive(msg); nil != err {
+				errs <- err
+			}
+		}
+	}()
+	return p
+}
+
+func (p *pipe) send(msg SignalMessage) error {
+	if nil != msg.Description {
+		p.lock.Lock()
+		p.types = append(p.types, msg.Description.Type)
+		p.lock.Unlock()
+	}
+	p.msgs <- msg
+	return nil
+}
+
+// The types of the descriptions sent so far.
+func (p *pipe) sent() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string(nil), p.types...)
+}
+
+// Wait until both PeerConnections are stable, or fail on any reported error.
+func waitStable(t *testing.T, errs chan error, pcs ...*PeerConnection) {
+	timeout := time.After(time.Second * 10)
+	for {
+		stable := true
+		for _, pc := range pcs {
+			stable = stable && SignalingStateStable == pc.SignalingState() &&
+				nil != pc.RemoteDescription()
+		}
+		if stable {
+			return
+		}
+		select {
+		case err := <-errs:
+			So(err, ShouldBeNil)
+		case <-timeout:
+			t.Fatal("Timed out.")
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+}
+
+func TestPerfectNegotiator(t *testing.T) {
+	SetLoggingVerbosity(0)
+
+	Convey("PerfectNegotiator", t, func() {
+		config := NewConfiguration()
+		alice, err := NewPeerConnection(config)
+		So(err, ShouldBeNil)
+		bob, err := NewPeerConnection(config)
+		So(err, ShouldBeNil)
+
+		errs := make(chan error, 16)
+		var polite, impolite *PerfectNegotiator
+		toBob, toAlice := pipeTo(&impolite, errs), pipeTo(&polite, errs)
+		polite = NewPerfectNegotiator(alice, true, toBob.send)
+		impolite = NewPerfectNegotiator(bob, false, toAlice.send)
+		polite.OnError = func(err error) { errs <- err }
+		impolite.OnError = func(err error) { errs <- err }
+		So(polite.Polite(), ShouldBeTrue)
+		So(impolite.Polite(), ShouldBeFalse)
+
+		Convey("Negotiates on OnNegotiationNeeded", func() {
+			channel, err := alice.CreateDataChannel("perfect")
+			So(err, ShouldBeNil)
+			waitStable(t, errs, alice, bob)
+			So(alice.LocalDescription().Type, ShouldEqual, "offer")
+			So(bob.LocalDescription().Type, ShouldEqual, "answer")
+
+			Convey("Resolves colliding offers", func() {
+				// Both offers are in flight before either arrives.
+				toAlice.hold.Lock()
+				toBob.hold.Lock()
+				So(polite.Negotiate(IceRestart(true)), ShouldBeNil)
+				So(impolite.Negotiate(IceRestart(true)), ShouldBeNil)
+				So(alice.SignalingState(), ShouldEqual,
+					SignalingStateHaveLocalOffer)
+				So(bob.SignalingState(), ShouldEqual,
+					SignalingStateHaveLocalOffer)
+				toAlice.hold.Unlock()
+				toBob.hold.Unlock()
+				waitStable(t, errs, alice, bob)
+				// The polite peer rolled back its offer to answer the other,
+				// after the initial exchange.
+				So(len(toBob.sent()), ShouldBeGreaterThanOrEqualTo, 3)
+				So(toBob.sent()[:3], ShouldResemble,
+					[]string{"offer", "offer", "answer"})
+				So(toAlice.sent()[:2], ShouldResemble, []string{"answer", "offer"})
+				So(alice.LocalDescription().Type, ShouldEqual, "answer")
+				So(bob.LocalDescription().Type, ShouldEqual, "offer")
+			})
+
+			Convey("Negotiates again once an exchange completes", func() {
+				toBob.hold.Lock()
+				So(polite.Negotiate(), ShouldBeNil)
+				So(polite.Negotiate(IceRestart(true)), ShouldBeNil)
+				// Only the first offer goes out mid-exchange.
+				So(toBob.sent(), ShouldResemble, []string{"offer", "offer"})
+				toBob.hold.Unlock()
+				timeout := time.After(time.Second * 10)
+				for 3 > len(toBob.sent()) {
+					select {
+					case err := <-errs:
+						So(err, ShouldBeNil)
+					case <-timeout:
+						t.Fatal("Timed out.")
+					case <-time.After(time.Millisecond * 10):
+					}
+				}
+				So(toBob.sent()[:3], ShouldResemble,
+					[]string{"offer", "offer", "offer"})
+				waitStable(t, errs, alice, bob)
+			})
+
+			alice.DeleteDataChannel(channel)
+		})
+
+		So(alice.Destroy(), ShouldBeNil)
+		So(bob.Destroy(), ShouldBeNil)
+	})
+}
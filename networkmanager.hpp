@@ -0,0 +1,127 @@
+#ifndef _NETWORKMANAGER_H_
+#define _NETWORKMANAGER_H_
+
+#include <map>
+#include <memory>
+#include <string>
+#include <vector>
+
+#include "rtc_base/network.h"
+
+// Restricts the networks of a BasicNetworkManager to those on an allowed list
+// of interface names and local addresses. Networks allowed by name keep all
+// of their addresses, and others keep only their allowed addresses.
+//
+// No "any address" networks are offered, so nothing is gathered at all if
+// none of the allowed interfaces or addresses exist.
+class FilteredNetworkManager
+  : public rtc::NetworkManager,
+    public sigslot::has_slots<> {
+ public:
+  // Each entry of |allowed| is either an IP address or an interface name.
+  FilteredNetworkManager(std::unique_ptr<rtc::BasicNetworkManager> base,
+                         const std::vector<std::string>& allowed)
+      : base_(std::move(base)) {
+    for (auto entry : allowed) {
+      rtc::IPAddress ip;
+      if (rtc::IPFromString(entry, &ip)) {
+        addresses_.push_back(ip);
+      } else {
+        names_.push_back(entry);
+      }
+    }
+    base_->SignalNetworksChanged.connect(
+        this, &FilteredNetworkManager::OnNetworksChanged);
+    base_->SignalError.connect(this, &FilteredNetworkManager::OnError);
+  }
+
+  ~FilteredNetworkManager() override {
+    base_->SignalNetworksChanged.disconnect(this);
+    base_->SignalError.disconnect(this);
+  }
+
+  void StartUpdating() override { base_->StartUpdating(); }
+  void StopUpdating() override { base_->StopUpdating(); }
+
+  void GetNetworks(NetworkList* networks) const override {
+    for (auto& n : networks_)
+      networks->push_back(n.second.get());
+  }
+
+  EnumerationPermission enumeration_permission() const override {
+    return base_->enumeration_permission();
+  }
+
+  bool GetDefaultLocalAddress(int family,
+                              rtc::IPAddress* ipaddr) const override {
+    if (!base_->GetDefaultLocalAddress(family, ipaddr))
+      return false;
+    for (auto& n : networks_) {
+      for (auto& ip : n.second->GetIPs()) {
+        if (ip == *ipaddr)
+          return true;
+      }
+    }
+    return false;
+  }
+
+ private:
+  bool allowed(const rtc::IPAddress& ip) const {
+    for (auto& a : addresses_) {
+      if (a == ip)
+        return true;
+    }
+    return false;
+  }
+
+  bool allowed(const rtc::Network& network) const {
+    for (auto& name : names_) {
+      if (name == network.name())
+        return true;
+    }
+    return false;
+  }
+
+  // Rebuilds the filtered list, reusing the Network objects already handed
+  // out for the same key, as NetworkManager requires.
+  void OnNetworksChanged() {
+    NetworkList all;
+    base_->GetNetworks(&all);
+    std::map<std::string, std::unique_ptr<rtc::Network>> networks;
+    for (auto network : all) {
+      std::vector<rtc::InterfaceAddress> ips;
+      for (auto& ip : network->GetIPs()) {
+        if (allowed(*network) || allowed(ip))
+          ips.push_back(ip);
+      }
+      if (ips.empty())
+        continue;
+      auto existing = networks_.find(network->key());
+      std::unique_ptr<rtc::Network> filtered;
+      if (existing != networks_.end()) {
+        filtered = std::move(existing->second);
+      } else {
+        filtered.reset(new rtc::Network(*network));
+      }
+      filtered->SetIPs(ips, false);
+      networks[network->key()] = std::move(filtered);
+    }
+    // Keep the removed networks alive, as ports may still refer to them.
+    for (auto& n : networks_) {
+      if (n.second)
+        removed_.push_back(std::move(n.second));
+    }
+    networks_ = std::move(networks);
+    SignalNetworksChanged();
+  }
+
+  void OnError() { SignalError(); }
+
+  std::unique_ptr<rtc::BasicNetworkManager> base_;
+  std::vector<rtc::IPAddress> addresses_;
+  std::vector<std::string> names_;
+  std::map<std::string, std::unique_ptr<rtc::Network>> networks_;
+  std::vector<std::unique_ptr<rtc::Network>> removed_;
+};
+
+#endif  // _NETWORKMANAGER_H_
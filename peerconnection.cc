@@ -5,21 +5,45 @@
  */
 #include "peerconnection.h"
 #include "datachannel.hpp"
+#include "factory.hpp"
+#include "networkmanager.hpp"
+#include "transport.hpp"
 
+#include <cmath>
 #include <iostream>
+#include <atomic>
 #include <future>
 #include <mutex>
+#include <sstream>
 
-#include "api/audio_codecs/builtin_audio_decoder_factory.h"
-#include "api/audio_codecs/builtin_audio_encoder_factory.h"
-#include "api/test/fakeconstraints.h"
-#include "pc/test/fakeaudiocapturemodule.h"
 #include "api/jsepsessiondescription.h"
 #include "pc/webrtcsdp.h"
+#include "pc/iceserverparsing.h"
+#include "api/stats/rtcstats_objects.h"
+#include "p2p/base/basicpacketsocketfactory.h"
+#include "p2p/client/basicportallocator.h"
+#include "rtc_base/fakenetwork.h"
+#include "rtc_base/proxyinfo.h"
+#include "rtc_base/stringencode.h"
+#include "rtc_base/timeutils.h"
 
 #define SUCCESS 0
 #define FAILURE -1
-#define TIMEOUT_SECS 3
+#define TIMEOUT CGO_TIMEOUT
+
+// How long to process signaling messages at a time, while waiting for an SDP
+// operation requested from the signaling thread itself.
+#define REENTRANT_POLL_MS 10
+
+// How often to check on DTLS transports while they are still connecting,
+// since libwebrtc has no observer for their state.
+#define DTLS_POLL_MS 1000
+
+// rtc::Message ids handled by Peer::OnMessage.
+enum {
+  MSG_DTLS_POLL,
+  MSG_NEGOTIATION_NEEDED,
+};
 
 #define CGO_DBG_ENABLED 0
 #define CGO_DBG_MSG(os, msg) \
@@ -34,6 +58,14 @@ typedef rtc::scoped_refptr<webrtc::PeerConnectionInterface> PC;
 typedef SessionDescriptionInterface* SDP;
 typedef rtc::scoped_refptr<CGoDataChannelObserver> DCObserver;
 
+class Peer;
+
+// A weak reference to a Peer, for native callbacks which can outlive it. Only
+// used on the signaling thread, where the Peer clears it while tearing down.
+struct PeerHandle : public rtc::RefCountInterface {
+  Peer *peer = nullptr;
+};
+
 // Peer acts as the glue between Go PeerConnection and the native
 // webrtc::PeerConnectionInterface. However, it's not directly accessible
 // through CGO, but indirectly through what's available in the more pure
@@ -42,64 +74,29 @@ typedef rtc::scoped_refptr<CGoDataChannelObserver> DCObserver;
 // The Go side may access this class through C.CGO_Peer.
 class Peer
   : public PeerConnectionObserver,
-    public CreateSessionDescriptionObserver {
+    public rtc::MessageHandler,
+    public rtc::RefCountInterface {
  public:
 
   // Expected to be called before anything else happens for Peer.
-  bool Initialize() {
-    promiseSDP = promise<SDP>();
-
-    // Due to the different threading model, in order for PeerConnectionFactory
-    // to be able to post async messages without getting blocked, we need to use
-    // external signalling and worker thread, accounted for in this class.
-    signalling_thread_ = std::unique_ptr<rtc::Thread>(new rtc::Thread());
-    worker_thread_ = std::unique_ptr<rtc::Thread>(new rtc::Thread());
-    signalling_thread_->SetName("CGO Signalling", NULL);
-    worker_thread_->SetName("CGO Worker", NULL);
-    signalling_thread_->Start();  // Must start before being passed to
-    worker_thread_->Start();      // PeerConnectionFactory.
-
-    this->fake_audio_ = FakeAudioCaptureModule::Create();
-    pc_factory = CreatePeerConnectionFactory(
-      worker_thread_.get(),
-      signalling_thread_.get(),
-      this->fake_audio_,
-      CreateBuiltinAudioEncoderFactory(),
-      CreateBuiltinAudioDecoderFactory(),
-      NULL, NULL);
-    if (!pc_factory.get()) {
-      CGO_DBG("Could not create PeerConnectionFactory");
-      return false;
-    }
-
-    // Media constraints are hard-coded here and not exposed in Go, since
-    // in this case, only DTLS/SCTP data channels are desired. If this ever
-    // changes (eg. enabling the Media API) then this will need a Go interface.
-    auto c = new FakeConstraints();
-    c->AddOptional(MediaConstraintsInterface::kEnableDtlsSrtp, true);
-    constraints = c;
-    return true;
+  void Initialize(Factory *factory) {
+    factory_ = factory;
+    handle_ = new rtc::RefCountedObject<PeerHandle>();
+    handle_->peer = this;
   }
 
-  void resetPromise() {
-    promiseSDP = promise<SDP>();
+  // Shared with every other Peer of the same Factory, so callbacks from Go
+  // hold up all of them until they return.
+  rtc::Thread* signalling_thread() {
+    return factory_->signalling_thread();
   }
 
-  //
-  // CreateSessionDescriptionObserver implementation
-  //
-  // These callbacks have been stubbed out using promises + futures, to be
-  // blocking as far as Go is concerned, which allows the usage
-  // of goroutines. This should be easier and more idiomatic for users.
-  //
-  void OnSuccess(SDP desc) {
-    CGO_DBG("SDP successfully created.");
-    promiseSDP.set_value(desc);
+  rtc::Thread* network_thread() {
+    return factory_->network_thread();
   }
 
-  void OnFailure(const std::string& error) {
-    CGO_DBG("SDP Failure: " + error);
-    promiseSDP.set_value(NULL);
+  Factory* factory() {
+    return factory_.get();
   }
 
   //
@@ -140,12 +137,14 @@ class Peer
     if (PeerConnectionInterface::IceConnectionState::kIceConnectionFailed ==
         new_state) {
       cgoOnIceCandidateError(goPeerConnection);
-      return;
     }
     cgoOnIceConnectionStateChange(goPeerConnection, new_state);
-    // The ice connection state is sent to the go callback
-    // which then translates it into RTCPeerConnectionState
-    cgoOnConnectionStateChange(goPeerConnection, new_state);
+    // Go aggregates the ICE and DTLS transport states into the
+    // RTCPeerConnectionState. Report the last known DTLS states right away,
+    // and again once fresh ones arrive.
+    ice_state_ = new_state;
+    ReportTransportStates();
+    RequestDtlsStates();
   }
 
   void OnIceGatheringChange(
@@ -163,27 +162,73 @@ class Peer
     cgoOnDataChannel(goPeerConnection, (void *)o);
   }
 
+  // Fetch the DTLS transport states, which libwebrtc only exposes as stats.
+  // Must be called on the signaling thread.
+  void RequestDtlsStates();
+
+  // Called on the signaling thread with the states from RequestDtlsStates.
+  void OnDtlsStates(const vector<int>& states) {
+    if (destroying_ ||
+        PeerConnectionInterface::kClosed == pc_->signaling_state())
+      return;
+    dtls_states_ = states;
+    ReportTransportStates();
+    // Keep checking while any handshake is in progress, so that DTLS failures
+    // and late completions are noticed without another ICE transition.
+    bool pending = false;
+    for (int s : states) {
+      pending = pending || CGO_DtlsTransportStateNew == s ||
+                CGO_DtlsTransportStateConnecting == s;
+    }
+    if (pending) {
+      signalling_thread()->PostDelayed(RTC_FROM_HERE, DTLS_POLL_MS, this,
+                                       MSG_DTLS_POLL);
+    }
+  }
+
+  void ReportTransportStates() {
+    cgoOnConnectionStateChange(goPeerConnection, ice_state_,
+        const_cast<int*>(dtls_states_.data()), dtls_states_.size());
+  }
+
+  // rtc::MessageHandler, for work posted to the signaling thread.
+  void OnMessage(rtc::Message* msg) {
+    switch (msg->message_id) {
+      case MSG_DTLS_POLL:
+        RequestDtlsStates();
+        break;
+      case MSG_NEGOTIATION_NEEDED:
+        OnRenegotiationNeeded();
+        break;
+    }
+  }
+
   void SetConfig(PeerConnectionInterface::RTCConfiguration *c) {
     if (config)
       delete config;
     config = c;
   }
 
+  // Only touched on the signaling thread.
+  PeerConnectionInterface::IceConnectionState ice_state_ =
+      PeerConnectionInterface::kIceConnectionNew;
+  vector<int> dtls_states_;
+  // Set once destruction starts, since destroying the PeerConnection delivers
+  // any outstanding stats.
+  std::atomic<bool> destroying_{false};
+  // Test helper: the next CreateOffer never hears back, as if libwebrtc hung.
+  std::atomic<bool> stall_next_offer_{false};
+
   // Note that Configuration is where ICE servers are specified.
   PeerConnectionInterface::RTCConfiguration *config = NULL;
-  const FakeConstraints* constraints = NULL;
 
   PC pc_;                  // Pointer to webrtc::PeerConnectionInterface.
   int goPeerConnection;    // Pointer to external Go PeerConnection struct,
                            // which is required to fire callbacks correctly.
 
-  // Pass SDPs through promises instead of callbacks, to allow benefits as
-  // described above. However, this means CreateOffer and CreateAnswer must
-  // not be concurrent to themselves or each other (which isn't expected
-  // anyways), due to the simplistic way futures are used here.
-  promise<SDP> promiseSDP;
-
-  rtc::scoped_refptr<PeerConnectionFactoryInterface> pc_factory;
+  // Used by the port allocator, and so must outlive |pc_|.
+  std::unique_ptr<rtc::NetworkManager> network_manager_;
+  std::unique_ptr<rtc::PacketSocketFactory> socket_factory_;
 
   // Prevent deallocation of created DataChannels, since they are ref_ptr,
   // by keeping track of them in a vector.
@@ -192,62 +237,355 @@ class Peer
 
  protected:
   ~Peer() {
-    SetConfig(NULL);
-    if (constraints)
-      delete constraints;
+    // The signaling thread carries on for other Peers, and may be in the
+    // middle of a callback or message for this one, so tear down there.
+    signalling_thread()->Invoke<void>(RTC_FROM_HERE, [this] { Teardown(); });
+    // The last Peer of a released Factory stops its threads.
+    factory_ = nullptr;
+  }
+
+ private:
+  // Must be called on the signaling thread.
+  void Teardown() {
+    destroying_ = true;
+    handle_->peer = nullptr;
+    // Drop anything still posted to this Peer.
+    signalling_thread()->Clear(this);
 
     // NOTE: Clears these explicitly first since they use the threads
+    o_lock.lock();
     observers.clear();
+    o_lock.unlock();
     pc_ = nullptr;
-    pc_factory = nullptr;
+    network_manager_ = nullptr;
+    socket_factory_ = nullptr;
+    SetConfig(NULL);
+  }
 
-    worker_thread_->Stop();
-    signalling_thread_->Stop();
+  rtc::scoped_refptr<Factory> factory_;
+  // Handed to callbacks in place of the Peer itself.
+  rtc::scoped_refptr<PeerHandle> handle_;
+};  // class Peer
+
+// Collects the DTLS transport states out of a stats report for a Peer, unless
+// it is gone by the time the report is delivered.
+class DtlsStatsCallback : public RTCStatsCollectorCallback {
+ public:
+  explicit DtlsStatsCallback(rtc::scoped_refptr<PeerHandle> peer)
+      : peer_(peer) {}
 
-    worker_thread_ = nullptr;
-    signalling_thread_ = nullptr;
+  void OnStatsDelivered(
+      const rtc::scoped_refptr<const RTCStatsReport>& report) override {
+    vector<int> states;
+    for (auto stats : report->GetStatsOfType<RTCTransportStats>()) {
+      if (stats->dtls_state.is_defined())
+        states.push_back(dtlsStateFromString(*stats->dtls_state));
+    }
+    if (peer_->peer)
+      peer_->peer->OnDtlsStates(states);
   }
 
  private:
-  std::unique_ptr<rtc::Thread> signalling_thread_;
-  std::unique_ptr<rtc::Thread> worker_thread_;
-  rtc::scoped_refptr<AudioDeviceModule> fake_audio_;
-};  // class Peer
+  static int dtlsStateFromString(const std::string& s) {
+    if (RTCDtlsTransportState::kConnecting == s)
+      return CGO_DtlsTransportStateConnecting;
+    if (RTCDtlsTransportState::kConnected == s)
+      return CGO_DtlsTransportStateConnected;
+    if (RTCDtlsTransportState::kClosed == s)
+      return CGO_DtlsTransportStateClosed;
+    if (RTCDtlsTransportState::kFailed == s)
+      return CGO_DtlsTransportStateFailed;
+    return CGO_DtlsTransportStateNew;
+  }
+
+  rtc::scoped_refptr<PeerHandle> peer_;
+};
+
+void Peer::RequestDtlsStates() {
+  if (destroying_ || !pc_ ||
+      PeerConnectionInterface::kClosed == pc_->signaling_state())
+    return;
+  pc_->GetStats(new rtc::RefCountedObject<DtlsStatsCallback>(handle_));
+}
 
 // Keep track of Peers in global scope to prevent deallocation, due to the
 // required scoped_refptr from implementing the Observer interface.
 vector<rtc::scoped_refptr<Peer>> localPeers;
 std::mutex lp_lock;
 
+// Populate |err| for Go with |type| and |message|, and return FAILURE.
+int setError(CGO_Error *err, RTCErrorType type, const std::string& message) {
+  CGO_DBG(message);
+  if (err) {
+    err->type = (int)type;
+    err->message = strdup(message.c_str());
+  }
+  return FAILURE;
+}
+
+// The SDP observers of this libwebrtc only report failures as strings, so
+// recover the closest RTCErrorType from the well-known parts of the messages
+// in pc/peerconnection.cc.
+RTCErrorType errorTypeFromMessage(const std::string& message) {
+  if (message.find("wrong state") != string::npos ||
+      message.find("is closed") != string::npos ||
+      message.find("can't be called before") != string::npos) {
+    return RTCErrorType::INVALID_STATE;
+  }
+  if (message.find("without") != string::npos ||
+      message.find("not matching") != string::npos ||
+      message.find("is NULL") != string::npos ||
+      message.find("Invalid") != string::npos) {
+    return RTCErrorType::INVALID_PARAMETER;
+  }
+  return RTCErrorType::INTERNAL_ERROR;
+}
+
+// The result of an asynchronous libwebrtc operation, which is fulfilled only
+// once: either by the operation's observer, or with a placeholder when the
+// waiting caller gives up first.
+template<typename T>
+class Outcome {
+ public:
+  // Returns false if already fulfilled, in which case |value| is unused.
+  bool Fulfil(T value) {
+    std::lock_guard<std::mutex> lock(lock_);
+    if (fulfilled_)
+      return false;
+    fulfilled_ = true;
+    promise_.set_value(value);
+    return true;
+  }
+  // Wake the caller with |placeholder|, unless the result is already in.
+  void Abandon(T placeholder) {
+    std::lock_guard<std::mutex> lock(lock_);
+    if (fulfilled_)
+      return;
+    fulfilled_ = abandoned_ = true;
+    promise_.set_value(placeholder);
+  }
+  bool abandoned() {
+    std::lock_guard<std::mutex> lock(lock_);
+    return abandoned_;
+  }
+  future<T> *result() { return &future_; }
+
+ private:
+  std::mutex lock_;
+  promise<T> promise_;
+  future<T> future_ = promise_.get_future();
+  bool fulfilled_ = false;
+  bool abandoned_ = false;
+};
+
+// Lets Go give up on a blocking call from another goroutine, once its
+// context is done, so that native code stops waiting on its behalf too.
+class Canceller : public rtc::RefCountInterface {
+ public:
+  // Run |abandon| once cancelled, which may be right away.
+  void OnCancel(std::function<void()> abandon) {
+    std::unique_lock<std::mutex> lock(lock_);
+    if (!cancelled_) {
+      abandon_ = abandon;
+      return;
+    }
+    lock.unlock();
+    abandon();
+  }
+  void Cancel() {
+    std::unique_lock<std::mutex> lock(lock_);
+    cancelled_ = true;
+    std::function<void()> abandon;
+    std::swap(abandon, abandon_);
+    lock.unlock();
+    if (abandon)
+      abandon();
+  }
+
+ protected:
+  Canceller() {}
+  ~Canceller() {}
+
+ private:
+  std::mutex lock_;
+  bool cancelled_ = false;
+  std::function<void()> abandon_;
+};
+
+// Each CreateOffer or CreateAnswer gets its own observer, and thus its own
+// promise, so that overlapping calls never race over a shared result.
+//
+// The callbacks have been stubbed out using promises + futures, to be
+// blocking as far as Go is concerned, which allows the usage
+// of goroutines. This should be easier and more idiomatic for users.
+class PeerCreateSDPObserver : public CreateSessionDescriptionObserver {
+ public:
+  static rtc::scoped_refptr<PeerCreateSDPObserver> Create() {
+    return new rtc::RefCountedObject<PeerCreateSDPObserver>();
+  }
+  virtual void OnSuccess(SDP desc) {
+    CGO_DBG("SDP successfully created.");
+    // A late SDP is freed instead of leaked.
+    if (stalled_ || !outcome.Fulfil(desc))
+      delete desc;
+  }
+  virtual void OnFailure(const std::string& error) {
+    CGO_DBG("SDP Failure: " + error);
+    if (stalled_)
+      return;
+    error_ = error;
+    outcome.Fulfil(NULL);
+  }
+  // Test helper: drop the result, as if libwebrtc never called back. Must be
+  // called before handing this observer to libwebrtc.
+  void Stall() { stalled_ = true; }
+  // Only valid once |outcome| has been fulfilled with NULL, and not abandoned.
+  const std::string& error() const { return error_; }
+  Outcome<SDP> outcome;
+
+ protected:
+  PeerCreateSDPObserver() {}
+  ~PeerCreateSDPObserver() {}
+
+ private:
+  std::string error_;
+  bool stalled_ = false;
+};  // class PeerCreateSDPObserver
+
 class PeerSDPObserver : public SetSessionDescriptionObserver {
  public:
   static PeerSDPObserver* Create() {
     return new rtc::RefCountedObject<PeerSDPObserver>();
   }
   virtual void OnSuccess() {
-    promiseSet.set_value(0);
+    outcome.Fulfil(SUCCESS);
   }
   virtual void OnFailure(const std::string& error) {
     CGO_DBG("SessionDescription: " + error);
-    promiseSet.set_value(-1);
+    error_ = error;
+    outcome.Fulfil(FAILURE);
   }
-  promise<int> promiseSet = promise<int>();
+  // Only valid once |outcome| has been fulfilled with FAILURE.
+  const std::string& error() const { return error_; }
+  Outcome<int> outcome;
 
  protected:
   PeerSDPObserver() {}
   ~PeerSDPObserver() {}
 
+ private:
+  std::string error_;
 };  // class PeerSDPObserver
 
+// Format |d| as a JSON number, or null if it is NaN or infinite, which JSON
+// can't represent.
+std::string jsonDouble(double d) {
+  return std::isfinite(d) ? rtc::ToString(d) : "null";
+}
+
+// Quote |s| as a JSON string.
+std::string jsonQuote(const std::string& s) {
+  std::ostringstream oss;
+  oss << '"';
+  for (char c : s) {
+    switch (c) {
+      case '"':  oss << "\\\""; break;
+      case '\\': oss << "\\\\"; break;
+      case '\n': oss << "\\n"; break;
+      case '\r': oss << "\\r"; break;
+      case '\t': oss << "\\t"; break;
+      default:
+        if ((unsigned char)c < 0x20) {
+          char buf[8];
+          snprintf(buf, sizeof(buf), "\\u%04x", c);
+          oss << buf;
+        } else {
+          oss << c;
+        }
+    }
+  }
+  oss << '"';
+  return oss.str();
+}
+
+// Serialize |report| as a JSON array of its stats objects, with the members
+// named as in the spec. Unlike RTCStatsReport::ToJson, 64-bit integers keep
+// their full precision.
+std::string statsToJson(const RTCStatsReport& report) {
+  std::ostringstream oss;
+  oss << "[";
+  bool first = true;
+  for (const RTCStats& stats : report) {
+    oss << (first ? "" : ",") << "{\"type\":" << jsonQuote(stats.type())
+        << ",\"id\":" << jsonQuote(stats.id())
+        << ",\"timestamp\":" << stats.timestamp_us();
+    first = false;
+    for (const RTCStatsMemberInterface* member : stats.Members()) {
+      if (!member->is_defined())
+        continue;
+      oss << "," << jsonQuote(member->name()) << ":";
+      switch (member->type()) {
+        case RTCStatsMemberInterface::kString:
+          oss << jsonQuote(
+              *member->cast_to<RTCStatsMember<std::string>>());
+          break;
+        case RTCStatsMemberInterface::kSequenceString: {
+          auto values =
+              *member->cast_to<RTCStatsMember<std::vector<std::string>>>();
+          oss << "[";
+          for (size_t i = 0; i < values.size(); ++i)
+            oss << (i ? "," : "") << jsonQuote(values[i]);
+          oss << "]";
+          break;
+        }
+        case RTCStatsMemberInterface::kDouble:
+          oss << jsonDouble(*member->cast_to<RTCStatsMember<double>>());
+          break;
+        case RTCStatsMemberInterface::kSequenceDouble: {
+          auto values =
+              *member->cast_to<RTCStatsMember<std::vector<double>>>();
+          oss << "[";
+          for (size_t i = 0; i < values.size(); ++i)
+            oss << (i ? "," : "") << jsonDouble(values[i]);
+          oss << "]";
+          break;
+        }
+        default:
+          // Integers, booleans, and sequences of those.
+          oss << member->ValueToString();
+      }
+    }
+    oss << "}";
+  }
+  oss << "]";
+  return oss.str();
+}
+
+class PeerStatsCallback : public RTCStatsCollectorCallback {
+ public:
+  static PeerStatsCallback* Create() {
+    return new rtc::RefCountedObject<PeerStatsCallback>();
+  }
+  void OnStatsDelivered(
+      const rtc::scoped_refptr<const RTCStatsReport>& report) override {
+    outcome.Fulfil(statsToJson(*report));
+  }
+  Outcome<std::string> outcome;
+
+ protected:
+  PeerStatsCallback() {}
+  ~PeerStatsCallback() {}
+};  // class PeerStatsCallback
+
 //
 // extern "C" Go-accessible functions:
 //
 
 // Create and return the Peer object, which provides initial native code
 // glue for the PeerConnection constructor.
-CGO_Peer CGO_InitializePeer(int goPc) {
+CGO_Peer CGO_InitializePeer(int goPc, CGO_Factory factory) {
   rtc::scoped_refptr<Peer> localPeer = new rtc::RefCountedObject<Peer>();
-  localPeer->Initialize();
+  localPeer->Initialize((Factory*)factory);
   lp_lock.lock();
   localPeers.push_back(localPeer);
   lp_lock.unlock();
@@ -257,17 +595,24 @@ CGO_Peer CGO_InitializePeer(int goPc) {
 
 void CGO_DestroyPeer(CGO_Peer cgoPeer) {
   auto cPeer = (Peer*)cgoPeer;
+  // The last reference may be released below, which tears the Peer down on
+  // the signaling thread. That must not happen with |lp_lock| held, since a
+  // callback there may be creating another Peer.
+  rtc::scoped_refptr<Peer> destroyed;
   lp_lock.lock();
-  localPeers.erase(
-    std::remove_if(localPeers.begin(), localPeers.end(), [cPeer](rtc::scoped_refptr<Peer> localPeer){
-      return localPeer.get() == cPeer;
-    }),
-    localPeers.end()
-  );
+  auto it = std::find_if(localPeers.begin(), localPeers.end(),
+      [cPeer](const rtc::scoped_refptr<Peer>& localPeer) {
+    return localPeer.get() == cPeer;
+  });
+  if (it != localPeers.end()) {
+    destroyed = *it;
+    localPeers.erase(it);
+  }
   lp_lock.unlock();
 }
 
-// This helper converts RTCConfiguration struct from GO to C++.
+// This helper converts RTCConfiguration struct from GO to C++. Returns NULL if
+// the configuration is invalid.
 PeerConnectionInterface::RTCConfiguration *castConfig_(
     CGO_Configuration *cgoConfig) {
   PeerConnectionInterface::RTCConfiguration* c =
@@ -284,17 +629,43 @@ PeerConnectionInterface::RTCConfiguration *castConfig_(
     is.urls = urls;
     is.username = s.username;
     is.password = s.credential;
+    is.tls_cert_policy = (PeerConnectionInterface::TlsCertPolicy)
+        s.tlsCertPolicy;
+    is.hostname = s.hostname;
     c->servers.push_back(is);
   }
 
+  // Certificates arrive as PEM, so that Go can persist them.
+  vector<CGO_Certificate> certs(cgoConfig->certificates,
+      cgoConfig->certificates + cgoConfig->numCertificates);
+  for (auto pem : certs) {
+    auto cert = rtc::RTCCertificate::FromPEM(
+        rtc::RTCCertificatePEM(pem.privateKey, pem.certificate));
+    if (!cert) {
+      CGO_DBG("Invalid certificate.");
+      delete c;
+      return NULL;
+    }
+    c->certificates.push_back(cert);
+  }
+
   // Cast Go const "enums" to C++ Enums.
   c->type = (PeerConnectionInterface::IceTransportsType)
       cgoConfig->iceTransportPolicy;
   c->bundle_policy = (PeerConnectionInterface::
       BundlePolicy)cgoConfig->bundlePolicy;
-  // TODO: [ED] extensions. Corresponding enum in configuration.go.
-  // c->rtcp_mux_policy = (PeerConnectionInterface::
-      // RtcpMuxPolicy)cgoConfig->RtcpMuxPolicy;
+  c->ice_candidate_pool_size = cgoConfig->iceCandidatePoolSize;
+  // Only DTLS/SCTP data channels are desired, which used to be requested
+  // through the deprecated media constraints.
+  c->enable_dtls_srtp = rtc::Optional<bool>(true);
+  c->rtcp_mux_policy = (PeerConnectionInterface::
+      RtcpMuxPolicy)cgoConfig->rtcpMuxPolicy;
+  c->sdp_semantics = (SdpSemantics)cgoConfig->sdpSemantics;
+  c->disable_ipv6 = cgoConfig->disableIPv6;
+  c->tcp_candidate_policy = (PeerConnectionInterface::TcpCandidatePolicy)
+      cgoConfig->tcpCandidatePolicy;
+  c->continual_gathering_policy = (PeerConnectionInterface::
+      ContinualGatheringPolicy)cgoConfig->continualGatheringPolicy;
   return c;
 }
 
@@ -302,14 +673,81 @@ PeerConnectionInterface::RTCConfiguration *castConfig_(
 // Returns 0 on Success.
 int CGO_CreatePeerConnection(CGO_Peer cgoPeer, CGO_Configuration *cgoConfig) {
   Peer *peer = (Peer*)cgoPeer;
-  peer->SetConfig(castConfig_(cgoConfig));
-  peer->pc_ = peer->pc_factory->CreatePeerConnection(
-    *peer->config,
-    peer->constraints,
-    NULL,  // port allocator      (reasonable default already within)
-    NULL,  // dtls identity store (reasonable default already within)
-    peer   // "observer"
-    );
+  auto cConfig = castConfig_(cgoConfig);
+  if (!cConfig)
+    return FAILURE;
+  peer->SetConfig(cConfig);
+
+  // The default port allocator can't be restricted to a port range, or to
+  // particular interfaces, so set up an equivalent one which can.
+  std::unique_ptr<rtc::BasicNetworkManager> networks(
+      new rtc::BasicNetworkManager());
+  vector<string> ignored(cgoConfig->ignoredInterfaces,
+      cgoConfig->ignoredInterfaces + cgoConfig->numIgnoredInterfaces);
+  networks->set_network_ignore_list(ignored);
+  if (cgoConfig->numTransportAddresses > 0) {
+    // A Go Transport's own addresses stand in for the host's interfaces.
+    auto addresses = new rtc::FakeNetworkManager();
+    for (int i = 0; i < cgoConfig->numTransportAddresses; i++) {
+      addresses->AddInterface(
+          rtc::SocketAddress(cgoConfig->transportAddresses[i], 0),
+          "transport" + rtc::ToString(i));
+    }
+    peer->network_manager_.reset(addresses);
+  } else if (cgoConfig->numLocalAddresses > 0) {
+    vector<string> allowed(cgoConfig->localAddresses,
+        cgoConfig->localAddresses + cgoConfig->numLocalAddresses);
+    peer->network_manager_.reset(
+        new FilteredNetworkManager(std::move(networks), allowed));
+  } else {
+    peer->network_manager_ = std::move(networks);
+  }
+  if (cgoConfig->transport) {
+    peer->socket_factory_.reset(new TransportPacketSocketFactory(
+        peer->network_thread(), cgoConfig->transport));
+  } else {
+    peer->socket_factory_.reset(
+        new rtc::BasicPacketSocketFactory(peer->network_thread()));
+  }
+  std::unique_ptr<cricket::PortAllocator> allocator(
+      new cricket::BasicPortAllocator(peer->network_manager_.get(),
+                                      peer->socket_factory_.get()));
+  if (!allocator->SetPortRange(cgoConfig->minPort, cgoConfig->maxPort)) {
+    CGO_DBG("Invalid port range.");
+    return FAILURE;
+  }
+  CGO_Proxy cProxy = cgoConfig->proxy;
+  if (rtc::PROXY_NONE != cProxy.type) {
+    rtc::ProxyInfo proxy;
+    proxy.type = (rtc::ProxyType)cProxy.type;
+    if (!proxy.address.FromString(cProxy.address)) {
+      CGO_DBG("Invalid proxy address.");
+      return FAILURE;
+    }
+    proxy.username = cProxy.username;
+    rtc::InsecureCryptStringImpl password;
+    password.password() = cProxy.password;
+    proxy.password = rtc::CryptString(password);
+    allocator->set_proxy(cProxy.userAgent, proxy);
+  }
+  // Applied to the port allocator by the PeerConnection, so other Peers of
+  // the Factory must wait to set their own.
+  {
+    std::lock_guard<std::mutex> lock(peer->factory()->options_lock);
+    PeerConnectionFactoryInterface::Options options =
+        peer->factory()->options;
+    options.network_ignore_mask |= cgoConfig->networkIgnoreMask;
+    // SCTP only runs over DTLS, so without encryption, DataChannels can only
+    // be carried over RTP.
+    peer->config->enable_rtp_data_channel = options.disable_encryption;
+    peer->factory()->pc_factory()->SetOptions(options);
+    peer->pc_ = peer->factory()->pc_factory()->CreatePeerConnection(
+      *peer->config,
+      std::move(allocator),
+      nullptr,  // dtls identity store (reasonable default already within)
+      peer      // "observer"
+      );
+  }
 
   if (!peer->pc_.get()) {
     CGO_DBG("Could not create PeerConnection.");
@@ -318,51 +756,122 @@ int CGO_CreatePeerConnection(CGO_Peer cgoPeer, CGO_Configuration *cgoConfig) {
   return SUCCESS;
 }
 
-bool SDPtimeout(future<SDP> *f, int seconds) {
-  auto status = f->wait_for(chrono::seconds(TIMEOUT_SECS));
-  return future_status::ready != status;
+// Waits on |f| for at most |timeout_ms| milliseconds, or indefinitely if
+// |timeout_ms| is negative. Returns true if the future is still not ready.
+//
+// libwebrtc completes these operations by posting to the signaling thread,
+// which is also the thread all Go callbacks fire on. So when called from
+// within a callback, blocking here would deadlock; instead, keep processing
+// the signaling thread's messages until the result arrives.
+template<typename T>
+bool timedOut(Peer *peer, future<T> *f, int timeout_ms) {
+  auto signalling = peer->signalling_thread();
+  if (!signalling->IsCurrent()) {
+    if (timeout_ms < 0) {
+      f->wait();
+      return false;
+    }
+    auto status = f->wait_for(chrono::milliseconds(timeout_ms));
+    return future_status::ready != status;
+  }
+  CGO_DBG("Reentrant call on the signaling thread.");
+  int64_t start = rtc::TimeMillis();
+  while (future_status::ready != f->wait_for(chrono::seconds(0))) {
+    int cms = REENTRANT_POLL_MS;
+    if (timeout_ms >= 0) {
+      int remaining = timeout_ms - (int)(rtc::TimeMillis() - start);
+      if (remaining <= 0)
+        return true;
+      cms = std::min(cms, remaining);
+    }
+    if (!signalling->ProcessMessages(cms))
+      return future_status::ready != f->wait_for(chrono::seconds(0));
+  }
+  return false;
 }
 
-// PeerConnection::CreateOffer
-// Blocks until libwebrtc succeeds in generating the SDP offer,
-// @returns SDP (pointer), or NULL on timeeout.
-CGO_sdpString CGO_CreateOffer(CGO_Peer cgoPeer) {
-  Peer* peer = (Peer*)cgoPeer;
-  auto r = peer->promiseSDP.get_future();
-  peer->pc_->CreateOffer(peer, peer->constraints);
-  if (SDPtimeout(&r, TIMEOUT_SECS)) {
-    CGO_DBG("CreateOffer timed out after " + TIMEOUT_SECS);
-    peer->resetPromise();
-    return NULL;
+// Waits on |outcome| like timedOut, but also gives up once |canceller| is
+// cancelled, if there is one. Either way, |outcome| is then abandoned with
+// |placeholder|, unless its result came in first. |owner| keeps |outcome|
+// alive for as long as |canceller| may still abandon it.
+template<typename T>
+T waitOutcome(Peer *peer, rtc::scoped_refptr<rtc::RefCountInterface> owner,
+              Outcome<T> *outcome, CGO_Canceller canceller, int timeout_ms,
+              T placeholder) {
+  if (canceller) {
+    ((Canceller*)canceller)->OnCancel([owner, outcome, placeholder]() {
+      outcome->Abandon(placeholder);
+    });
   }
-  SDP sdp = r.get();  // blocking
-  peer->resetPromise();
+  if (timedOut(peer, outcome->result(), timeout_ms))
+    outcome->Abandon(placeholder);
+  return outcome->result()->get();
+}
+
+// Shared by CreateOffer and CreateAnswer, once |obs| has been handed to
+// libwebrtc. On success, |out| is set to the serialized SDP.
+int waitCreateSDP(Peer *peer, rtc::scoped_refptr<PeerCreateSDPObserver> obs,
+                  CGO_Canceller canceller, int timeout_ms, CGO_sdpString *out,
+                  CGO_Error *err) {
+  SDP sdp = waitOutcome<SDP>(peer, obs, &obs->outcome, canceller, timeout_ms,
+                             NULL);
+  if (obs->outcome.abandoned())
+    return TIMEOUT;
   if (!sdp)
-    return NULL;
-  auto s = CGO_SerializeSDP(sdp);
+    return setError(err, errorTypeFromMessage(obs->error()), obs->error());
+  *out = CGO_SerializeSDP(sdp);
   delete sdp;
-  return s;
+  return SUCCESS;
+}
+
+// This helper converts RTCOfferAnswerOptions struct from Go to C++.
+PeerConnectionInterface::RTCOfferAnswerOptions castOfferAnswerOptions_(
+    CGO_OfferAnswerOptions o) {
+  PeerConnectionInterface::RTCOfferAnswerOptions options;
+  options.offer_to_receive_audio = o.offerToReceiveAudio;
+  options.offer_to_receive_video = o.offerToReceiveVideo;
+  options.voice_activity_detection = o.voiceActivityDetection;
+  options.ice_restart = o.iceRestart;
+  options.use_rtp_mux = o.useRtpMux;
+  return options;
+}
+
+// PeerConnection::CreateOffer
+// Blocks until libwebrtc succeeds in generating the SDP offer, or until
+// |timeout_ms| elapses or |canceller| is cancelled. On success, |out| is set
+// to the serialized SDP. May be called concurrently, and from within
+// callbacks.
+// @returns SUCCESS, FAILURE, or TIMEOUT.
+int CGO_CreateOffer(CGO_Peer cgoPeer, CGO_OfferAnswerOptions options,
+                    CGO_Canceller canceller, int timeout_ms,
+                    CGO_sdpString *out, CGO_Error *err) {
+  Peer* peer = (Peer*)cgoPeer;
+  auto obs = PeerCreateSDPObserver::Create();
+  if (peer->stall_next_offer_.exchange(false))
+    obs->Stall();
+  peer->pc_->CreateOffer(obs, castOfferAnswerOptions_(options));
+  int r = waitCreateSDP(peer, obs, canceller, timeout_ms, out, err);
+  if (TIMEOUT == r)
+    CGO_DBG("CreateOffer timed out after " << timeout_ms << "ms");
+  return r;
 }
 
 // PeerConnection::CreateAnswer
-// Blocks until libwebrtc succeeds in generating the SDP answer.
-// @returns SDP, or NULL on timeout.
-CGO_sdpString CGO_CreateAnswer(CGO_Peer cgoPeer) {
+// Blocks until libwebrtc succeeds in generating the SDP answer, or until
+// |timeout_ms| elapses or |canceller| is cancelled. On success, |out| is set
+// to the serialized SDP. May be called concurrently, and from within
+// callbacks.
+// @returns SUCCESS, FAILURE, or TIMEOUT.
+int CGO_CreateAnswer(CGO_Peer cgoPeer, CGO_OfferAnswerOptions options,
+                     CGO_Canceller canceller, int timeout_ms,
+                     CGO_sdpString *out, CGO_Error *err) {
   Peer *peer = (Peer*)cgoPeer;
-  auto r = peer->promiseSDP.get_future();
-  peer->pc_->CreateAnswer(peer, peer->constraints);
-  if (SDPtimeout(&r, TIMEOUT_SECS)) {
-    CGO_DBG("CreateAnswer timed out after " + TIMEOUT_SECS);
-    peer->resetPromise();
-    return NULL;
-  }
-  SDP sdp = r.get();  // blocking
-  peer->resetPromise();
-  if (!sdp)
-    return NULL;
-  auto s = CGO_SerializeSDP(sdp);
-  delete sdp;
-  return s;
+  auto obs = PeerCreateSDPObserver::Create();
+  peer->pc_->CreateAnswer(obs, castOfferAnswerOptions_(options));
+  int r = waitCreateSDP(peer, obs, canceller, timeout_ms, out, err);
+  if (TIMEOUT == r)
+    CGO_DBG("CreateAnswer timed out after " << timeout_ms << "ms");
+  return r;
 }
 
 // Serialize SDP message to a string Go can use.
@@ -373,23 +882,53 @@ CGO_sdpString CGO_SerializeSDP(CGO_sdp sdp) {
   return (CGO_sdpString)strdup(s.c_str());
 }
 
-// Given a fully serialized SDP string |msg|, return a CGO sdp object.
-CGO_sdp CGO_DeserializeSDP(const char *type, const char *msg) {
+// Given a fully serialized SDP string |msg|, return a CGO sdp object, or NULL
+// with |err| populated if it can't be parsed.
+CGO_sdp CGO_DeserializeSDP(const char *type, const char *msg, CGO_Error *err) {
   // TODO: Maybe use an enum instead of string for type.
   auto jsep_sdp = new JsepSessionDescription(type);
-  SdpParseError err;
+  SdpParseError parseError;
   std::string msg_str(msg);
-  SdpDeserialize(msg_str, jsep_sdp, &err);
+  if (!SdpDeserialize(msg_str, jsep_sdp, &parseError)) {
+    delete jsep_sdp;
+    setError(err, RTCErrorType::SYNTAX_ERROR,
+             "Failed to parse SDP: " + parseError.description +
+             " (line: " + parseError.line + ")");
+    return NULL;
+  }
   return (CGO_sdp)jsep_sdp;
 }
 
+// Shared by SetLocalDescription and SetRemoteDescription, which take
+// ownership of |sdp|. Blocks for at most |timeout_ms| milliseconds (negative
+// waits forever), or until |canceller| is cancelled. Giving up does not stop
+// libwebrtc from applying |sdp|.
+int setDescription(Peer *peer, bool local, SDP sdp, CGO_Canceller canceller,
+                   int timeout_ms, CGO_Error *err) {
+  rtc::scoped_refptr<PeerSDPObserver> obs = PeerSDPObserver::Create();
+  if (local) {
+    peer->pc_->SetLocalDescription(obs, sdp);
+  } else {
+    peer->pc_->SetRemoteDescription(obs, sdp);
+  }
+  int r = waitOutcome(peer, obs, &obs->outcome, canceller, timeout_ms,
+                      TIMEOUT);
+  if (TIMEOUT == r) {
+    CGO_DBG("Set" << (local ? "Local" : "Remote") << "Description timed out "
+            "after " << timeout_ms << "ms");
+    return TIMEOUT;
+  }
+  if (SUCCESS != r)
+    return setError(err, errorTypeFromMessage(obs->error()), obs->error());
+  return SUCCESS;
+}
+
 // PeerConnection::SetLocalDescription
-int CGO_SetLocalDescription(CGO_Peer cgoPeer, CGO_sdp sdp) {
-  PC cPC = ((Peer*)cgoPeer)->pc_;
-  auto obs = PeerSDPObserver::Create();
-  auto r = obs->promiseSet.get_future();
-  cPC->SetLocalDescription(obs, (SDP)sdp);
-  return r.get();
+int CGO_SetLocalDescription(CGO_Peer cgoPeer, CGO_sdp sdp,
+                            CGO_Canceller canceller, int timeout_ms,
+                            CGO_Error *err) {
+  return setDescription((Peer*)cgoPeer, true, (SDP)sdp, canceller, timeout_ms,
+                        err);
 }
 
 // PeerConnection::GetLocalDescription
@@ -399,12 +938,88 @@ CGO_sdp CGO_GetLocalDescription(CGO_Peer cgoPeer) {
 }
 
 // PeerConnection::SetRemoteDescription
-int CGO_SetRemoteDescription(CGO_Peer cgoPeer, CGO_sdp sdp) {
-  PC cPC = ((Peer*)cgoPeer)->pc_;
-  auto obs = PeerSDPObserver::Create();
-  auto r = obs->promiseSet.get_future();
-  cPC->SetRemoteDescription(obs, (SDP)sdp);
-  return r.get();
+int CGO_SetRemoteDescription(CGO_Peer cgoPeer, CGO_sdp sdp,
+                             CGO_Canceller canceller, int timeout_ms,
+                             CGO_Error *err) {
+  return setDescription((Peer*)cgoPeer, false, (SDP)sdp, canceller,
+                        timeout_ms, err);
+}
+
+// Copy |desc| as a new description of |type|. An offer's "a=setup:actpass"
+// is not valid in an answer, so when re-typing an offer as an answer, settle
+// its DTLS role as the complement of the role in |other|.
+SDP copyAs(const SessionDescriptionInterface *desc, const std::string& type,
+           const SessionDescriptionInterface *other) {
+  std::string s;
+  desc->ToString(&s);
+  if (type == SessionDescriptionInterface::kAnswer &&
+      desc->type() == SessionDescriptionInterface::kOffer) {
+    std::string o;
+    other->ToString(&o);
+    std::string role = o.find("a=setup:active") != string::npos ?
+        "a=setup:passive" : "a=setup:active";
+    const std::string actpass = "a=setup:actpass";
+    for (size_t i = s.find(actpass); i != string::npos; i = s.find(actpass))
+      s.replace(i, actpass.size(), role);
+  }
+  return CreateSessionDescription(type, s, nullptr);
+}
+
+// PeerConnection::SetLocalDescription / SetRemoteDescription with "rollback".
+//
+// This libwebrtc predates native rollback, so it is emulated by re-applying
+// the current (last negotiated) descriptions, starting with the side which
+// holds the pending offer, which returns the signaling state to stable. This
+// is only possible once an offer/answer exchange has completed.
+//
+// The two steps are not atomic. If re-applying the answer fails, the last
+// negotiated offer is left pending, which is reported as INTERNAL_ERROR.
+int CGO_Rollback(CGO_Peer cgoPeer, CGO_Canceller canceller, int timeout_ms,
+                 CGO_Error *err) {
+  Peer *peer = (Peer*)cgoPeer;
+  PC cPC = peer->pc_;
+  auto state = cPC->signaling_state();
+  if (PeerConnectionInterface::kHaveLocalOffer != state &&
+      PeerConnectionInterface::kHaveRemoteOffer != state) {
+    return setError(err, RTCErrorType::INVALID_STATE,
+                    "Rollback requires a pending offer.");
+  }
+  auto local = cPC->current_local_description();
+  auto remote = cPC->current_remote_description();
+  if (!local || !remote) {
+    return setError(err, RTCErrorType::UNSUPPORTED_OPERATION,
+                    "Rollback before the first completed negotiation is not "
+                    "supported by this libwebrtc.");
+  }
+  const std::string offer = SessionDescriptionInterface::kOffer;
+  const std::string answer = SessionDescriptionInterface::kAnswer;
+  bool localOffer = PeerConnectionInterface::kHaveLocalOffer == state;
+  SDP first = localOffer ? copyAs(local, offer, remote)
+                         : copyAs(remote, offer, local);
+  SDP second = localOffer ? copyAs(remote, answer, local)
+                          : copyAs(local, answer, remote);
+  if (!first || !second) {
+    delete first;
+    delete second;
+    return setError(err, RTCErrorType::INTERNAL_ERROR,
+                    "Could not copy the current descriptions.");
+  }
+  int r = setDescription(peer, localOffer, first, canceller, timeout_ms, err);
+  if (SUCCESS != r) {
+    delete second;
+    return r;
+  }
+  // The last negotiated offer is now pending again, so a failure from here
+  // on does not leave things as they were.
+  CGO_Error answerErr = {};
+  r = setDescription(peer, !localOffer, second, canceller, timeout_ms,
+                     &answerErr);
+  if (FAILURE != r)
+    return r;
+  std::string cause = answerErr.message ? answerErr.message : "";
+  free(answerErr.message);
+  return setError(err, RTCErrorType::INTERNAL_ERROR,
+                  "Rollback left the pending offer re-applied: " + cause);
 }
 
 // PeerConnection::GetRemoteDescription
@@ -413,19 +1028,65 @@ CGO_sdp CGO_GetRemoteDescription(CGO_Peer cgoPeer) {
   return (CGO_sdp)cPC->remote_description();
 }
 
+// Serialize the description returned by |get| on the signaling thread, since
+// it may be replaced by libwebrtc as soon as that thread moves on.
+CGO_sdpString copyDescription(Peer *peer, CGO_sdpString *type,
+    const SessionDescriptionInterface* (PeerConnectionInterface::*get)() const) {
+  return peer->signalling_thread()->Invoke<CGO_sdpString>(RTC_FROM_HERE,
+      [peer, type, get]() -> CGO_sdpString {
+    auto desc = (peer->pc_.get()->*get)();
+    if (!desc)
+      return NULL;
+    *type = (CGO_sdpString)strdup(desc->type().c_str());
+    return CGO_SerializeSDP((CGO_sdp)desc);
+  });
+}
+
+// PeerConnection::pending_local_description
+CGO_sdpString CGO_GetPendingLocalDescription(CGO_Peer cgoPeer,
+                                             CGO_sdpString *type) {
+  return copyDescription((Peer*)cgoPeer, type,
+                         &PeerConnectionInterface::pending_local_description);
+}
+
+// PeerConnection::current_local_description
+CGO_sdpString CGO_GetCurrentLocalDescription(CGO_Peer cgoPeer,
+                                             CGO_sdpString *type) {
+  return copyDescription((Peer*)cgoPeer, type,
+                         &PeerConnectionInterface::current_local_description);
+}
+
+// PeerConnection::pending_remote_description
+CGO_sdpString CGO_GetPendingRemoteDescription(CGO_Peer cgoPeer,
+                                              CGO_sdpString *type) {
+  return copyDescription((Peer*)cgoPeer, type,
+                         &PeerConnectionInterface::pending_remote_description);
+}
+
+// PeerConnection::current_remote_description
+CGO_sdpString CGO_GetCurrentRemoteDescription(CGO_Peer cgoPeer,
+                                              CGO_sdpString *type) {
+  return copyDescription((Peer*)cgoPeer, type,
+                         &PeerConnectionInterface::current_remote_description);
+}
+
 // PeerConnection::AddIceCandidate
-int CGO_AddIceCandidate(CGO_Peer cgoPeer, CGO_IceCandidate *cgoIC) {
+int CGO_AddIceCandidate(CGO_Peer cgoPeer, CGO_IceCandidate *cgoIC,
+                        CGO_Error *err) {
   PC cPC = ((Peer*)cgoPeer)->pc_;
-  SdpParseError *error = nullptr;
-  IceCandidateInterface *ic = webrtc::CreateIceCandidate(
-    string(cgoIC->sdp_mid), cgoIC->sdp_mline_index, string(cgoIC->sdp), error);
-  if (error || !ic) {
-    CGO_DBG("SDP parse error");
-    return FAILURE;
+  SdpParseError parseError;
+  std::unique_ptr<IceCandidateInterface> ic(webrtc::CreateIceCandidate(
+    string(cgoIC->sdp_mid), cgoIC->sdp_mline_index, string(cgoIC->sdp),
+    &parseError));
+  if (!ic) {
+    return setError(err, RTCErrorType::SYNTAX_ERROR,
+                    "Failed to parse ICE candidate: " + parseError.description);
   }
-  if (!cPC->AddIceCandidate(ic)) {
-    CGO_DBG("Problem adding ICE candidate.");
-    return FAILURE;
+  // libwebrtc only refuses well-formed candidates when there is no remote
+  // description to add them to yet, or when already closed.
+  if (!cPC->AddIceCandidate(ic.get())) {
+    return setError(err, RTCErrorType::INVALID_STATE,
+                    "Problem adding ICE candidate.");
   }
   return SUCCESS;
 }
@@ -448,17 +1109,69 @@ int CGO_IceGatheringState(CGO_Peer cgoPeer) {
   return cPC->ice_gathering_state();
 }
 
+// PeerConnection::GetStats, serialized as JSON into |out|, which must be
+// freed by Go.
+int CGO_GetStats(CGO_Peer cgoPeer, CGO_Canceller canceller, int timeout_ms,
+                 char **out, CGO_Error *err) {
+  Peer *peer = (Peer*)cgoPeer;
+  if (PeerConnectionInterface::kClosed == peer->pc_->signaling_state()) {
+    return setError(err, RTCErrorType::INVALID_STATE,
+                    "GetStats called on a closed PeerConnection.");
+  }
+  rtc::scoped_refptr<PeerStatsCallback> callback = PeerStatsCallback::Create();
+  peer->pc_->GetStats(callback);
+  std::string json = waitOutcome<std::string>(
+      peer, callback, &callback->outcome, canceller, timeout_ms, "");
+  if (callback->outcome.abandoned()) {
+    CGO_DBG("GetStats timed out after " << timeout_ms << "ms");
+    return TIMEOUT;
+  }
+  *out = strdup(json.c_str());
+  return SUCCESS;
+}
+
+// Whether |a| and |b| hold the same certificates, in the same order.
+bool sameCertificates(
+    const vector<rtc::scoped_refptr<rtc::RTCCertificate>>& a,
+    const vector<rtc::scoped_refptr<rtc::RTCCertificate>>& b) {
+  if (a.size() != b.size())
+    return false;
+  for (size_t i = 0; i < a.size(); i++) {
+    auto pa = a[i]->ToPEM(), pb = b[i]->ToPEM();
+    if (pa.private_key() != pb.private_key() ||
+        pa.certificate() != pb.certificate())
+      return false;
+  }
+  return true;
+}
+
 // PeerConnection::SetConfiguration
-int CGO_SetConfiguration(CGO_Peer cgoPeer, CGO_Configuration* cgoConfig) {
+int CGO_SetConfiguration(CGO_Peer cgoPeer, CGO_Configuration* cgoConfig,
+                         CGO_Error *err) {
   Peer *peer = (Peer*)cgoPeer;
   auto cConfig = castConfig_(cgoConfig);
-  webrtc::RTCError *error = new webrtc::RTCError();
-  bool success = peer->pc_->SetConfiguration(*cConfig, error);
+  if (!cConfig) {
+    return setError(err, RTCErrorType::INVALID_PARAMETER,
+                    "Invalid certificate in configuration.");
+  }
+  // libwebrtc compares certificates by pointer, and they can't change anyway,
+  // so keep the current ones if the same PEMs were passed in again.
+  if (peer->config && sameCertificates(peer->config->certificates,
+                                       cConfig->certificates)) {
+    cConfig->certificates = peer->config->certificates;
+  }
+  // Chosen by the Factory's options, and fixed since.
+  if (peer->config) {
+    cConfig->enable_rtp_data_channel = peer->config->enable_rtp_data_channel;
+  }
+  webrtc::RTCError error;
+  bool success = peer->pc_->SetConfiguration(*cConfig, &error);
   if (success) {
     peer->SetConfig(cConfig);
     return SUCCESS;
   }
-  return (int) error->type();
+  delete cConfig;
+  return setError(err, error.type(), error.message());
 }
 
 // PeerConnection::CreateDataChannel
@@ -486,6 +1199,33 @@ void* CGO_CreateDataChannel(CGO_Peer cgoPeer, char *label, CGO_DataChannelInit d
   return (void *)o;
 }
 
+// Fire OnNegotiationNeeded from the signaling thread, like libwebrtc does,
+// for renegotiation which libwebrtc doesn't know about, such as ICE restarts.
+void CGO_NegotiationNeeded(CGO_Peer cgoPeer) {
+  Peer *peer = (Peer*)cgoPeer;
+  peer->signalling_thread()->Post(RTC_FROM_HERE, peer, MSG_NEGOTIATION_NEEDED);
+}
+
+CGO_Canceller CGO_NewCanceller() {
+  Canceller *c = new rtc::RefCountedObject<Canceller>();
+  c->AddRef();
+  return c;
+}
+
+void CGO_Cancel(CGO_Canceller canceller) {
+  ((Canceller*)canceller)->Cancel();
+}
+
+void CGO_ReleaseCanceller(CGO_Canceller canceller) {
+  ((Canceller*)canceller)->Release();
+}
+
+// Whether the caller is on the signaling thread, as it is within callbacks, so
+// that Go runs blocking calls there rather than on another thread.
+int CGO_IsSignalingThread(CGO_Peer cgoPeer) {
+  return ((Peer*)cgoPeer)->signalling_thread()->IsCurrent();
+}
+
 // PeerConnection::Close
 void CGO_Close(CGO_Peer peer) {
   auto cPeer = (Peer*)peer;
@@ -515,3 +1255,19 @@ void CGO_fakeIceCandidateError(CGO_Peer peer) {
   cPeer->OnIceConnectionChange(
       PeerConnectionInterface::IceConnectionState::kIceConnectionFailed);
 }
+
+void CGO_fakeStallNextOffer(CGO_Peer peer) {
+  ((Peer*)peer)->stall_next_offer_ = true;
+}
+
+// Validates |url| the way libwebrtc does for RTCConfiguration, with a username
+// and password so that only the URL itself can fail. Returns the RTCErrorType.
+int CGO_ParseIceServerURL(const char *url) {
+  PeerConnectionInterface::IceServer server;
+  server.urls.push_back(std::string(url));
+  server.username = "username";
+  server.password = "password";
+  cricket::ServerAddresses stun_servers;
+  std::vector<cricket::RelayServerConfig> turn_servers;
+  return (int)ParseIceServers({server}, &stun_servers, &turn_servers);
+}
@@ -43,8 +43,9 @@ package webrtc
 */
 import "C"
 import (
-	"errors"
-	"fmt"
+	"context"
+	"sync"
+	"time"
 	"unsafe"
 )
 
@@ -56,14 +57,20 @@ type (
 	PeerConnectionState int
 	IceGatheringState   int
 	IceConnectionState  int
+	DtlsTransportState  int
 )
 
+// PeerConnectionState aggregates the states of the ICE and DTLS transports,
+// and has no native equivalent in this libwebrtc.
+//
+// See: https://w3c.github.io/webrtc-pc/#rtcpeerconnectionstate-enum
 const (
 	PeerConnectionStateNew PeerConnectionState = iota
 	PeerConnectionStateConnecting
 	PeerConnectionStateConnected
 	PeerConnectionStateDisconnected
 	PeerConnectionStateFailed
+	PeerConnectionStateClosed
 )
 
 func (s PeerConnectionState) String() string {
@@ -73,6 +80,26 @@ func (s PeerConnectionState) String() string {
 		"Connected",
 		"Disconnected",
 		"Failed",
+		"Closed",
+	})
+}
+
+// See: https://w3c.github.io/webrtc-pc/#rtcdtlstransportstate-enum
+const (
+	DtlsTransportStateNew DtlsTransportState = iota
+	DtlsTransportStateConnecting
+	DtlsTransportStateConnected
+	DtlsTransportStateClosed
+	DtlsTransportStateFailed
+)
+
+func (s DtlsTransportState) String() string {
+	return EnumToStringSafe(int(s), []string{
+		"New",
+		"Connecting",
+		"Connected",
+		"Closed",
+		"Failed",
 	})
 }
 
@@ -136,66 +163,403 @@ type PeerConnection struct {
 	OnConnectionStateChange    func(PeerConnectionState)
 	OnDataChannel              func(*DataChannel)
 
-	config Configuration
+	config     Configuration
+	configLock sync.Mutex
+	// Fires when the CredentialProviders' credentials need refreshing.
+	credentialTimer *time.Timer
+
+	// Aggregate of the transport states, as last reported by native code.
+	connectionState     PeerConnectionState
+	connectionStateLock sync.Mutex
+
+	// Set by RestartIce until an offer/answer exchange completes.
+	iceRestart     bool
+	iceRestartLock sync.Mutex
 
-	cgoPeer C.CGO_Peer // Native code internals
-	index   int        // Index into the PCMap
+	// Whether the Factory disabled encryption, which moves DataChannels to RTP.
+	encryptionDisabled bool
+
+	cgoPeer   C.CGO_Peer // Native code internals
+	index     int        // Index into the PCMap
+	transport int        // Index into the transportMap, if any
 }
 
 /* Construct a WebRTC PeerConnection.
 
 For a successful connection, provide at least one ICE server (stun or turn)
 in the |Configuration| struct.
+
+It runs on the threads of |factory|, if given, or else of the DefaultFactory.
 */
-func NewPeerConnection(config *Configuration) (*PeerConnection, error) {
+func NewPeerConnection(config *Configuration,
+	factory ...*Factory) (*PeerConnection, error) {
 	if nil == config {
-		return nil, errors.New("PeerConnection requires a Configuration.")
+		return nil, &Error{ErrorTypeInvalidParameter, "NewPeerConnection",
+			"PeerConnection requires a Configuration."}
+	}
+	var f *Factory
+	switch len(factory) {
+	case 0:
+		var err error
+		if f, err = DefaultFactory(); nil != err {
+			return nil, err
+		}
+	case 1:
+		f = factory[0]
+	}
+	if nil == f {
+		return nil, &Error{ErrorTypeInvalidParameter, "NewPeerConnection",
+			"PeerConnection takes at most one non-nil Factory."}
+	}
+	resolved, expires, err := resolveCredentials("NewPeerConnection", *config)
+	if nil != err {
+		return nil, err
 	}
 	pc := new(PeerConnection)
 	pc.index = PCMap.Set(pc)
+	if err = pc.create(f, config, resolved); nil != err {
+		pc.release()
+		return nil, err
+	}
+	pc.configLock.Lock()
+	pc.scheduleCredentialRefresh(expires)
+	pc.configLock.Unlock()
+	INFO.Println("Created PeerConnection: ", pc, pc.cgoPeer)
+	return pc, nil
+}
+
+// Set up the native PeerConnection of |pc| from |config|, with credentials
+// |resolved|. Whatever it gets to is undone by release if it fails.
+func (pc *PeerConnection) create(f *Factory, config *Configuration,
+	resolved Configuration) error {
 	// Internal CGO Peer wraps the native webrtc::PeerConnectionInterface.
-	pc.cgoPeer = C.CGO_InitializePeer(C.int(pc.index))
+	if err := f.initializePeer(pc); nil != err {
+		return err
+	}
 	if nil == pc.cgoPeer {
-		return pc, errors.New("PeerConnection: failed to initialize.")
+		return &Error{ErrorTypeInternalError, "NewPeerConnection",
+			"failed to initialize."}
 	}
 	pc.config = *config
-	cConfig := config._CGO()
+	cConfig := resolved._CGO()
 	defer freeConfig(cConfig)
+	if nil != config.Transport {
+		pc.transport = transportMap.Set(config.Transport)
+		cConfig.transport = C.int(pc.transport)
+		var addresses []string
+		for _, ip := range config.Transport.Addresses() {
+			addresses = append(addresses, ip.String())
+		}
+		cConfig.transportAddresses, cConfig.numTransportAddresses =
+			cStrings(addresses)
+	}
 	if 0 != C.CGO_CreatePeerConnection(pc.cgoPeer, cConfig) {
-		return nil, errors.New("PeerConnection: could not create from config.")
+		return &Error{ErrorTypeInternalError, "NewPeerConnection",
+			"could not create from config."}
 	}
-	INFO.Println("Created PeerConnection: ", pc, pc.cgoPeer)
-	return pc, nil
+	return nil
 }
 
 func (pc *PeerConnection) Destroy() error {
 	err := pc.Close()
-	PCMap.Delete(pc.index)
-	C.CGO_DestroyPeer(pc.cgoPeer)
+	pc.release()
 	return err
 }
 
+// Let go of the native Peer, which holds on to its Factory, and of the Go
+// objects native code refers to.
+func (pc *PeerConnection) release() {
+	PCMap.Delete(pc.index)
+	if nil != pc.cgoPeer {
+		C.CGO_DestroyPeer(pc.cgoPeer)
+	}
+	if 0 != pc.transport {
+		transportMap.Delete(pc.transport)
+	}
+}
+
 //
 // === Session Description Protocol ===
 //
 
+// DefaultTimeout bounds the blocking SDP methods which do not take a
+// context.Context, such as CreateOffer and SetLocalDescription.
+const DefaultTimeout = 3 * time.Second
+
+// Convert the deadline of |ctx|, if any, into the timeout in milliseconds
+// expected by the blocking native methods. Negative means wait indefinitely,
+// or until cancelled.
+func nativeTimeout(ctx context.Context) C.int {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return -1
+	}
+	ms := time.Until(deadline) / time.Millisecond
+	if ms < 0 {
+		return 0
+	}
+	return C.int(ms)
+}
+
+type nativeResult struct {
+	sdp    *SessionDescription
+	status C.int
+	err    error
+}
+
+// Run a blocking native |call| for |op| on its own goroutine, and wait for
+// either its result or for |ctx| to be done. Native code then gives up on the
+// abandoned call too, through the canceller it is passed.
+//
+// From within a callback, which holds the signaling thread, |call| runs on
+// that thread instead, so that native code can keep processing the signaling
+// thread's messages while it waits. On another goroutine, it would wait for
+// the callback to return, which in turn waits for it.
+func (pc *PeerConnection) waitNative(ctx context.Context, op string,
+	call func(C.CGO_Canceller) nativeResult) (*SessionDescription, error) {
+	if err := ctx.Err(); nil != err {
+		return nil, err
+	}
+	done := make(chan nativeResult, 1)
+	// A callback's goroutine stays on the thread it was called from, so this
+	// still holds for |call| below.
+	if 0 != C.CGO_IsSignalingThread(pc.cgoPeer) {
+		done <- callCancellable(ctx, call)
+	} else {
+		go func() {
+			done <- callCancellable(ctx, call)
+		}()
+	}
+	select {
+	case r := <-done:
+		if C.CGO_TIMEOUT == r.status {
+			// Prefer the context's own error when its deadline caused this.
+			if err := ctx.Err(); nil != err {
+				return nil, err
+			}
+			return nil, &Error{Type: ErrorTypeTimeout, Op: op}
+		}
+		return r.sdp, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Run |call| with a native canceller which is cancelled once |ctx| is done,
+// or with none if |ctx| never can be. The canceller is only released once
+// |call| has returned, and nothing can cancel it anymore.
+func callCancellable(ctx context.Context,
+	call func(C.CGO_Canceller) nativeResult) nativeResult {
+	if nil == ctx.Done() {
+		return call(nil)
+	}
+	canceller := C.CGO_NewCanceller()
+	returned := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		select {
+		case <-ctx.Done():
+			C.CGO_Cancel(canceller)
+		case <-returned:
+		}
+	}()
+	r := call(canceller)
+	close(returned)
+	<-watched
+	C.CGO_ReleaseCanceller(canceller)
+	return r
+}
+
+// Call the blocking native |create| function for |op|, which wraps either
+// CGO_CreateOffer or CGO_CreateAnswer, and wrap the result as |sdpType|.
+func (pc *PeerConnection) createSDP(ctx context.Context, op string,
+	sdpType string, create func(C.CGO_Canceller, *C.CGO_sdpString,
+		*C.CGO_Error) C.int) (*SessionDescription, error) {
+	return pc.waitNative(ctx, op, func(canceller C.CGO_Canceller) nativeResult {
+		var sdp C.CGO_sdpString
+		var cErr C.CGO_Error
+		status := create(canceller, &sdp, &cErr)
+		switch status {
+		case 0:
+			return nativeResult{NewSessionDescription(sdpType, sdp), status, nil}
+		case C.CGO_TIMEOUT:
+			return nativeResult{nil, status, nil}
+		}
+		return nativeResult{nil, status, newNativeError(op, &cErr)}
+	})
+}
+
+// Call the blocking native |set| function for |op|, which wraps either
+// CGO_SetLocalDescription or CGO_SetRemoteDescription.
+func (pc *PeerConnection) setSDP(ctx context.Context, op string,
+	sdp *SessionDescription,
+	set func(C.CGO_Canceller, C.CGO_sdp, *C.CGO_Error) C.int) error {
+	if nil == sdp {
+		return &Error{ErrorTypeInvalidParameter, op, "Cannot use nil SessionDescription."}
+	}
+	if err := ctx.Err(); nil != err {
+		return err
+	}
+	cSdp, err := sdp.GoStringToCgoSdp()
+	if nil != err {
+		err.(*Error).Op = op
+		return err
+	}
+	_, err = pc.waitNative(ctx, op, func(canceller C.CGO_Canceller) nativeResult {
+		var cErr C.CGO_Error
+		status := set(canceller, cSdp, &cErr)
+		if 0 != status && C.CGO_TIMEOUT != status {
+			return nativeResult{nil, status, newNativeError(op, &cErr)}
+		}
+		return nativeResult{nil, status, nil}
+	})
+	return err
+}
+
+// OfferOptions corresponds to RTCOfferOptions, and is configured through the
+// variadic helpers passed to CreateOffer, such as IceRestart(true).
+//
+// See: https://w3c.github.io/webrtc-pc/#dom-rtcofferoptions
+type OfferOptions struct {
+	IceRestart             bool
+	VoiceActivityDetection bool
+	// Legacy options which add a media section even without any tracks.
+	// -1 leaves it up to libwebrtc, otherwise 0 or 1.
+	OfferToReceiveAudio int
+	OfferToReceiveVideo int
+	// Whether to BUNDLE audio/video/data together.
+	UseRtpMux bool
+}
+
+// AnswerOptions corresponds to RTCAnswerOptions, and is configured through
+// the variadic helpers passed to CreateAnswer.
+//
+// See: https://w3c.github.io/webrtc-pc/#dom-rtcansweroptions
+type AnswerOptions struct {
+	VoiceActivityDetection bool
+}
+
+// These are the defaults taken from include/api/peerconnectioninterface.h
+func defaultOfferOptions() OfferOptions {
+	return OfferOptions{
+		IceRestart:             false,
+		VoiceActivityDetection: true,
+		OfferToReceiveAudio:    -1,
+		OfferToReceiveVideo:    -1,
+		UseRtpMux:              true,
+	}
+}
+
+func defaultAnswerOptions() AnswerOptions {
+	return AnswerOptions{
+		VoiceActivityDetection: true,
+	}
+}
+
+// IceRestart configures an offer's 'iceRestart' option, which generates new
+// ICE credentials and so restarts ICE once negotiated.
+func IceRestart(restart bool) func(*OfferOptions) {
+	return func(o *OfferOptions) {
+		o.IceRestart = restart
+	}
+}
+
+// OfferVoiceActivityDetection configures an offer's 'voiceActivityDetection'.
+func OfferVoiceActivityDetection(vad bool) func(*OfferOptions) {
+	return func(o *OfferOptions) {
+		o.VoiceActivityDetection = vad
+	}
+}
+
+// AnswerVoiceActivityDetection configures an answer's 'voiceActivityDetection'.
+func AnswerVoiceActivityDetection(vad bool) func(*AnswerOptions) {
+	return func(o *AnswerOptions) {
+		o.VoiceActivityDetection = vad
+	}
+}
+
+// OfferToReceiveAudio configures an offer's legacy 'offerToReceiveAudio'.
+func OfferToReceiveAudio(receive bool) func(*OfferOptions) {
+	return func(o *OfferOptions) {
+		o.OfferToReceiveAudio = boolToInt(receive)
+	}
+}
+
+// OfferToReceiveVideo configures an offer's legacy 'offerToReceiveVideo'.
+func OfferToReceiveVideo(receive bool) func(*OfferOptions) {
+	return func(o *OfferOptions) {
+		o.OfferToReceiveVideo = boolToInt(receive)
+	}
+}
+
+// UseRtpMux configures whether an offer BUNDLEs its media sections.
+func UseRtpMux(mux bool) func(*OfferOptions) {
+	return func(o *OfferOptions) {
+		o.UseRtpMux = mux
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (o *OfferOptions) _CGO() C.CGO_OfferAnswerOptions {
+	return C.CGO_OfferAnswerOptions{
+		offerToReceiveAudio:    C.int(o.OfferToReceiveAudio),
+		offerToReceiveVideo:    C.int(o.OfferToReceiveVideo),
+		voiceActivityDetection: C.int(boolToInt(o.VoiceActivityDetection)),
+		iceRestart:             C.int(boolToInt(o.IceRestart)),
+		useRtpMux:              C.int(boolToInt(o.UseRtpMux)),
+	}
+}
+
+func (o *AnswerOptions) _CGO() C.CGO_OfferAnswerOptions {
+	options := defaultOfferOptions()
+	options.VoiceActivityDetection = o.VoiceActivityDetection
+	return options._CGO()
+}
+
 /*
 CreateOffer prepares an SDP "offer" message, which should be set as the local
 description, then sent to the remote peer over a signalling channel. This
 should only be called by the peer initiating the connection.
 
-This method is blocking, and should occur within a separate goroutine.
+This method is blocking, and gives up with ErrTimeout after DefaultTimeout;
+see CreateOfferContext. It is safe to call concurrently, and from within any of
+the PeerConnection callbacks.
 */
-func (pc *PeerConnection) CreateOffer() (*SessionDescription, error) {
-	sdp := C.CGO_CreateOffer(pc.cgoPeer)
-	if nil == sdp {
-		return nil, errors.New("CreateOffer: could not prepare SDP offer.")
-	}
-	offer := NewSessionDescription("offer", sdp)
-	if offer == nil {
-		return nil, errors.New("CreateOffer: could not prepare SDP offer.")
+func (pc *PeerConnection) CreateOffer(options ...func(*OfferOptions)) (*SessionDescription, error) {
+	return pc.createOffer(context.Background(),
+		C.int(DefaultTimeout/time.Millisecond), options)
+}
+
+// CreateOfferContext is like CreateOffer, but waits for as long as |ctx|
+// allows, returning ctx.Err() if it is cancelled or its deadline passes.
+func (pc *PeerConnection) CreateOfferContext(ctx context.Context,
+	options ...func(*OfferOptions)) (*SessionDescription, error) {
+	return pc.createOffer(ctx, nativeTimeout(ctx), options)
+}
+
+func (pc *PeerConnection) createOffer(ctx context.Context, timeout C.int,
+	options []func(*OfferOptions)) (*SessionDescription, error) {
+	o := defaultOfferOptions()
+	pc.iceRestartLock.Lock()
+	o.IceRestart = pc.iceRestart
+	pc.iceRestartLock.Unlock()
+	for _, option := range options {
+		option(&o)
 	}
-	return offer, nil
+	cOptions := o._CGO()
+	return pc.createSDP(ctx, "CreateOffer", "offer",
+		func(canceller C.CGO_Canceller, sdp *C.CGO_sdpString,
+			cErr *C.CGO_Error) C.int {
+			return C.CGO_CreateOffer(pc.cgoPeer, cOptions, canceller, timeout,
+				sdp, cErr)
+		})
 }
 
 /*
@@ -204,32 +568,72 @@ response to an offer received and set as the remote description. Once generated,
 this answer should then be set as the local description and sent back over the
 signaling channel to the remote peer.
 
-This method is blocking, and should occur within a separate goroutine.
+This method is blocking, and gives up with ErrTimeout after DefaultTimeout;
+see CreateAnswerContext. It is safe to call concurrently, and from within any of
+the PeerConnection callbacks.
 */
-func (pc *PeerConnection) CreateAnswer() (*SessionDescription, error) {
-	sdp := C.CGO_CreateAnswer(pc.cgoPeer)
-	if nil == sdp {
-		return nil, errors.New("CreateAnswer failed: could not prepare SDP offer.")
-	}
-	answer := NewSessionDescription("answer", sdp)
-	if answer == nil {
-		return nil, errors.New("CreateAnswer failed: could not prepare SDP offer.")
+func (pc *PeerConnection) CreateAnswer(options ...func(*AnswerOptions)) (*SessionDescription, error) {
+	return pc.createAnswer(context.Background(),
+		C.int(DefaultTimeout/time.Millisecond), options)
+}
+
+// CreateAnswerContext is like CreateAnswer, but waits for as long as |ctx|
+// allows, returning ctx.Err() if it is cancelled or its deadline passes.
+func (pc *PeerConnection) CreateAnswerContext(ctx context.Context,
+	options ...func(*AnswerOptions)) (*SessionDescription, error) {
+	return pc.createAnswer(ctx, nativeTimeout(ctx), options)
+}
+
+func (pc *PeerConnection) createAnswer(ctx context.Context, timeout C.int,
+	options []func(*AnswerOptions)) (*SessionDescription, error) {
+	o := defaultAnswerOptions()
+	for _, option := range options {
+		option(&o)
 	}
-	return answer, nil
+	cOptions := o._CGO()
+	return pc.createSDP(ctx, "CreateAnswer", "answer",
+		func(canceller C.CGO_Canceller, sdp *C.CGO_sdpString,
+			cErr *C.CGO_Error) C.int {
+			return C.CGO_CreateAnswer(pc.cgoPeer, cOptions, canceller, timeout,
+				sdp, cErr)
+		})
 }
 
 /*
 Set a |SessionDescription| as the local description. The description should be
 generated from the local peer's CreateOffer or CreateAnswer, and not be a
 description received over the signaling channel.
+
+A description of type "rollback" undoes a pending local offer, returning to
+the stable signaling state. Rollback is emulated in two steps, which are not
+atomic: if it fails with ErrorTypeInternalError, or times out, the last
+negotiated offer may be left pending instead of the rolled back one.
 */
 func (pc *PeerConnection) SetLocalDescription(sdp *SessionDescription) error {
-	if nil == sdp {
-		return errors.New("Cannot use nil SessionDescription.")
+	return pc.setLocalDescription(context.Background(), sdp,
+		C.int(DefaultTimeout/time.Millisecond))
+}
+
+// SetLocalDescriptionContext is like SetLocalDescription, but waits for as
+// long as |ctx| allows, returning ctx.Err() if it is cancelled or expires.
+//
+// Giving up does not stop libwebrtc from applying |sdp|, which may still
+// happen after ctx.Err() is returned. SignalingState tells whether it did.
+func (pc *PeerConnection) SetLocalDescriptionContext(ctx context.Context, sdp *SessionDescription) error {
+	return pc.setLocalDescription(ctx, sdp, nativeTimeout(ctx))
+}
+
+func (pc *PeerConnection) setLocalDescription(ctx context.Context, sdp *SessionDescription, timeout C.int) error {
+	if nil != sdp && "rollback" == sdp.Type {
+		return pc.rollback(ctx, "SetLocalDescription", SignalingStateHaveLocalOffer, timeout)
 	}
-	r := C.CGO_SetLocalDescription(pc.cgoPeer, sdp.GoStringToCgoSdp())
-	if 0 != r {
-		return errors.New("SetLocalDescription failed.")
+	err := pc.setSDP(ctx, "SetLocalDescription", sdp,
+		func(canceller C.CGO_Canceller, cSdp C.CGO_sdp, cErr *C.CGO_Error) C.int {
+			return C.CGO_SetLocalDescription(pc.cgoPeer, cSdp, canceller,
+				timeout, cErr)
+		})
+	if nil != err {
+		return err
 	}
 	pc.localDescription = sdp
 	return nil
@@ -240,7 +644,8 @@ func (pc *PeerConnection) LocalDescription() (sdp *SessionDescription) {
 	// Refresh SDP; it might have changed by ICE candidate gathering.
 	if pc.localDescription != nil {
 		cgoSdp := C.CGO_GetLocalDescription(pc.cgoPeer)
-		pc.localDescription.Sdp = CgoSdpToGoString(cgoSdp)
+		config := pc.GetConfiguration()
+		pc.localDescription.Sdp = config.natSdp(CgoSdpToGoString(cgoSdp))
 	}
 	return pc.localDescription
 }
@@ -251,16 +656,43 @@ be one generated by the remote peer's CreateOffer or CreateAnswer, received
 over the signaling channel, and not a description created locally.
 
 If the local peer is the answerer, this must be called before CreateAnswer.
+
+A description of type "rollback" undoes a pending remote offer, returning to
+the stable signaling state, with the same caveats as for SetLocalDescription.
 */
 func (pc *PeerConnection) SetRemoteDescription(sdp *SessionDescription) error {
-	if nil == sdp {
-		return errors.New("Cannot use nil SessionDescription.")
+	return pc.setRemoteDescription(context.Background(), sdp,
+		C.int(DefaultTimeout/time.Millisecond))
+}
+
+// SetRemoteDescriptionContext is like SetRemoteDescription, but waits for as
+// long as |ctx| allows, returning ctx.Err() if it is cancelled or expires.
+//
+// Giving up does not stop libwebrtc from applying |sdp|, which may still
+// happen after ctx.Err() is returned. SignalingState tells whether it did.
+func (pc *PeerConnection) SetRemoteDescriptionContext(ctx context.Context, sdp *SessionDescription) error {
+	return pc.setRemoteDescription(ctx, sdp, nativeTimeout(ctx))
+}
+
+func (pc *PeerConnection) setRemoteDescription(ctx context.Context, sdp *SessionDescription, timeout C.int) error {
+	if nil != sdp && "rollback" == sdp.Type {
+		return pc.rollback(ctx, "SetRemoteDescription", SignalingStateHaveRemoteOffer, timeout)
 	}
-	r := C.CGO_SetRemoteDescription(pc.cgoPeer, sdp.GoStringToCgoSdp())
-	if 0 != r {
-		return errors.New("SetRemoteDescription failed.")
+	err := pc.setSDP(ctx, "SetRemoteDescription", sdp,
+		func(canceller C.CGO_Canceller, cSdp C.CGO_sdp, cErr *C.CGO_Error) C.int {
+			return C.CGO_SetRemoteDescription(pc.cgoPeer, cSdp, canceller,
+				timeout, cErr)
+		})
+	if nil != err {
+		return err
 	}
 	pc.remoteDescription = sdp
+	if "answer" == sdp.Type {
+		// Any ICE restart has now been negotiated.
+		pc.iceRestartLock.Lock()
+		pc.iceRestart = false
+		pc.iceRestartLock.Unlock()
+	}
 	return nil
 }
 
@@ -273,6 +705,106 @@ func (pc *PeerConnection) RemoteDescription() (sdp *SessionDescription) {
 	return pc.remoteDescription
 }
 
+// Undo the pending offer, which must be in signaling state |pending|, for a
+// "rollback" SessionDescription passed to |op|.
+//
+// libwebrtc does not implement rollback yet, so the native side emulates it by
+// re-applying the last negotiated descriptions. This means rollback fails with
+// ErrUnsupportedOperation if no offer/answer exchange has completed before.
+// It also means a rollback can be left half done; see SetLocalDescription.
+func (pc *PeerConnection) rollback(ctx context.Context, op string,
+	pending SignalingState, timeout C.int) error {
+	if state := pc.SignalingState(); pending != state {
+		return &Error{ErrorTypeInvalidState, op,
+			"Cannot rollback in signaling state " + state.String() + "."}
+	}
+	_, err := pc.waitNative(ctx, op, func(canceller C.CGO_Canceller) nativeResult {
+		var cErr C.CGO_Error
+		status := C.CGO_Rollback(pc.cgoPeer, canceller, timeout, &cErr)
+		if 0 != status && C.CGO_TIMEOUT != status {
+			return nativeResult{nil, status, newNativeError(op, &cErr)}
+		}
+		return nativeResult{nil, status, nil}
+	})
+	if nil != err {
+		return err
+	}
+	// Whichever side made the pending offer has re-applied its last offer.
+	local, remote := "answer", "offer"
+	if SignalingStateHaveLocalOffer == pending {
+		local, remote = "offer", "answer"
+	}
+	pc.localDescription = &SessionDescription{Type: local,
+		Sdp: CgoSdpToGoString(C.CGO_GetLocalDescription(pc.cgoPeer))}
+	pc.remoteDescription = &SessionDescription{Type: remote,
+		Sdp: CgoSdpToGoString(C.CGO_GetRemoteDescription(pc.cgoPeer))}
+	return nil
+}
+
+// Wrap the copy made by one of the native description getters, which is NULL
+// if there is no such description.
+func copiedDescription(get func(*C.CGO_sdpString) C.CGO_sdpString) *SessionDescription {
+	var sdpType C.CGO_sdpString
+	sdp := get(&sdpType)
+	if nil == sdp {
+		return nil
+	}
+	defer C.free(unsafe.Pointer(sdpType))
+	return NewSessionDescription(C.GoString(sdpType), sdp)
+}
+
+// readonly pendingLocalDescription
+//
+// The local description which is still waiting on an answer, or nil if the
+// signaling state is stable. Unlike LocalDescription, this is a fresh copy on
+// every call.
+func (pc *PeerConnection) PendingLocalDescription() *SessionDescription {
+	return pc.withNATCandidates(copiedDescription(
+		func(t *C.CGO_sdpString) C.CGO_sdpString {
+			return C.CGO_GetPendingLocalDescription(pc.cgoPeer, t)
+		}))
+}
+
+// readonly currentLocalDescription
+//
+// The local description negotiated the last time the signaling state became
+// stable, or nil before the first negotiation completes.
+func (pc *PeerConnection) CurrentLocalDescription() *SessionDescription {
+	return pc.withNATCandidates(copiedDescription(
+		func(t *C.CGO_sdpString) C.CGO_sdpString {
+			return C.CGO_GetCurrentLocalDescription(pc.cgoPeer, t)
+		}))
+}
+
+// Add the candidates of any NATMappings to a local description.
+func (pc *PeerConnection) withNATCandidates(desc *SessionDescription) *SessionDescription {
+	if nil != desc {
+		config := pc.GetConfiguration()
+		desc.Sdp = config.natSdp(desc.Sdp)
+	}
+	return desc
+}
+
+// readonly pendingRemoteDescription
+//
+// The remote description which is still waiting on an answer, or nil if the
+// signaling state is stable.
+func (pc *PeerConnection) PendingRemoteDescription() *SessionDescription {
+	return copiedDescription(func(t *C.CGO_sdpString) C.CGO_sdpString {
+		return C.CGO_GetPendingRemoteDescription(pc.cgoPeer, t)
+	})
+}
+
+// readonly currentRemoteDescription
+//
+// The remote description negotiated the last time the signaling state became
+// stable, or nil before the first negotiation completes.
+func (pc *PeerConnection) CurrentRemoteDescription() *SessionDescription {
+	return copiedDescription(func(t *C.CGO_sdpString) C.CGO_sdpString {
+		return C.CGO_GetCurrentRemoteDescription(pc.cgoPeer, t)
+	})
+}
+
 // readonly signalingState
 func (pc *PeerConnection) SignalingState() SignalingState {
 	return (SignalingState)(C.CGO_GetSignalingState(pc.cgoPeer))
@@ -280,9 +812,79 @@ func (pc *PeerConnection) SignalingState() SignalingState {
 
 // readonly connectionState
 func (pc *PeerConnection) ConnectionState() PeerConnectionState {
-	// TODO: Aggregate states according to:
-	// https://w3c.github.io/webrtc-pc/#rtcpeerconnectionstate-enum
-	return (PeerConnectionState)(C.CGO_IceConnectionState(pc.cgoPeer))
+	pc.connectionStateLock.Lock()
+	defer pc.connectionStateLock.Unlock()
+	return pc.connectionState
+}
+
+// Aggregate the ICE connection state, which libwebrtc already aggregates over
+// the ICE transports, with the states of the DTLS transports.
+//
+// See: https://w3c.github.io/webrtc-pc/#rtcpeerconnectionstate-enum
+func aggregateConnectionState(ice IceConnectionState,
+	dtls []DtlsTransportState) PeerConnectionState {
+	anyDtls := func(states ...DtlsTransportState) bool {
+		for _, d := range dtls {
+			for _, s := range states {
+				if d == s {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	switch {
+	case IceConnectionStateClosed == ice:
+		return PeerConnectionStateClosed
+	case IceConnectionStateFailed == ice || anyDtls(DtlsTransportStateFailed):
+		return PeerConnectionStateFailed
+	case IceConnectionStateDisconnected == ice:
+		return PeerConnectionStateDisconnected
+	case IceConnectionStateNew == ice &&
+		!anyDtls(DtlsTransportStateConnecting, DtlsTransportStateConnected):
+		return PeerConnectionStateNew
+	case IceConnectionStateNew == ice || IceConnectionStateChecking == ice ||
+		anyDtls(DtlsTransportStateNew, DtlsTransportStateConnecting):
+		return PeerConnectionStateConnecting
+	}
+	return PeerConnectionStateConnected
+}
+
+// Update the aggregate connection state, firing OnConnectionStateChange only
+// if it actually changed. Once closed, it stays closed.
+func (pc *PeerConnection) updateTransportStates(ice IceConnectionState,
+	dtls []DtlsTransportState) {
+	state := aggregateConnectionState(ice, dtls)
+	pc.connectionStateLock.Lock()
+	previous := pc.connectionState
+	if PeerConnectionStateClosed != previous {
+		pc.connectionState = state
+	}
+	pc.connectionStateLock.Unlock()
+	if PeerConnectionStateClosed == previous || state == previous {
+		return
+	}
+	INFO.Println("fired OnConnectionStateChange: ", pc.index, state)
+	if nil != pc.OnConnectionStateChange {
+		pc.OnConnectionStateChange(state)
+	}
+}
+
+/*
+RestartIce makes every offer from CreateOffer restart ICE, until an answer is
+applied with SetRemoteDescription, and fires OnNegotiationNeeded so that the
+usual signaling exchange sends such an offer.
+
+This gathers and exchanges new candidates, for example after a NAT mapping has
+died, while keeping the DTLS transport and so every open DataChannel.
+
+See: https://w3c.github.io/webrtc-pc/#dom-rtcpeerconnection-restartice
+*/
+func (pc *PeerConnection) RestartIce() {
+	pc.iceRestartLock.Lock()
+	pc.iceRestart = true
+	pc.iceRestartLock.Unlock()
+	C.CGO_NegotiationNeeded(pc.cgoPeer)
 }
 
 // readonly icegatheringstatee
@@ -306,9 +908,10 @@ func (pc *PeerConnection) AddIceCandidate(ic IceCandidate) error {
 	cIC.sdp_mline_index = C.int(ic.SdpMLineIndex)
 	cIC.sdp = sdp
 
-	r := C.CGO_AddIceCandidate(pc.cgoPeer, cIC)
+	var cErr C.CGO_Error
+	r := C.CGO_AddIceCandidate(pc.cgoPeer, cIC, &cErr)
 	if 0 != r {
-		return errors.New("AddIceCandidate failed.")
+		return newNativeError("AddIceCandidate", &cErr)
 	}
 	return nil
 }
@@ -316,17 +919,36 @@ func (pc *PeerConnection) AddIceCandidate(ic IceCandidate) error {
 func (pc *PeerConnection) GetConfiguration() Configuration {
 	// There does not appear to be a native code version of GetConfiguration -
 	// so we'll keep track of it purely from Go.
+	pc.configLock.Lock()
+	defer pc.configLock.Unlock()
 	return pc.config
 }
 
+// SetConfiguration updates the Configuration, fetching new credentials for any
+// IceServers with a CredentialProvider.
 func (pc *PeerConnection) SetConfiguration(config Configuration) error {
-	cConfig := config._CGO()
+	pc.configLock.Lock()
+	defer pc.configLock.Unlock()
+	return pc.setConfiguration(config)
+}
+
+func (pc *PeerConnection) setConfiguration(config Configuration) error {
+	if field := pc.config.fixedFieldChanged(&config); "" != field {
+		return &Error{ErrorTypeInvalidModification, "SetConfiguration",
+			field + " cannot be changed after creation"}
+	}
+	resolved, expires, err := resolveCredentials("SetConfiguration", config)
+	if nil != err {
+		return err
+	}
+	cConfig := resolved._CGO()
 	defer freeConfig(cConfig)
-	err := C.CGO_SetConfiguration(pc.cgoPeer, cConfig)
-	if err != 0 {
-		return errors.New(fmt.Sprintf("PeerConnection: could not set configuration. Error ID: %d", err))
+	var cErr C.CGO_Error
+	if 0 != C.CGO_SetConfiguration(pc.cgoPeer, cConfig, &cErr) {
+		return newNativeError("SetConfiguration", &cErr)
 	}
 	pc.config = config
+	pc.scheduleCredentialRefresh(expires)
 	return nil
 }
 
@@ -403,11 +1025,17 @@ func (pc *PeerConnection) CreateDataChannel(label string, options ...func(*DataC
 	cfg.maxRetransmits = C.int(init.MaxRetransmits)
 	cfg.maxPacketLifeTime = C.int(init.MaxPacketLifeTime)
 
+	if pc.encryptionDisabled {
+		WARN.Printf("CreateDataChannel: %q goes over RTP, unreliable and "+
+			"unordered, as the Factory disabled encryption. %s\n", label,
+			encryptionDisabledWarning)
+	}
+
 	l := C.CString(label)
 	defer C.free(unsafe.Pointer(l))
 	cDataChannel := C.CGO_CreateDataChannel(pc.cgoPeer, l, cfg)
 	if nil == cDataChannel {
-		return nil, errors.New("Failed to CreateDataChannel")
+		return nil, &Error{Type: ErrorTypeInternalError, Op: "CreateDataChannel"}
 	}
 	// Provide internal Data Channel as reference to create the Go wrapper.
 	dc := NewDataChannel(unsafe.Pointer(cDataChannel))
@@ -421,7 +1049,15 @@ func (pc *PeerConnection) DeleteDataChannel(dc *DataChannel) {
 	return
 }
 
+// Close the PeerConnection. As in the browser, this moves ConnectionState to
+// PeerConnectionStateClosed without firing OnConnectionStateChange.
 func (pc *PeerConnection) Close() error {
+	pc.connectionStateLock.Lock()
+	pc.connectionState = PeerConnectionStateClosed
+	pc.connectionStateLock.Unlock()
+	pc.configLock.Lock()
+	pc.scheduleCredentialRefresh(time.Time{})
+	pc.configLock.Unlock()
 	C.CGO_Close(pc.cgoPeer)
 	return nil
 }
@@ -459,6 +1095,12 @@ func cgoOnIceCandidate(p int, cIC C.CGO_IceCandidate) {
 	pc := PCMap.Get(p).(*PeerConnection)
 	if nil != pc.OnIceCandidate {
 		pc.OnIceCandidate(ic)
+		config := pc.GetConfiguration()
+		for _, candidate := range config.natCandidates(ic.Candidate) {
+			mapped := ic
+			mapped.Candidate = candidate
+			pc.OnIceCandidate(mapped)
+		}
 	}
 }
 
@@ -471,31 +1113,20 @@ func cgoOnIceCandidateError(p int) {
 	}
 }
 
+const maxDtlsTransports = 1 << 16
+
 //export cgoOnConnectionStateChange
-func cgoOnConnectionStateChange(p int, iceState IceConnectionState) {
-	// TODO: This may need to be slightly more complicated...
-	// https://w3c.github.io/webrtc-pc/#rtcpeerconnectionstate-enum
-	var state PeerConnectionState
-	switch iceState {
-	case IceConnectionStateNew:
-		state = PeerConnectionStateNew
-	case IceConnectionStateChecking:
-		state = PeerConnectionStateConnecting
-	case IceConnectionStateConnected:
-		state = PeerConnectionStateConnected
-	case IceConnectionStateFailed:
-		state = PeerConnectionStateFailed
-	case IceConnectionStateDisconnected:
-		state = PeerConnectionStateDisconnected
-	default:
-		return
+func cgoOnConnectionStateChange(p int, ice IceConnectionState,
+	cDtls *C.int, numDtls C.int) {
+	dtls := make([]DtlsTransportState, int(numDtls))
+	if 0 < numDtls {
+		states := (*[maxDtlsTransports]C.int)(unsafe.Pointer(cDtls))[:numDtls:numDtls]
+		for i, s := range states {
+			dtls[i] = DtlsTransportState(s)
+		}
 	}
-
-	INFO.Println("fired OnConnectionStateChange: ", p)
 	pc := PCMap.Get(p).(*PeerConnection)
-	if nil != pc.OnConnectionStateChange {
-		pc.OnConnectionStateChange(state)
-	}
+	pc.updateTransportStates(ice, dtls)
 }
 
 //export cgoOnIceConnectionStateChange
@@ -536,6 +1167,12 @@ var _cgoIceConnectionStateFailed = int(C.CGO_IceConnectionStateFailed)
 var _cgoIceConnectionStateDisconnected = int(C.CGO_IceConnectionStateDisconnected)
 var _cgoIceConnectionStateClosed = int(C.CGO_IceConnectionStateClosed)
 
+var _cgoDtlsTransportStateNew = int(C.CGO_DtlsTransportStateNew)
+var _cgoDtlsTransportStateConnecting = int(C.CGO_DtlsTransportStateConnecting)
+var _cgoDtlsTransportStateConnected = int(C.CGO_DtlsTransportStateConnected)
+var _cgoDtlsTransportStateClosed = int(C.CGO_DtlsTransportStateClosed)
+var _cgoDtlsTransportStateFailed = int(C.CGO_DtlsTransportStateFailed)
+
 var _cgoIceGatheringStateNew = int(C.CGO_IceGatheringStateNew)
 var _cgoIceGatheringStateGathering = int(C.CGO_IceGatheringStateGathering)
 var _cgoIceGatheringStateComplete = int(C.CGO_IceGatheringStateComplete)
@@ -543,3 +1180,7 @@ var _cgoIceGatheringStateComplete = int(C.CGO_IceGatheringStateComplete)
 func cgoFakeIceCandidateError(pc *PeerConnection) {
 	C.CGO_fakeIceCandidateError(pc.cgoPeer)
 }
+
+func cgoFakeStallNextOffer(pc *PeerConnection) {
+	C.CGO_fakeStallNextOffer(pc.cgoPeer)
+}
@@ -3,6 +3,9 @@
 
 #define WEBRTC_POSIX 1
 
+#include "certificate.h"
+#include "factory.h"
+
 #ifdef __cplusplus
 extern "C" {
 #endif
@@ -13,6 +16,13 @@ extern "C" {
 
   typedef void* CGO_Array;
 
+  // Returned by the blocking "C methods" below when libwebrtc did not
+  // complete the operation within the requested timeout, or was cancelled.
+  #define CGO_TIMEOUT -2
+
+  // Cancels a blocking call from another thread. May be NULL.
+  typedef void* CGO_Canceller;
+
   typedef void* CGO_Peer;
   typedef void* CGO_sdp;  // Pointer to SessionDescriptionInterface*
   typedef const char* CGO_sdpString;
@@ -26,63 +36,145 @@ extern "C" {
     int id;
   } CGO_DataChannelInit;
 
+  typedef struct {
+    int offerToReceiveAudio;
+    int offerToReceiveVideo;
+    int voiceActivityDetection;
+    int iceRestart;
+    int useRtpMux;
+  } CGO_OfferAnswerOptions;
+
   typedef struct {
     char **urls;
     int   numUrls;
 
     char  *username;
     char  *credential;
+    int   tlsCertPolicy;
+    char  *hostname;
   } CGO_IceServer;
 
+  // For TURN over TCP and TLS. |address| is "host:port".
+  typedef struct {
+    int   type;
+    char  *address;
+    char  *username;
+    char  *password;
+    char  *userAgent;
+  } CGO_Proxy;
+
   typedef struct {
     CGO_IceServer  *iceServers;
     int            numIceServers;
 
     int            iceTransportPolicy;
     int            bundlePolicy;
-    // [BD] int      RtcpMuxPolicy;
+    int            rtcpMuxPolicy;
+    int            sdpSemantics;
     char           *peerIdentity;
-    // [BD] CGO_Array Certificates;
-    // [BD] int      IceCandidatePoolSize;
+    CGO_Certificate *certificates;
+    int            numCertificates;
+    int            iceCandidatePoolSize;
+
+    // Port allocator controls, fixed once the PeerConnection is created.
+    int            minPort;
+    int            maxPort;
+    int            networkIgnoreMask;
+    char           **ignoredInterfaces;
+    int            numIgnoredInterfaces;
+    char           **localAddresses;
+    int            numLocalAddresses;
+    CGO_Proxy      proxy;
+    // Index of a Go Transport to create sockets through, or 0 for the
+    // host's own. Its addresses, if any, replace the host's interfaces.
+    int            transport;
+    char           **transportAddresses;
+    int            numTransportAddresses;
+    int            disableIPv6;
+    int            tcpCandidatePolicy;
+    int            continualGatheringPolicy;
   } CGO_Configuration;
 
+  // Filled in by the "C methods" below when they fail, mirroring
+  // webrtc::RTCError. |message| is heap allocated, and must be freed by Go.
+  typedef struct {
+    int type;
+    char *message;
+  } CGO_Error;
+
+  // Mirrors RTCDtlsTransportState, which libwebrtc only reports as a string in
+  // RTCTransportStats. Must match DtlsTransportState in Go.
+  enum {
+    CGO_DtlsTransportStateNew,
+    CGO_DtlsTransportStateConnecting,
+    CGO_DtlsTransportStateConnected,
+    CGO_DtlsTransportStateClosed,
+    CGO_DtlsTransportStateFailed,
+  };
+
   typedef struct {
     const char *sdp_mid;
     int sdp_mline_index;
     const char *sdp;
   } CGO_IceCandidate;
 
-  CGO_Peer CGO_InitializePeer(int pc);
+  CGO_Peer CGO_InitializePeer(int pc, CGO_Factory factory);
   void CGO_DestroyPeer(CGO_Peer);
 
   // Below are "C methods" for the Peer class, which must be hidden from cgo.
 
   int CGO_CreatePeerConnection(CGO_Peer, CGO_Configuration*);
 
-  CGO_sdpString CGO_CreateOffer(CGO_Peer);
-  CGO_sdpString CGO_CreateAnswer(CGO_Peer);
+  int CGO_CreateOffer(CGO_Peer, CGO_OfferAnswerOptions, CGO_Canceller,
+                      int timeout_ms, CGO_sdpString *out, CGO_Error *err);
+  int CGO_CreateAnswer(CGO_Peer, CGO_OfferAnswerOptions, CGO_Canceller,
+                       int timeout_ms, CGO_sdpString *out, CGO_Error *err);
 
   CGO_sdpString CGO_SerializeSDP(CGO_sdp);
-  CGO_sdp CGO_DeserializeSDP(const char *type, const char *msg);
+  CGO_sdp CGO_DeserializeSDP(const char *type, const char *msg, CGO_Error *err);
 
-  int CGO_SetLocalDescription(CGO_Peer, CGO_sdp);
+  int CGO_SetLocalDescription(CGO_Peer, CGO_sdp, CGO_Canceller,
+                              int timeout_ms, CGO_Error *err);
   CGO_sdp CGO_GetLocalDescription(CGO_Peer);
-  int CGO_SetRemoteDescription(CGO_Peer, CGO_sdp);
+  int CGO_SetRemoteDescription(CGO_Peer, CGO_sdp, CGO_Canceller,
+                               int timeout_ms, CGO_Error *err);
   CGO_sdp CGO_GetRemoteDescription(CGO_Peer);
-  int CGO_AddIceCandidate(CGO_Peer cgoPeer, CGO_IceCandidate *cgoIC);
+  int CGO_Rollback(CGO_Peer, CGO_Canceller, int timeout_ms, CGO_Error *err);
+
+  // Serialized copies of the pending or current descriptions, or NULL if
+  // there is none. |type| is set too. Both strings must be freed by Go.
+  CGO_sdpString CGO_GetPendingLocalDescription(CGO_Peer, CGO_sdpString *type);
+  CGO_sdpString CGO_GetCurrentLocalDescription(CGO_Peer, CGO_sdpString *type);
+  CGO_sdpString CGO_GetPendingRemoteDescription(CGO_Peer, CGO_sdpString *type);
+  CGO_sdpString CGO_GetCurrentRemoteDescription(CGO_Peer, CGO_sdpString *type);
+
+  int CGO_AddIceCandidate(CGO_Peer cgoPeer, CGO_IceCandidate *cgoIC,
+                          CGO_Error *err);
 
   int CGO_GetSignalingState(CGO_Peer);
   int CGO_IceConnectionState(CGO_Peer);
   int CGO_IceGatheringState(CGO_Peer);
-  int CGO_SetConfiguration(CGO_Peer, CGO_Configuration*);
+  int CGO_GetStats(CGO_Peer, CGO_Canceller, int timeout_ms, char **out,
+                   CGO_Error *err);
+  int CGO_SetConfiguration(CGO_Peer, CGO_Configuration*, CGO_Error *err);
 
   void* CGO_CreateDataChannel(CGO_Peer, char*, CGO_DataChannelInit);
   void CGO_DeleteDataChannel(CGO_Peer, void* l);
 
+  void CGO_NegotiationNeeded(CGO_Peer);
+
+  int CGO_IsSignalingThread(CGO_Peer);
+
+  CGO_Canceller CGO_NewCanceller();
+  void CGO_Cancel(CGO_Canceller);
+  void CGO_ReleaseCanceller(CGO_Canceller);
+
   void CGO_Close(CGO_Peer);
 
   // Test helpers
   void CGO_fakeIceCandidateError(CGO_Peer peer);
+  void CGO_fakeStallNextOffer(CGO_Peer peer);
+  int CGO_ParseIceServerURL(const char *url);
 
 #ifdef __cplusplus
 }
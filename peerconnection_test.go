@@ -1,6 +1,12 @@
 package webrtc
 
 import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -32,6 +38,59 @@ C++ webrtc::PeerConnectionInterface values`, t, func() {
 	})
 }
 
+func TestDtlsTransportStateEnums(t *testing.T) {
+	Convey(`Enum: DtlsTransportState values should match
+C++ CGO_DtlsTransportState values`, t, func() {
+		So(DtlsTransportStateNew, ShouldEqual, _cgoDtlsTransportStateNew)
+		So(DtlsTransportStateConnecting, ShouldEqual,
+			_cgoDtlsTransportStateConnecting)
+		So(DtlsTransportStateConnected, ShouldEqual,
+			_cgoDtlsTransportStateConnected)
+		So(DtlsTransportStateClosed, ShouldEqual, _cgoDtlsTransportStateClosed)
+		So(DtlsTransportStateFailed, ShouldEqual, _cgoDtlsTransportStateFailed)
+	})
+}
+
+func TestAggregateConnectionState(t *testing.T) {
+	Convey("PeerConnectionState aggregates ICE and DTLS states", t, func() {
+		dtls := func(states ...DtlsTransportState) []DtlsTransportState {
+			return states
+		}
+		for _, c := range []struct {
+			ice  IceConnectionState
+			dtls []DtlsTransportState
+			want PeerConnectionState
+		}{
+			{IceConnectionStateNew, nil, PeerConnectionStateNew},
+			{IceConnectionStateNew, dtls(DtlsTransportStateNew,
+				DtlsTransportStateClosed), PeerConnectionStateNew},
+			{IceConnectionStateNew, dtls(DtlsTransportStateConnecting),
+				PeerConnectionStateConnecting},
+			{IceConnectionStateChecking, dtls(DtlsTransportStateNew),
+				PeerConnectionStateConnecting},
+			{IceConnectionStateConnected, dtls(DtlsTransportStateConnecting),
+				PeerConnectionStateConnecting},
+			{IceConnectionStateConnected, dtls(DtlsTransportStateConnected),
+				PeerConnectionStateConnected},
+			{IceConnectionStateCompleted, dtls(DtlsTransportStateConnected,
+				DtlsTransportStateClosed), PeerConnectionStateConnected},
+			{IceConnectionStateConnected, dtls(DtlsTransportStateFailed),
+				PeerConnectionStateFailed},
+			{IceConnectionStateFailed, dtls(DtlsTransportStateConnected),
+				PeerConnectionStateFailed},
+			{IceConnectionStateDisconnected, dtls(DtlsTransportStateConnected),
+				PeerConnectionStateDisconnected},
+			{IceConnectionStateDisconnected, dtls(DtlsTransportStateFailed),
+				PeerConnectionStateFailed},
+			{IceConnectionStateClosed, dtls(DtlsTransportStateFailed),
+				PeerConnectionStateClosed},
+		} {
+			So(aggregateConnectionState(c.ice, c.dtls), ShouldEqual, c.want)
+		}
+		So(PeerConnectionStateClosed.String(), ShouldEqual, "Closed")
+	})
+}
+
 func TestPeerConnection(t *testing.T) {
 	SetLoggingVerbosity(0)
 
@@ -150,17 +209,34 @@ func TestPeerConnection(t *testing.T) {
 						success <- state
 					}
 					cgoOnConnectionStateChange(pc.index,
-						IceConnectionStateNew)
-					expectPeerConnectionState(PeerConnectionStateNew)
+						IceConnectionStateChecking, nil, 0)
+					expectPeerConnectionState(PeerConnectionStateConnecting)
 					cgoOnConnectionStateChange(pc.index,
-						IceConnectionStateConnected)
+						IceConnectionStateConnected, nil, 0)
 					expectPeerConnectionState(PeerConnectionStateConnected)
+					// Completed aggregates the same as Connected, so must not fire.
+					cgoOnConnectionStateChange(pc.index,
+						IceConnectionStateCompleted, nil, 0)
 					cgoOnConnectionStateChange(pc.index,
-						IceConnectionStateFailed)
+						IceConnectionStateFailed, nil, 0)
 					expectPeerConnectionState(PeerConnectionStateFailed)
 					cgoOnConnectionStateChange(pc.index,
-						IceConnectionStateDisconnected)
+						IceConnectionStateDisconnected, nil, 0)
 					expectPeerConnectionState(PeerConnectionStateDisconnected)
+					So(pc.ConnectionState(), ShouldEqual,
+						PeerConnectionStateDisconnected)
+
+					// Close changes the state without firing, and is final.
+					So(pc.Close(), ShouldBeNil)
+					So(pc.ConnectionState(), ShouldEqual, PeerConnectionStateClosed)
+					cgoOnConnectionStateChange(pc.index,
+						IceConnectionStateConnected, nil, 0)
+					So(pc.ConnectionState(), ShouldEqual, PeerConnectionStateClosed)
+					select {
+					case r := <-success:
+						t.Fatal("Unexpected state change:", r)
+					default:
+					}
 				})
 
 				Convey("OnDataChannel", func() {
@@ -213,6 +289,9 @@ func TestPeerConnection(t *testing.T) {
 				So(err, ShouldBeNil)
 				So(alice.LocalDescription(), ShouldEqual, offer)
 				So(alice.SignalingState(), ShouldEqual, SignalingStateHaveLocalOffer)
+				So(alice.PendingLocalDescription().Type, ShouldEqual, "offer")
+				So(alice.CurrentLocalDescription(), ShouldBeNil)
+				So(alice.PendingRemoteDescription(), ShouldBeNil)
 
 				ic := IceCandidate{"fixme", "", 0}
 				err = alice.AddIceCandidate(ic)
@@ -223,6 +302,8 @@ func TestPeerConnection(t *testing.T) {
 					So(err, ShouldBeNil)
 					So(bob.RemoteDescription(), ShouldEqual, offer)
 					So(bob.SignalingState(), ShouldEqual, SignalingStateHaveRemoteOffer)
+					So(bob.PendingRemoteDescription().Type, ShouldEqual, "offer")
+					So(bob.CurrentRemoteDescription(), ShouldBeNil)
 
 					answer, err = bob.CreateAnswer()
 					So(answer, ShouldNotBeNil)
@@ -238,10 +319,354 @@ func TestPeerConnection(t *testing.T) {
 						So(err, ShouldBeNil)
 						So(alice.RemoteDescription(), ShouldEqual, answer)
 						So(alice.SignalingState(), ShouldEqual, SignalingStateStable)
+
+						So(alice.PendingLocalDescription(), ShouldBeNil)
+						So(alice.PendingRemoteDescription(), ShouldBeNil)
+						So(alice.CurrentLocalDescription().Type, ShouldEqual, "offer")
+						So(alice.CurrentRemoteDescription().Type, ShouldEqual, "answer")
+						So(alice.CurrentRemoteDescription().Sdp, ShouldNotBeEmpty)
 					})
 				})
 			})
 
+			Convey("Rollback", func() {
+				rollback := &SessionDescription{Type: "rollback"}
+				// Not possible before the first negotiation has completed.
+				offer, err := alice.CreateOffer()
+				So(err, ShouldBeNil)
+				So(alice.SetLocalDescription(offer), ShouldBeNil)
+				err = alice.SetLocalDescription(rollback)
+				So(errors.Is(err, ErrUnsupportedOperation), ShouldBeTrue)
+				So(alice.SignalingState(), ShouldEqual, SignalingStateHaveLocalOffer)
+
+				So(bob.SetRemoteDescription(offer), ShouldBeNil)
+				answer, err := bob.CreateAnswer()
+				So(err, ShouldBeNil)
+				So(bob.SetLocalDescription(answer), ShouldBeNil)
+				So(alice.SetRemoteDescription(answer), ShouldBeNil)
+
+				// Nothing to roll back when stable.
+				err = alice.SetLocalDescription(rollback)
+				So(errors.Is(err, ErrInvalidState), ShouldBeTrue)
+
+				offer, err = alice.CreateOffer(IceRestart(true))
+				So(err, ShouldBeNil)
+				So(alice.SetLocalDescription(offer), ShouldBeNil)
+				// Only the side with the pending offer can roll back.
+				err = alice.SetRemoteDescription(rollback)
+				So(errors.Is(err, ErrInvalidState), ShouldBeTrue)
+				So(alice.SetLocalDescription(rollback), ShouldBeNil)
+				So(alice.SignalingState(), ShouldEqual, SignalingStateStable)
+				So(alice.LocalDescription().Type, ShouldEqual, "offer")
+				So(alice.RemoteDescription().Type, ShouldEqual, "answer")
+
+				So(bob.SetRemoteDescription(offer), ShouldBeNil)
+				So(bob.SignalingState(), ShouldEqual, SignalingStateHaveRemoteOffer)
+				So(bob.SetRemoteDescription(rollback), ShouldBeNil)
+				So(bob.SignalingState(), ShouldEqual, SignalingStateStable)
+				So(bob.LocalDescription().Type, ShouldEqual, "answer")
+				So(bob.RemoteDescription().Type, ShouldEqual, "offer")
+			})
+
+			Convey("RestartIce", func() {
+				ufrag := func(sdp *SessionDescription) string {
+					for _, line := range strings.Split(sdp.Sdp, "\r\n") {
+						if strings.HasPrefix(line, "a=ice-ufrag:") {
+							return line
+						}
+					}
+					return ""
+				}
+				negotiate := func() *SessionDescription {
+					offer, err := alice.CreateOffer()
+					So(err, ShouldBeNil)
+					So(alice.SetLocalDescription(offer), ShouldBeNil)
+					So(bob.SetRemoteDescription(offer), ShouldBeNil)
+					answer, err := bob.CreateAnswer()
+					So(err, ShouldBeNil)
+					So(bob.SetLocalDescription(answer), ShouldBeNil)
+					So(alice.SetRemoteDescription(answer), ShouldBeNil)
+					return offer
+				}
+				// Needs an m= section to carry ICE credentials.
+				channel, err := alice.CreateDataChannel("restart")
+				So(err, ShouldBeNil)
+				previous := ufrag(negotiate())
+				So(previous, ShouldNotBeEmpty)
+
+				needed := make(chan bool, 1)
+				alice.OnNegotiationNeeded = func() {
+					select {
+					case needed <- true:
+					default:
+					}
+				}
+				alice.RestartIce()
+				select {
+				case <-needed:
+				case <-time.After(time.Second * 1):
+					t.Fatal("Timed out.")
+				}
+				restarted := ufrag(negotiate())
+				So(restarted, ShouldNotEqual, previous)
+
+				// Once negotiated, the next offer keeps the new credentials.
+				offer, err := alice.CreateOffer()
+				So(err, ShouldBeNil)
+				So(ufrag(offer), ShouldEqual, restarted)
+				alice.DeleteDataChannel(channel)
+
+				Convey("An open DataChannel keeps working through it", func() {
+					network := newMemNetwork()
+					carol, err := NewPeerConnection(NewConfiguration(
+						OptionTransport(network.transport("10.0.0.1"))))
+					So(err, ShouldBeNil)
+					defer carol.Destroy()
+					dave, err := NewPeerConnection(NewConfiguration(
+						OptionTransport(network.transport("10.0.0.2"))))
+					So(err, ShouldBeNil)
+					defer dave.Destroy()
+					received := make(chan string, 4)
+					dave.OnDataChannel = func(channel *DataChannel) {
+						channel.OnMessage = func(msg []byte) {
+							received <- string(msg)
+						}
+					}
+					channel, err := carol.CreateDataChannel("restart")
+					So(err, ShouldBeNil)
+					defer carol.DeleteDataChannel(channel)
+					opened := make(chan bool, 1)
+					channel.OnOpen = func() {
+						opened <- true
+					}
+					exchangeDescriptions(carol, dave)
+					select {
+					case <-opened:
+					case <-time.After(time.Second * 10):
+						t.Fatal("Timed out waiting for the DataChannel.")
+					}
+					previous := ufrag(carol.LocalDescription())
+
+					carol.RestartIce()
+					exchangeDescriptions(carol, dave)
+					So(ufrag(carol.LocalDescription()), ShouldNotEqual, previous)
+					So(channel.ReadyState(), ShouldEqual, DataStateOpen)
+					// Sent across the restart, while new candidates connect.
+					for _, msg := range []string{"during", "after"} {
+						channel.SendText(msg)
+						select {
+						case got := <-received:
+							So(got, ShouldEqual, msg)
+						case <-time.After(time.Second * 10):
+							t.Fatal("Timed out waiting for a message.")
+						}
+					}
+					So(channel.ReadyState(), ShouldEqual, DataStateOpen)
+				})
+			})
+
+			Convey("IceCandidatePoolSize", func() {
+				// Time from SetLocalDescription until gathering completes.
+				gatheringTime := func(pc *PeerConnection) time.Duration {
+					complete := make(chan bool, 1)
+					pc.OnIceGatheringStateChange = func(s IceGatheringState) {
+						if IceGatheringStateComplete == s {
+							complete <- true
+						}
+					}
+					channel, err := pc.CreateDataChannel("pool")
+					So(err, ShouldBeNil)
+					defer pc.DeleteDataChannel(channel)
+					offer, err := pc.CreateOffer()
+					So(err, ShouldBeNil)
+					start := time.Now()
+					So(pc.SetLocalDescription(offer), ShouldBeNil)
+					select {
+					case <-complete:
+					case <-time.After(time.Second * 10):
+						t.Fatal("Timed out.")
+					}
+					return time.Since(start)
+				}
+
+				// Otherwise configured the same as each other.
+				unpooled, err := NewPeerConnection(config)
+				So(err, ShouldBeNil)
+				defer unpooled.Destroy()
+				pooledConfig := *config
+				pooledConfig.IceCandidatePoolSize = 1
+				pooled, err := NewPeerConnection(&pooledConfig)
+				So(err, ShouldBeNil)
+				defer pooled.Destroy()
+				// Give the pool time to fill, as a long-lived service would.
+				time.Sleep(time.Second)
+				// Pooled candidates are handed over by SetLocalDescription,
+				// rather than gathered only afterwards.
+				So(gatheringTime(pooled), ShouldBeLessThan, gatheringTime(unpooled))
+
+				// The pool can be resized until SetLocalDescription.
+				resized := *config
+				resized.IceCandidatePoolSize = 2
+				So(bob.SetConfiguration(resized), ShouldBeNil)
+				So(bob.GetConfiguration().IceCandidatePoolSize, ShouldEqual, 2)
+				err = pooled.SetConfiguration(resized)
+				So(errors.Is(err, ErrInvalidModification), ShouldBeTrue)
+			})
+
+			Convey("Unified Plan interoperates for DataChannels", func() {
+				unified := *config
+				unified.SdpSemantics = SdpSemanticsUnifiedPlan
+				carol, err := NewPeerConnection(&unified)
+				So(err, ShouldBeNil)
+				So(carol.GetConfiguration().SdpSemantics, ShouldEqual,
+					SdpSemanticsUnifiedPlan)
+				channel, err := carol.CreateDataChannel("unified")
+				So(err, ShouldBeNil)
+				offer, err := carol.CreateOffer()
+				So(err, ShouldBeNil)
+				So(offer.Sdp, ShouldContainSubstring, "m=application")
+				So(carol.SetLocalDescription(offer), ShouldBeNil)
+				So(alice.SetRemoteDescription(offer), ShouldBeNil)
+				answer, err := alice.CreateAnswer()
+				So(err, ShouldBeNil)
+				So(alice.SetLocalDescription(answer), ShouldBeNil)
+				So(carol.SetRemoteDescription(answer), ShouldBeNil)
+				carol.DeleteDataChannel(channel)
+				So(carol.Destroy(), ShouldBeNil)
+			})
+
+			Convey("Context-aware SDP methods", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				offer, err := alice.CreateOfferContext(ctx)
+				So(offer, ShouldBeNil)
+				So(err, ShouldEqual, context.Canceled)
+
+				ctx, cancel = context.WithTimeout(context.Background(), 0)
+				defer cancel()
+				offer, err = alice.CreateOfferContext(ctx)
+				So(offer, ShouldBeNil)
+				So(err, ShouldEqual, context.DeadlineExceeded)
+
+				ctx, cancel = context.WithTimeout(context.Background(), time.Second*10)
+				defer cancel()
+				offer, err = alice.CreateOfferContext(ctx)
+				So(offer, ShouldNotBeNil)
+				So(err, ShouldBeNil)
+				err = alice.SetLocalDescriptionContext(ctx, offer)
+				So(err, ShouldBeNil)
+				So(alice.SignalingState(), ShouldEqual, SignalingStateHaveLocalOffer)
+
+				err = bob.SetRemoteDescriptionContext(ctx, offer)
+				So(err, ShouldBeNil)
+				answer, err := bob.CreateAnswerContext(ctx)
+				So(answer, ShouldNotBeNil)
+				So(err, ShouldBeNil)
+
+				Convey("Cancelled while in flight", func() {
+					before := runtime.NumGoroutine()
+					cgoFakeStallNextOffer(alice)
+					ctx, cancel := context.WithCancel(context.Background())
+					result := make(chan error, 1)
+					go func() {
+						_, err := alice.CreateOfferContext(ctx)
+						result <- err
+					}()
+					time.Sleep(100 * time.Millisecond)
+					select {
+					case <-result:
+						t.Fatal("The stalled offer returned.")
+					default:
+					}
+					cancel()
+					select {
+					case err := <-result:
+						So(err, ShouldEqual, context.Canceled)
+					case <-time.After(time.Second):
+						t.Fatal("Cancel did not return.")
+					}
+					// Native code gives up on the stalled offer as well, long
+					// before DefaultTimeout, instead of lingering.
+					deadline := time.Now().Add(time.Second)
+					for runtime.NumGoroutine() > before &&
+						time.Now().Before(deadline) {
+						time.Sleep(50 * time.Millisecond)
+					}
+					So(runtime.NumGoroutine(), ShouldBeLessThanOrEqualTo, before)
+				})
+			})
+
+			Convey("Offer and answer options", func() {
+				o := defaultOfferOptions()
+				for _, option := range []func(*OfferOptions){
+					IceRestart(true),
+					OfferVoiceActivityDetection(false),
+					OfferToReceiveAudio(true),
+					OfferToReceiveVideo(false),
+					UseRtpMux(false),
+				} {
+					option(&o)
+				}
+				So(o.IceRestart, ShouldBeTrue)
+				So(o.VoiceActivityDetection, ShouldBeFalse)
+				So(o.OfferToReceiveAudio, ShouldEqual, 1)
+				So(o.OfferToReceiveVideo, ShouldEqual, 0)
+				So(o.UseRtpMux, ShouldBeFalse)
+
+				offer, err := alice.CreateOffer(OfferToReceiveAudio(true))
+				So(err, ShouldBeNil)
+				So(offer.Sdp, ShouldContainSubstring, "m=audio")
+
+				offer, err = alice.CreateOffer()
+				So(err, ShouldBeNil)
+				So(offer.Sdp, ShouldNotContainSubstring, "m=audio")
+				So(alice.SetLocalDescription(offer), ShouldBeNil)
+				So(bob.SetRemoteDescription(offer), ShouldBeNil)
+				answer, err := bob.CreateAnswer(AnswerVoiceActivityDetection(false))
+				So(answer, ShouldNotBeNil)
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Concurrent SDP operations", func() {
+				errs := make(chan error, 4)
+				for i := 0; i < 4; i++ {
+					go func() {
+						_, err := alice.CreateOffer()
+						errs <- err
+					}()
+				}
+				for i := 0; i < 4; i++ {
+					select {
+					case err := <-errs:
+						So(err, ShouldBeNil)
+					case <-time.After(time.Second * 5):
+						t.Fatal("Timed out.")
+					}
+				}
+			})
+
+			Convey("Reentrant SDP operations from a callback", func() {
+				errs := make(chan error, 1)
+				alice.OnNegotiationNeeded = func() {
+					offer, err := alice.CreateOffer()
+					if nil == err {
+						err = alice.SetLocalDescription(offer)
+					}
+					errs <- err
+				}
+				channel, err := alice.CreateDataChannel("reentrant")
+				So(channel, ShouldNotBeNil)
+				So(err, ShouldBeNil)
+				select {
+				case err := <-errs:
+					So(err, ShouldBeNil)
+					So(alice.SignalingState(), ShouldEqual, SignalingStateHaveLocalOffer)
+				case <-time.After(time.Second * 5):
+					t.Fatal("Timed out.")
+				}
+				alice.DeleteDataChannel(channel)
+			})
+
 			Convey("DataChannel", func() {
 				channel, err := alice.CreateDataChannel("test")
 				So(channel, ShouldNotBeNil)
@@ -271,3 +696,200 @@ func TestPeerConnection(t *testing.T) {
 		})
 	})
 }
+
+func TestPortAllocator(t *testing.T) {
+	SetLoggingVerbosity(0)
+
+	// Gathers the local candidates of a fresh PeerConnection.
+	gather := func(config *Configuration, factory ...*Factory) []string {
+		pc, err := NewPeerConnection(config, factory...)
+		So(err, ShouldBeNil)
+		defer pc.Destroy()
+		var candidates []string
+		complete := make(chan bool, 1)
+		pc.OnIceCandidate = func(ic IceCandidate) {
+			candidates = append(candidates, ic.Candidate)
+		}
+		pc.OnIceGatheringStateChange = func(s IceGatheringState) {
+			if IceGatheringStateComplete == s {
+				complete <- true
+			}
+		}
+		channel, err := pc.CreateDataChannel("gather")
+		So(err, ShouldBeNil)
+		defer pc.DeleteDataChannel(channel)
+		offer, err := pc.CreateOffer()
+		So(err, ShouldBeNil)
+		So(pc.SetLocalDescription(offer), ShouldBeNil)
+		select {
+		case <-complete:
+		case <-time.After(time.Second * 10):
+			t.Fatal("Timed out.")
+		}
+		return candidates
+	}
+
+	Convey("Port allocator controls", t, func() {
+		Convey("Port range", func() {
+			candidates := gather(NewConfiguration(OptionPortRange(40000, 40010)))
+			So(candidates, ShouldNotBeEmpty)
+			for _, candidate := range candidates {
+				fields := strings.Fields(candidate)
+				So(len(fields), ShouldBeGreaterThan, 5)
+				port, err := strconv.Atoi(fields[5])
+				So(err, ShouldBeNil)
+				So(port, ShouldBeBetweenOrEqual, 40000, 40010)
+			}
+		})
+
+		Convey("TCP candidates", func() {
+			hasTCP := func(candidates []string) bool {
+				for _, candidate := range candidates {
+					if "tcp" == strings.ToLower(strings.Fields(candidate)[2]) {
+						return true
+					}
+				}
+				return false
+			}
+			So(hasTCP(gather(NewConfiguration())), ShouldBeTrue)
+			So(hasTCP(gather(NewConfiguration(
+				OptionTcpCandidatePolicy(TcpCandidatePolicyDisabled)))), ShouldBeFalse)
+		})
+
+		Convey("Ignoring every network type leaves no candidates", func() {
+			all := NetworkTypeEthernet | NetworkTypeWifi | NetworkTypeCellular |
+				NetworkTypeVpn | NetworkTypeLoopback
+			// Interfaces of unknown type can only be ignored by name.
+			candidates := gather(NewConfiguration(
+				OptionNetworkIgnoreMask(all),
+				OptionIgnoreInterfaces(localInterfaces()...)))
+			So(candidates, ShouldBeEmpty)
+		})
+
+		Convey("Local addresses", func() {
+			// The address of each candidate.
+			addresses := func(candidates []string) []string {
+				var addrs []string
+				for _, candidate := range candidates {
+					addrs = append(addrs, strings.Fields(candidate)[4])
+				}
+				return addrs
+			}
+			loopback := OptionNetworkIgnoreMask(NetworkTypeNone)
+
+			candidates := gather(NewConfiguration(loopback,
+				OptionLocalAddresses("127.0.0.1")))
+			So(candidates, ShouldNotBeEmpty)
+			for _, addr := range addresses(candidates) {
+				So(addr, ShouldEqual, "127.0.0.1")
+			}
+
+			candidates = gather(NewConfiguration(loopback,
+				OptionLocalAddresses("lo")))
+			So(candidates, ShouldNotBeEmpty)
+			for _, addr := range addresses(candidates) {
+				So(net.ParseIP(addr).IsLoopback(), ShouldBeTrue)
+			}
+
+			candidates = gather(NewConfiguration(loopback,
+				OptionLocalAddresses("192.0.2.123", "nonexistent0")))
+			So(candidates, ShouldBeEmpty)
+
+			// Loopback is still left out of a Configuration which leaves
+			// NetworkIgnoreMask unset.
+			candidates = gather(&Configuration{
+				LocalAddresses: []string{"127.0.0.1"},
+			})
+			So(candidates, ShouldBeEmpty)
+
+			// Run with an alias, such as from "ip addr add 127.0.0.2/8 dev lo",
+			// to check one address is picked out of several on an interface.
+			if alias := loopbackAlias(); "" != alias {
+				candidates = gather(NewConfiguration(loopback,
+					OptionLocalAddresses(alias)))
+				So(candidates, ShouldNotBeEmpty)
+				for _, addr := range addresses(candidates) {
+					So(addr, ShouldEqual, alias)
+				}
+			}
+		})
+
+		Convey("FactoryOptions add to the NetworkIgnoreMask", func() {
+			factory, err := NewFactory()
+			So(err, ShouldBeNil)
+			defer factory.Destroy()
+			config := NewConfiguration(OptionNetworkIgnoreMask(NetworkTypeNone),
+				OptionLocalAddresses("127.0.0.1"))
+			So(gather(config, factory), ShouldNotBeEmpty)
+			So(factory.SetOptions(FactoryOptions{
+				NetworkIgnoreMask: NetworkTypeLoopback,
+			}), ShouldBeNil)
+			So(gather(config, factory), ShouldBeEmpty)
+		})
+
+		Convey("Cannot change after creation", func() {
+			config := NewConfiguration(OptionPortRange(40000, 40100),
+				OptionIgnoreInterfaces("docker0"))
+			pc, err := NewPeerConnection(config)
+			So(err, ShouldBeNil)
+			for field, change := range map[string]func(*Configuration){
+				"DisableIPv6": func(c *Configuration) { c.DisableIPv6 = true },
+				"MinPort":     func(c *Configuration) { c.MinPort = 40001 },
+				"MaxPort":     func(c *Configuration) { c.MaxPort = 0 },
+				"NetworkIgnoreMask": func(c *Configuration) {
+					c.NetworkIgnoreMask = NetworkTypeNone
+				},
+				"IgnoredInterfaces": func(c *Configuration) {
+					c.IgnoredInterfaces = nil
+				},
+				"LocalAddresses": func(c *Configuration) {
+					c.LocalAddresses = []string{"127.0.0.1"}
+				},
+				"Proxy": func(c *Configuration) {
+					c.Proxy = ProxyConfig{Type: ProxyTypeSocks5,
+						Address: "127.0.0.1:1080"}
+				},
+				"Transport": func(c *Configuration) {
+					c.Transport = newMemNetwork().transport("10.0.0.1")
+				},
+			} {
+				changed := *config
+				change(&changed)
+				err = pc.SetConfiguration(changed)
+				So(errors.Is(err, ErrInvalidModification), ShouldBeTrue)
+				if "DisableIPv6" != field {
+					So(err.Error(), ShouldContainSubstring, field)
+				}
+			}
+			So(pc.GetConfiguration().MinPort, ShouldEqual, 40000)
+
+			// An unset NetworkIgnoreMask is the same as its default.
+			unchanged := *config
+			unchanged.NetworkIgnoreMask = 0
+			So(pc.SetConfiguration(unchanged), ShouldBeNil)
+			So(pc.Destroy(), ShouldBeNil)
+		})
+	})
+}
+
+// An IPv4 loopback address other than 127.0.0.1, or "" if there is none.
+func loopbackAlias() string {
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if ok && ipnet.IP.IsLoopback() && nil != ipnet.IP.To4() &&
+			!ipnet.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+			return ipnet.IP.String()
+		}
+	}
+	return ""
+}
+
+func localInterfaces() []string {
+	interfaces, _ := net.Interfaces()
+	var names []string
+	for _, i := range interfaces {
+		names = append(names, i.Name)
+	}
+	return names
+}
@@ -0,0 +1,89 @@
+package webrtc
+
+// #include <stdlib.h>
+// #include "peerconnection.h"
+// #include "ctestenums.h"
+import "C"
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"unsafe"
+)
+
+// ProxyType corresponds to rtc::ProxyType.
+type ProxyType int
+
+const (
+	ProxyTypeNone ProxyType = iota
+	// An HTTP proxy supporting the CONNECT method.
+	ProxyTypeHttps
+	ProxyTypeSocks5
+)
+
+func (t ProxyType) String() string {
+	return EnumToStringSafe(int(t), []string{
+		"None",
+		"Https",
+		"Socks5",
+	})
+}
+
+/*
+ProxyConfig describes a proxy for the connections to TURN servers over TCP or
+TLS, such as "turn:host?transport=tcp" or "turns:host". Nothing else goes
+through it, so the IceTransportPolicy should usually be
+IceTransportPolicyRelay.
+
+An HTTPS proxy answers to Username and Password when it asks for them, and a
+SOCKS5 proxy with username/password authentication.
+*/
+type ProxyConfig struct {
+	Type     ProxyType
+	Address  string // As "host:port".
+	Username string
+	Password string
+	// Sent in the User-Agent header of an HTTPS proxy's CONNECT request.
+	UserAgent string
+}
+
+// OptionProxy routes TURN over TCP and TLS through |proxy|.
+func OptionProxy(proxy ProxyConfig) ConfigurationOption {
+	return func(config *Configuration) error {
+		if ProxyTypeHttps != proxy.Type && ProxyTypeSocks5 != proxy.Type {
+			return fmt.Errorf("OptionProxy: unsupported type %s", proxy.Type)
+		}
+		host, port, err := net.SplitHostPort(proxy.Address)
+		if nil != err {
+			return fmt.Errorf("OptionProxy: %v", err)
+		}
+		if p, err := strconv.Atoi(port); "" == host || nil != err ||
+			p < 1 || p > 0xffff {
+			return fmt.Errorf("OptionProxy: invalid address %q", proxy.Address)
+		}
+		config.Proxy = proxy
+		return nil
+	}
+}
+
+func (proxy *ProxyConfig) _CGO() C.CGO_Proxy {
+	var cProxy C.CGO_Proxy
+	cProxy._type = C.int(proxy.Type)
+	cProxy.address = C.CString(proxy.Address)
+	cProxy.username = C.CString(proxy.Username)
+	cProxy.password = C.CString(proxy.Password)
+	cProxy.userAgent = C.CString(proxy.UserAgent)
+	return cProxy
+}
+
+func freeProxy(cProxy C.CGO_Proxy) {
+	C.free(unsafe.Pointer(cProxy.address))
+	C.free(unsafe.Pointer(cProxy.username))
+	C.free(unsafe.Pointer(cProxy.password))
+	C.free(unsafe.Pointer(cProxy.userAgent))
+}
+
+// Test helpers
+var _cgoProxyTypeNone = int(C.CGO_ProxyTypeNone)
+var _cgoProxyTypeHttps = int(C.CGO_ProxyTypeHttps)
+var _cgoProxyTypeSocks5 = int(C.CGO_ProxyTypeSocks5)
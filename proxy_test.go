@@ -0,0 +1,250 @@
+package webrtc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProxyTypeEnums(t *testing.T) {
+	Convey(`Enum: ProxyType values should match
+C++ rtc::ProxyType values`, t, func() {
+		So(ProxyTypeNone, ShouldEqual, _cgoProxyTypeNone)
+		So(ProxyTypeHttps, ShouldEqual, _cgoProxyTypeHttps)
+		So(ProxyTypeSocks5, ShouldEqual, _cgoProxyTypeSocks5)
+	})
+}
+
+func TestProxy(t *testing.T) {
+	SetLoggingVerbosity(0)
+
+	Convey("OptionProxy", t, func() {
+		config := NewConfiguration(OptionProxy(ProxyConfig{
+			Type:      ProxyTypeHttps,
+			Address:   "proxy.example.org:3128",
+			UserAgent: "test",
+		}))
+		So(config.Proxy.Type, ShouldEqual, ProxyTypeHttps)
+		So(config.Proxy.Address, ShouldEqual, "proxy.example.org:3128")
+
+		for _, proxy := range []ProxyConfig{
+			{Type: ProxyTypeNone, Address: "proxy.example.org:3128"},
+			{Type: ProxyTypeSocks5, Address: "proxy.example.org"},
+			{Type: ProxyTypeSocks5, Address: ":1080"},
+			{Type: ProxyTypeSocks5, Address: "proxy.example.org:0"},
+			{Type: ProxyTypeSocks5, Address: "proxy.example.org:socks"},
+		} {
+			So(OptionProxy(proxy)(config), ShouldNotBeNil)
+		}
+	})
+
+	Convey("TURN over TCP through a proxy", t, func() {
+		turn, allocations := turnTCPStandIn(t)
+		defer turn.Close()
+
+		// Gathers relay candidates through |proxy| until the TURN server
+		// receives an allocation request.
+		allocate := func(proxy ProxyConfig) {
+			config := NewConfiguration(
+				OptionIceServer("turn:"+turn.Addr().String()+"?transport=tcp",
+					"user", "secret"),
+				OptionIceTransportPolicy(IceTransportPolicyRelay),
+				OptionProxy(proxy))
+			pc, err := NewPeerConnection(config)
+			So(err, ShouldBeNil)
+			defer pc.Destroy()
+			channel, err := pc.CreateDataChannel("proxy")
+			So(err, ShouldBeNil)
+			defer pc.DeleteDataChannel(channel)
+			offer, err := pc.CreateOffer()
+			So(err, ShouldBeNil)
+			So(pc.SetLocalDescription(offer), ShouldBeNil)
+			select {
+			case <-allocations:
+			case <-time.After(10 * time.Second):
+				t.Fatal("Timed out waiting for an allocation through", proxy.Type)
+			}
+		}
+
+		Convey("HTTPS", func() {
+			proxy, requests := httpProxyStandIn(t)
+			defer proxy.Close()
+			allocate(ProxyConfig{
+				Type:      ProxyTypeHttps,
+				Address:   proxy.Addr().String(),
+				UserAgent: "go-webrtc-test",
+			})
+			request := <-requests
+			So(request.Method, ShouldEqual, "CONNECT")
+			So(request.Host, ShouldEqual, turn.Addr().String())
+			So(request.UserAgent(), ShouldEqual, "go-webrtc-test")
+		})
+
+		Convey("SOCKS5", func() {
+			proxy, requests := socks5ProxyStandIn(t, "alice", "hunter2")
+			defer proxy.Close()
+			allocate(ProxyConfig{
+				Type:     ProxyTypeSocks5,
+				Address:  proxy.Addr().String(),
+				Username: "alice",
+				Password: "hunter2",
+			})
+			So(<-requests, ShouldEqual, turn.Addr().String())
+		})
+	})
+}
+
+// A stand-in for a TURN server over TCP, which reports each Allocate request.
+func turnTCPStandIn(t *testing.T) (net.Listener, chan bool) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	allocations := make(chan bool, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if nil != err {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				header := make([]byte, 20)
+				if _, err := io.ReadFull(conn, header); nil != err {
+					return
+				}
+				if 0x0003 == binary.BigEndian.Uint16(header[0:2]) {
+					allocations <- true
+				}
+			}()
+		}
+	}()
+	return listener, allocations
+}
+
+// Accept connections on a fresh listener, passing each to |handle|.
+func serveStandIn(t *testing.T, handle func(net.Conn)) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if nil != err {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return listener
+}
+
+// Copy between |conn| and a new connection to |target|, until either closes.
+func tunnel(conn net.Conn, target string) {
+	upstream, err := net.Dial("tcp", target)
+	if nil != err {
+		conn.Close()
+		return
+	}
+	go func() {
+		io.Copy(upstream, conn)
+		upstream.Close()
+	}()
+	io.Copy(conn, upstream)
+	conn.Close()
+}
+
+// A stand-in for an HTTP proxy, which reports each CONNECT request.
+func httpProxyStandIn(t *testing.T) (net.Listener, chan *http.Request) {
+	requests := make(chan *http.Request, 16)
+	listener := serveStandIn(t, func(conn net.Conn) {
+		request, err := http.ReadRequest(bufio.NewReader(conn))
+		if nil != err || "CONNECT" != request.Method {
+			conn.Close()
+			return
+		}
+		requests <- request
+		io.WriteString(conn, "HTTP/1.0 200 Connection established\r\n\r\n")
+		tunnel(conn, request.Host)
+	})
+	return listener, requests
+}
+
+// A stand-in for a SOCKS5 proxy requiring |username| and |password|, which
+// reports the destination of each CONNECT request.
+func socks5ProxyStandIn(t *testing.T, username, password string) (
+	net.Listener, chan string) {
+	requests := make(chan string, 16)
+	listener := serveStandIn(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		// Greeting: version, then the offered authentication methods.
+		greeting := make([]byte, 2)
+		if _, err := io.ReadFull(r, greeting); nil != err || 5 != greeting[0] {
+			conn.Close()
+			return
+		}
+		methods := make([]byte, greeting[1])
+		io.ReadFull(r, methods)
+		conn.Write([]byte{5, 2}) // Username/password.
+		// RFC 1929 authentication.
+		readString := func() string {
+			n, _ := r.ReadByte()
+			s := make([]byte, n)
+			io.ReadFull(r, s)
+			return string(s)
+		}
+		r.ReadByte()
+		if readString() != username || readString() != password {
+			conn.Write([]byte{1, 1})
+			conn.Close()
+			return
+		}
+		conn.Write([]byte{1, 0})
+		// Request: version, command, reserved, then the address.
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(r, request); nil != err || 1 != request[1] {
+			conn.Close()
+			return
+		}
+		var host string
+		switch request[3] {
+		case 1:
+			ip := make([]byte, 4)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		case 3:
+			host = readString()
+		case 4:
+			ip := make([]byte, 16)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		}
+		port := make([]byte, 2)
+		io.ReadFull(r, port)
+		target := net.JoinHostPort(host,
+			strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+		requests <- target
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		tunnel(&bufferedConn{conn, r}, target)
+	})
+	return listener, requests
+}
+
+// A net.Conn whose reads go through a bufio.Reader which has already
+// consumed part of it.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -55,12 +55,19 @@ func (desc *SessionDescription) Serialize() string {
 	return string(bytes)
 }
 
-func (desc *SessionDescription) GoStringToCgoSdp() C.CGO_sdp {
+// Parse the SessionDescription into a native object. Returns an *Error with
+// ErrorTypeSyntaxError if the SDP is malformed.
+func (desc *SessionDescription) GoStringToCgoSdp() (C.CGO_sdp, error) {
 	t := C.CString(desc.Type)
 	defer C.free(unsafe.Pointer(t))
 	s := C.CString(desc.Sdp)
 	defer C.free(unsafe.Pointer(s))
-	return C.CGO_DeserializeSDP(t, s)
+	var cErr C.CGO_Error
+	sdp := C.CGO_DeserializeSDP(t, s, &cErr)
+	if nil == sdp {
+		return nil, newNativeError("DeserializeSDP", &cErr)
+	}
+	return sdp, nil
 }
 
 // Deserialize a received json string into a SessionDescription, if possible.
@@ -0,0 +1,240 @@
+package webrtc
+
+// #include <stdlib.h>
+// #include "peerconnection.h"
+import "C"
+import (
+	"context"
+	"encoding/json"
+	"time"
+	"unsafe"
+)
+
+// StatsBase holds what every entry of a StatsReport has in common.
+//
+// See: https://w3c.github.io/webrtc-pc/#dom-rtcstats
+type StatsBase struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Milliseconds since the UNIX epoch, as a DOMHighResTimeStamp.
+	Timestamp float64 `json:"timestamp"`
+}
+
+// Time returns the Timestamp as a time.Time.
+func (s *StatsBase) Time() time.Time {
+	return time.Unix(0, int64(s.Timestamp*float64(time.Millisecond)))
+}
+
+func (s *StatsBase) base() *StatsBase {
+	return s
+}
+
+// See: https://w3c.github.io/webrtc-stats/#pcstats-dict*
+type PeerConnectionStats struct {
+	StatsBase
+	DataChannelsOpened uint32 `json:"dataChannelsOpened"`
+	DataChannelsClosed uint32 `json:"dataChannelsClosed"`
+}
+
+// See: https://w3c.github.io/webrtc-stats/#dcstats-dict*
+type DataChannelStats struct {
+	StatsBase
+	Label            string `json:"label"`
+	Protocol         string `json:"protocol"`
+	DataChannelID    int32  `json:"datachannelid"`
+	State            string `json:"state"`
+	MessagesSent     uint32 `json:"messagesSent"`
+	BytesSent        uint64 `json:"bytesSent"`
+	MessagesReceived uint32 `json:"messagesReceived"`
+	BytesReceived    uint64 `json:"bytesReceived"`
+}
+
+// Round trip times are in seconds, and bitrates in bits per second.
+//
+// See: https://w3c.github.io/webrtc-stats/#candidatepair-dict*
+type IceCandidatePairStats struct {
+	StatsBase
+	TransportID              string  `json:"transportId"`
+	LocalCandidateID         string  `json:"localCandidateId"`
+	RemoteCandidateID        string  `json:"remoteCandidateId"`
+	State                    string  `json:"state"`
+	Priority                 uint64  `json:"priority"`
+	Nominated                bool    `json:"nominated"`
+	Writable                 bool    `json:"writable"`
+	Readable                 bool    `json:"readable"`
+	BytesSent                uint64  `json:"bytesSent"`
+	BytesReceived            uint64  `json:"bytesReceived"`
+	TotalRoundTripTime       float64 `json:"totalRoundTripTime"`
+	CurrentRoundTripTime     float64 `json:"currentRoundTripTime"`
+	AvailableOutgoingBitrate float64 `json:"availableOutgoingBitrate"`
+	AvailableIncomingBitrate float64 `json:"availableIncomingBitrate"`
+	RequestsReceived         uint64  `json:"requestsReceived"`
+	RequestsSent             uint64  `json:"requestsSent"`
+	ResponsesReceived        uint64  `json:"responsesReceived"`
+	ResponsesSent            uint64  `json:"responsesSent"`
+	ConsentRequestsSent      uint64  `json:"consentRequestsSent"`
+}
+
+// Describes either a local or a remote candidate, according to IsRemote.
+//
+// See: https://w3c.github.io/webrtc-stats/#icecandidate-dict*
+type IceCandidateStats struct {
+	StatsBase
+	TransportID   string `json:"transportId"`
+	IsRemote      bool   `json:"isRemote"`
+	NetworkType   string `json:"networkType"`
+	IP            string `json:"ip"`
+	Port          int32  `json:"port"`
+	Protocol      string `json:"protocol"`
+	CandidateType string `json:"candidateType"`
+	Priority      int32  `json:"priority"`
+	URL           string `json:"url"`
+	Deleted       bool   `json:"deleted"`
+}
+
+// See: https://w3c.github.io/webrtc-stats/#transportstats-dict*
+type TransportStats struct {
+	StatsBase
+	BytesSent               uint64 `json:"bytesSent"`
+	BytesReceived           uint64 `json:"bytesReceived"`
+	RtcpTransportStatsID    string `json:"rtcpTransportStatsId"`
+	DtlsState               string `json:"dtlsState"`
+	SelectedCandidatePairID string `json:"selectedCandidatePairId"`
+	LocalCertificateID      string `json:"localCertificateId"`
+	RemoteCertificateID     string `json:"remoteCertificateId"`
+}
+
+// See: https://w3c.github.io/webrtc-stats/#certificatestats-dict*
+type CertificateStats struct {
+	StatsBase
+	Fingerprint          string `json:"fingerprint"`
+	FingerprintAlgorithm string `json:"fingerprintAlgorithm"`
+	Base64Certificate    string `json:"base64Certificate"`
+	IssuerCertificateID  string `json:"issuerCertificateId"`
+}
+
+/*
+StatsReport holds the stats of a PeerConnection at one point in time, as
+returned by GetStats, grouped by type. Only the types relevant to DataChannels
+are kept.
+
+It serializes to JSON as-is, for example to ship to monitoring.
+
+See: https://w3c.github.io/webrtc-pc/#rtcstatsreport-object
+*/
+type StatsReport struct {
+	PeerConnection   *PeerConnectionStats    `json:"peerConnection,omitempty"`
+	DataChannels     []DataChannelStats      `json:"dataChannels,omitempty"`
+	CandidatePairs   []IceCandidatePairStats `json:"candidatePairs,omitempty"`
+	LocalCandidates  []IceCandidateStats     `json:"localCandidates,omitempty"`
+	RemoteCandidates []IceCandidateStats     `json:"remoteCandidates,omitempty"`
+	Transports       []TransportStats        `json:"transports,omitempty"`
+	Certificates     []CertificateStats      `json:"certificates,omitempty"`
+}
+
+// SelectedCandidatePair returns the candidate pair currently used by the first
+// transport, or nil if none has been selected yet.
+func (r *StatsReport) SelectedCandidatePair() *IceCandidatePairStats {
+	for _, t := range r.Transports {
+		for i := range r.CandidatePairs {
+			if "" != t.SelectedCandidatePairID &&
+				r.CandidatePairs[i].ID == t.SelectedCandidatePairID {
+				return &r.CandidatePairs[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Serialize a StatsReport into a JSON string.
+func (r *StatsReport) Serialize() string {
+	bytes, err := json.Marshal(r)
+	if nil != err {
+		ERROR.Println(err)
+		return ""
+	}
+	return string(bytes)
+}
+
+// Parse the JSON array of stats objects produced by the native code, whose
+// timestamps are in microseconds.
+func parseStatsReport(native string) (*StatsReport, error) {
+	var entries []json.RawMessage
+	if err := json.Unmarshal([]byte(native), &entries); nil != err {
+		return nil, err
+	}
+	report := new(StatsReport)
+	for _, entry := range entries {
+		var base StatsBase
+		if err := json.Unmarshal(entry, &base); nil != err {
+			return nil, err
+		}
+		var stats interface {
+			base() *StatsBase
+		}
+		switch base.Type {
+		case "peer-connection":
+			report.PeerConnection = new(PeerConnectionStats)
+			stats = report.PeerConnection
+		case "data-channel":
+			report.DataChannels = append(report.DataChannels, DataChannelStats{})
+			stats = &report.DataChannels[len(report.DataChannels)-1]
+		case "candidate-pair":
+			report.CandidatePairs = append(report.CandidatePairs,
+				IceCandidatePairStats{})
+			stats = &report.CandidatePairs[len(report.CandidatePairs)-1]
+		case "local-candidate":
+			report.LocalCandidates = append(report.LocalCandidates,
+				IceCandidateStats{})
+			stats = &report.LocalCandidates[len(report.LocalCandidates)-1]
+		case "remote-candidate":
+			report.RemoteCandidates = append(report.RemoteCandidates,
+				IceCandidateStats{})
+			stats = &report.RemoteCandidates[len(report.RemoteCandidates)-1]
+		case "transport":
+			report.Transports = append(report.Transports, TransportStats{})
+			stats = &report.Transports[len(report.Transports)-1]
+		case "certificate":
+			report.Certificates = append(report.Certificates, CertificateStats{})
+			stats = &report.Certificates[len(report.Certificates)-1]
+		default:
+			continue
+		}
+		if err := json.Unmarshal(entry, stats); nil != err {
+			return nil, err
+		}
+		stats.base().Timestamp /= 1000
+	}
+	return report, nil
+}
+
+// GetStats gathers the current stats of the PeerConnection, waiting for as
+// long as |ctx| allows.
+//
+// See: https://w3c.github.io/webrtc-pc/#dom-rtcpeerconnection-getstats
+func (pc *PeerConnection) GetStats(ctx context.Context) (*StatsReport, error) {
+	timeout := nativeTimeout(ctx)
+	var native string
+	_, err := pc.waitNative(ctx, "GetStats", func(canceller C.CGO_Canceller) nativeResult {
+		var out *C.char
+		var cErr C.CGO_Error
+		status := C.CGO_GetStats(pc.cgoPeer, canceller, timeout, &out, &cErr)
+		switch status {
+		case 0:
+			native = C.GoString(out)
+			C.free(unsafe.Pointer(out))
+			return nativeResult{nil, status, nil}
+		case C.CGO_TIMEOUT:
+			return nativeResult{nil, status, nil}
+		}
+		return nativeResult{nil, status, newNativeError("GetStats", &cErr)}
+	})
+	if nil != err {
+		return nil, err
+	}
+	report, err := parseStatsReport(native)
+	if nil != err {
+		return nil, &Error{ErrorTypeInternalError, "GetStats", err.Error()}
+	}
+	return report, nil
+}
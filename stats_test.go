@@ -0,0 +1,145 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// As produced by the native code for a connected PeerConnection.
+const nativeStats = `[
+{"type":"peer-connection","id":"RTCPeerConnection","timestamp":1500000000000000,
+ "dataChannelsOpened":1,"dataChannelsClosed":0},
+{"type":"data-channel","id":"RTCDataChannel_1","timestamp":1500000000000000,
+ "label":"chat","protocol":"","datachannelid":1,"state":"open",
+ "messagesSent":2,"bytesSent":18446744073709551615,"messagesReceived":1,
+ "bytesReceived":5},
+{"type":"candidate-pair","id":"RTCIceCandidatePair_a_b","timestamp":1500000000000000,
+ "transportId":"RTCTransport_data_1","localCandidateId":"RTCIceCandidate_a",
+ "remoteCandidateId":"RTCIceCandidate_b","state":"succeeded","priority":1,
+ "nominated":true,"writable":true,"bytesSent":100,"bytesReceived":200,
+ "totalRoundTripTime":null,"currentRoundTripTime":0.25},
+{"type":"local-candidate","id":"RTCIceCandidate_a","timestamp":1500000000000000,
+ "transportId":"RTCTransport_data_1","isRemote":false,"ip":"10.0.0.1",
+ "port":5000,"protocol":"udp","candidateType":"host","priority":1},
+{"type":"remote-candidate","id":"RTCIceCandidate_b","timestamp":1500000000000000,
+ "transportId":"RTCTransport_data_1","isRemote":true,"ip":"10.0.0.2",
+ "port":6000,"protocol":"udp","candidateType":"srflx","priority":1},
+{"type":"transport","id":"RTCTransport_data_1","timestamp":1500000000000000,
+ "bytesSent":100,"bytesReceived":200,"dtlsState":"connected",
+ "selectedCandidatePairId":"RTCIceCandidatePair_a_b",
+ "localCertificateId":"RTCCertificate_x"},
+{"type":"certificate","id":"RTCCertificate_x","timestamp":1500000000000000,
+ "fingerprint":"AB:CD","fingerprintAlgorithm":"sha-256",
+ "base64Certificate":"MIIB"},
+{"type":"codec","id":"RTCCodec_0","timestamp":1500000000000000}
+]`
+
+func TestStatsReport(t *testing.T) {
+	SetLoggingVerbosity(0)
+
+	Convey("Parse native stats", t, func() {
+		report, err := parseStatsReport(nativeStats)
+		So(err, ShouldBeNil)
+		So(report.PeerConnection.DataChannelsOpened, ShouldEqual, 1)
+		So(report.PeerConnection.Time(), ShouldResemble, time.Unix(1500000000, 0))
+		So(report.PeerConnection.Timestamp, ShouldEqual, 1500000000000)
+
+		So(len(report.DataChannels), ShouldEqual, 1)
+		So(report.DataChannels[0].Label, ShouldEqual, "chat")
+		So(report.DataChannels[0].BytesSent, ShouldEqual, uint64(18446744073709551615))
+		So(len(report.LocalCandidates), ShouldEqual, 1)
+		So(len(report.RemoteCandidates), ShouldEqual, 1)
+		So(report.RemoteCandidates[0].CandidateType, ShouldEqual, "srflx")
+		So(len(report.Certificates), ShouldEqual, 1)
+
+		pair := report.SelectedCandidatePair()
+		So(pair, ShouldNotBeNil)
+		So(pair.CurrentRoundTripTime, ShouldEqual, 0.25)
+		// Non-finite numbers come through as null.
+		So(pair.TotalRoundTripTime, ShouldEqual, 0)
+		So(pair.LocalCandidateID, ShouldEqual, report.LocalCandidates[0].ID)
+
+		var decoded StatsReport
+		So(json.Unmarshal([]byte(report.Serialize()), &decoded), ShouldBeNil)
+		So(&decoded, ShouldResemble, report)
+
+		_, err = parseStatsReport("not json")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("GetStats", t, func() {
+		pc, err := NewPeerConnection(NewConfiguration())
+		So(err, ShouldBeNil)
+		channel, err := pc.CreateDataChannel("stats")
+		So(err, ShouldBeNil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		report, err := pc.GetStats(ctx)
+		So(err, ShouldBeNil)
+		So(report.PeerConnection, ShouldNotBeNil)
+		So(report.SelectedCandidatePair(), ShouldBeNil)
+
+		pc.DeleteDataChannel(channel)
+		So(pc.Close(), ShouldBeNil)
+		_, err = pc.GetStats(ctx)
+		So(err, ShouldNotBeNil)
+		So(pc.Destroy(), ShouldBeNil)
+	})
+
+	Convey("GetStats of a connected pair", t, func() {
+		network := newMemNetwork()
+		alice, err := NewPeerConnection(NewConfiguration(
+			OptionTransport(network.transport("10.0.0.1"))))
+		So(err, ShouldBeNil)
+		bob, err := NewPeerConnection(NewConfiguration(
+			OptionTransport(network.transport("10.0.0.2"))))
+		So(err, ShouldBeNil)
+		channel, err := alice.CreateDataChannel("stats")
+		So(err, ShouldBeNil)
+		opened := make(chan bool, 1)
+		channel.OnOpen = func() {
+			opened <- true
+		}
+		exchangeDescriptions(alice, bob)
+		select {
+		case <-opened:
+		case <-time.After(time.Second * 10):
+			t.Fatal("Timed out waiting for the DataChannel.")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		var report *StatsReport
+		for nil == report || nil == report.SelectedCandidatePair() {
+			report, err = alice.GetStats(ctx)
+			So(err, ShouldBeNil)
+			time.Sleep(time.Millisecond * 50)
+		}
+		pair := report.SelectedCandidatePair()
+		So(pair.State, ShouldEqual, "succeeded")
+		So(pair.LocalCandidateID, ShouldNotBeEmpty)
+		So(pair.RemoteCandidateID, ShouldNotBeEmpty)
+		var local *IceCandidateStats
+		for i := range report.LocalCandidates {
+			if report.LocalCandidates[i].ID == pair.LocalCandidateID {
+				local = &report.LocalCandidates[i]
+			}
+		}
+		So(local, ShouldNotBeNil)
+		So(local.IP, ShouldEqual, "10.0.0.1")
+		So(report.PeerConnection.DataChannelsOpened, ShouldEqual, 1)
+
+		// Everything the native code reported is valid JSON.
+		var decoded StatsReport
+		So(json.Unmarshal([]byte(report.Serialize()), &decoded), ShouldBeNil)
+
+		alice.DeleteDataChannel(channel)
+		So(alice.Destroy(), ShouldBeNil)
+		So(bob.Destroy(), ShouldBeNil)
+	})
+}
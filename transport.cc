@@ -0,0 +1,23 @@
+#include "transport.h"
+#include "transport.hpp"
+
+// Called by Go for each packet, or stream segment, read from a connection.
+void CGO_Socket_Deliver(CGO_Socket socket, void *data, int size,
+                        const char *from) {
+  rtc::SocketAddress address;
+  if (from)
+    address.FromString(from);
+  ((TransportSocket*)socket)->Deliver(data, size, address);
+}
+
+// Called by Go once a net.Conn has been dialed, with its local address.
+void CGO_Socket_Connected(CGO_Socket socket, const char *local) {
+  rtc::SocketAddress address;
+  address.FromString(local);
+  ((TransportSocket*)socket)->Connected(address);
+}
+
+// Called by Go when a net.Conn fails to dial, or stops reading.
+void CGO_Socket_Closed(CGO_Socket socket, int error) {
+  ((TransportSocket*)socket)->Closed(error);
+}
@@ -0,0 +1,226 @@
+package webrtc
+
+// #include <stdlib.h>
+// #include "transport.h"
+import "C"
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+/*
+Transport carries the ICE traffic of a PeerConnection through Go code instead
+of the host's sockets, such as for a userspace network stack, a test harness,
+or an obfuscation layer. See OptionTransport.
+
+libwebrtc sends through the connections from its network thread, which waits
+for each write to return, so writes should not block for long. Reads happen on
+goroutines of their own.
+*/
+type Transport interface {
+	// The local addresses to gather candidates on, in place of those of the
+	// host's network interfaces. None keeps the host's interfaces, and with
+	// them Configuration.LocalAddresses and IgnoredInterfaces.
+	Addresses() []net.IP
+
+	// Returns a connection for UDP candidates and TURN over UDP, bound to
+	// |laddr|. A zero port means any port.
+	ListenPacket(laddr *net.UDPAddr) (net.PacketConn, error)
+
+	// Returns a connection to |raddr|, for TCP candidates and TURN over TCP.
+	// |laddr| has a zero port, and may have no IP either.
+	DialTCP(laddr, raddr *net.TCPAddr) (net.Conn, error)
+}
+
+/*
+OptionTransport creates all the sockets of a PeerConnection through
+|transport|, which cannot be changed with SetConfiguration.
+
+Nothing listens for incoming TCP connections, so TCP candidates are active
+only. TURN over TLS is not supported, and a Configuration.Proxy is left to the
+Transport to implement. Host names are still resolved through the system.
+*/
+func OptionTransport(transport Transport) ConfigurationOption {
+	return func(config *Configuration) error {
+		if nil == transport {
+			return fmt.Errorf("OptionTransport: nil Transport")
+		}
+		config.Transport = transport
+		return nil
+	}
+}
+
+// Transports of live PeerConnections, and their connections, for native code.
+var transportMap = NewCGOMap()
+var socketMap = NewCGOMap()
+
+// The largest UDP payload.
+const maxPacketSize = 1 << 16
+
+// A connection from a Transport, backing a native socket.
+type transportSocket struct {
+	lock       sync.Mutex
+	native     unsafe.Pointer // The C.CGO_Socket, until it closes.
+	packetConn net.PacketConn
+	conn       net.Conn
+}
+
+// Runs |f| on the native socket, unless it has closed, in which case it
+// returns false. The native socket stays valid until |f| returns.
+func (s *transportSocket) withNative(f func(C.CGO_Socket)) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if nil == s.native {
+		return false
+	}
+	f(C.CGO_Socket(s.native))
+	return true
+}
+
+func (s *transportSocket) readPackets() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := s.packetConn.ReadFrom(buf)
+		if nil != err {
+			if s.withNative(func(C.CGO_Socket) {}) {
+				WARN.Println("Transport: stopped reading from",
+					s.packetConn.LocalAddr(), err)
+			}
+			return
+		}
+		from := C.CString(addr.String())
+		s.withNative(func(native C.CGO_Socket) {
+			C.CGO_Socket_Deliver(native, unsafe.Pointer(&buf[0]), C.int(n), from)
+		})
+		C.free(unsafe.Pointer(from))
+	}
+}
+
+func (s *transportSocket) dial(transport Transport, laddr, raddr *net.TCPAddr) {
+	conn, err := transport.DialTCP(laddr, raddr)
+	if nil != err {
+		INFO.Println("Transport: could not dial", raddr, err)
+		s.withNative(func(native C.CGO_Socket) {
+			C.CGO_Socket_Closed(native, C.int(syscall.ECONNREFUSED))
+		})
+		return
+	}
+	local := C.CString(conn.LocalAddr().String())
+	defer C.free(unsafe.Pointer(local))
+	if !s.withNative(func(native C.CGO_Socket) {
+		s.conn = conn
+		C.CGO_Socket_Connected(native, local)
+	}) {
+		conn.Close()
+		return
+	}
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			s.withNative(func(native C.CGO_Socket) {
+				C.CGO_Socket_Deliver(native, unsafe.Pointer(&buf[0]), C.int(n), nil)
+			})
+		}
+		if nil != err {
+			code := syscall.ECONNRESET
+			if io.EOF == err {
+				code = 0
+			}
+			s.withNative(func(native C.CGO_Socket) {
+				C.CGO_Socket_Closed(native, C.int(code))
+			})
+			return
+		}
+	}
+}
+
+//export cgoTransportListenPacket
+func cgoTransportListenPacket(t int, cIP *C.char, minPort int, maxPort int,
+	native unsafe.Pointer, local **C.char) int {
+	transport := transportMap.Get(t).(Transport)
+	ip := net.ParseIP(C.GoString(cIP))
+	var conn net.PacketConn
+	err := fmt.Errorf("empty port range %d-%d", minPort, maxPort)
+	for port := minPort; port <= maxPort; port++ {
+		conn, err = transport.ListenPacket(&net.UDPAddr{IP: ip, Port: port})
+		if nil == err {
+			break
+		}
+	}
+	if nil != err {
+		WARN.Println("Transport: could not listen on", ip, err)
+		return 0
+	}
+	s := &transportSocket{native: native, packetConn: conn}
+	*local = C.CString(conn.LocalAddr().String())
+	go s.readPackets()
+	return socketMap.Set(s)
+}
+
+//export cgoTransportDial
+func cgoTransportDial(t int, cLocal *C.char, cRemote *C.char,
+	native unsafe.Pointer) int {
+	transport := transportMap.Get(t).(Transport)
+	laddr, err := net.ResolveTCPAddr("tcp", C.GoString(cLocal))
+	if nil != err {
+		laddr = &net.TCPAddr{}
+	}
+	raddr, err := net.ResolveTCPAddr("tcp", C.GoString(cRemote))
+	if nil != err {
+		WARN.Println("Transport: could not dial", err)
+		return 0
+	}
+	s := &transportSocket{native: native}
+	go s.dial(transport, laddr, raddr)
+	return socketMap.Set(s)
+}
+
+// Returns the number of bytes sent, or -1. A nil |cTo| is for a net.Conn.
+//
+//export cgoTransportSend
+func cgoTransportSend(socket int, data unsafe.Pointer, size int,
+	cTo *C.char) int {
+	s := socketMap.Get(socket).(*transportSocket)
+	b := C.GoBytes(data, C.int(size))
+	var n int
+	var err error
+	if nil == cTo {
+		s.lock.Lock()
+		conn := s.conn
+		s.lock.Unlock()
+		n, err = conn.Write(b)
+	} else {
+		var to *net.UDPAddr
+		to, err = net.ResolveUDPAddr("udp", C.GoString(cTo))
+		if nil == err {
+			n, err = s.packetConn.WriteTo(b, to)
+		}
+	}
+	if nil != err {
+		INFO.Println("Transport: send failed:", err)
+		return -1
+	}
+	return n
+}
+
+// Once this returns, nothing more is reported to the native socket.
+//
+//export cgoTransportClose
+func cgoTransportClose(socket int) {
+	s := socketMap.Get(socket).(*transportSocket)
+	socketMap.Delete(socket)
+	s.lock.Lock()
+	s.native = nil
+	s.lock.Unlock()
+	if nil != s.packetConn {
+		s.packetConn.Close()
+	}
+	if nil != s.conn {
+		s.conn.Close()
+	}
+}
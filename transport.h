@@ -0,0 +1,29 @@
+#ifndef _C_TRANSPORT_H_
+#define _C_TRANSPORT_H_
+
+#define WEBRTC_POSIX 1
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+  // In order to present an interface cgo is happy with, nothing in this file
+  // can directly reference header files from libwebrtc / C++ world. All the
+  // casting must be hidden in the .cc file.
+
+  // A native socket backed by a Go Transport's connection. Only valid until
+  // Go's cgoTransportClose returns for it.
+  typedef void* CGO_Socket;
+
+  // Called by Go as its connections produce data or events, from any
+  // goroutine. Each is handed over to the socket's network thread.
+  void CGO_Socket_Deliver(CGO_Socket socket, void *data, int size,
+                          const char *from);
+  void CGO_Socket_Connected(CGO_Socket socket, const char *local);
+  void CGO_Socket_Closed(CGO_Socket socket, int error);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif  // _C_TRANSPORT_H_
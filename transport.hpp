@@ -0,0 +1,390 @@
+#ifndef _TRANSPORT_H_
+#define _TRANSPORT_H_
+
+#include <_cgo_export.h>  // Allow calling certain Go functions.
+
+#include <errno.h>
+#include <string.h>
+
+#include <algorithm>
+#include <map>
+#include <memory>
+#include <string>
+
+#include "p2p/base/asyncstuntcpsocket.h"
+#include "p2p/base/packetsocketfactory.h"
+#include "rtc_base/asyncpacketsocket.h"
+#include "rtc_base/asyncsocket.h"
+#include "rtc_base/asynctcpsocket.h"
+#include "rtc_base/buffer.h"
+#include "rtc_base/messagehandler.h"
+#include "rtc_base/nethelpers.h"
+#include "rtc_base/thread.h"
+
+// rtc::Message ids handled by the sockets below.
+enum {
+  MSG_TRANSPORT_DATA,
+  MSG_TRANSPORT_CONNECTED,
+  MSG_TRANSPORT_CLOSED,
+};
+
+// What Go hands over to a socket's network thread.
+struct TransportMessageData : public rtc::MessageData {
+  rtc::Buffer data;
+  rtc::SocketAddress address;
+  int error = 0;
+};
+
+// The native side of a connection from a Go Transport, which is what
+// CGO_Socket points to. Go reports each event from its own goroutines, and
+// they are posted to the network thread, where libwebrtc expects them.
+class TransportSocket : public rtc::MessageHandler {
+ public:
+  explicit TransportSocket(rtc::Thread *network) : network_(network) {}
+
+  void Deliver(const void *data, size_t size, const rtc::SocketAddress& from) {
+    auto msg = new TransportMessageData();
+    msg->data.SetData((const uint8_t*)data, size);
+    msg->address = from;
+    network_->Post(RTC_FROM_HERE, this, MSG_TRANSPORT_DATA, msg);
+  }
+
+  void Connected(const rtc::SocketAddress& local) {
+    auto msg = new TransportMessageData();
+    msg->address = local;
+    network_->Post(RTC_FROM_HERE, this, MSG_TRANSPORT_CONNECTED, msg);
+  }
+
+  void Closed(int error) {
+    auto msg = new TransportMessageData();
+    msg->error = error;
+    network_->Post(RTC_FROM_HERE, this, MSG_TRANSPORT_CLOSED, msg);
+  }
+
+  // Go only deals in IP addresses, since libwebrtc resolves host names.
+  static std::string goAddress(const rtc::SocketAddress& address) {
+    return rtc::SocketAddress(address.ipaddr(), address.port()).ToString();
+  }
+
+  void OnMessage(rtc::Message *msg) override {
+    std::unique_ptr<TransportMessageData> data(
+        static_cast<TransportMessageData*>(msg->pdata));
+    switch (msg->message_id) {
+      case MSG_TRANSPORT_DATA:
+        OnData(data->data, data->address);
+        break;
+      case MSG_TRANSPORT_CONNECTED:
+        OnConnected(data->address);
+        break;
+      case MSG_TRANSPORT_CLOSED:
+        OnClosed(data->error);
+        break;
+    }
+  }
+
+ protected:
+  virtual ~TransportSocket() {}
+
+  virtual void OnData(const rtc::Buffer& data,
+                      const rtc::SocketAddress& from) = 0;
+  virtual void OnConnected(const rtc::SocketAddress& local) {}
+  virtual void OnClosed(int error) {}
+
+  // Stops Go from reporting anything further, and drops whatever it already
+  // has. Must be called from the network thread before destruction.
+  void Release() {
+    if (goSocket) {
+      cgoTransportClose(goSocket);
+      goSocket = 0;
+    }
+    network_->Clear(this);
+  }
+
+  int goSocket = 0;  // Index into Go's socket map, or 0 once closed.
+  rtc::Thread *network_;
+  int error_ = 0;
+};
+
+// A UDP socket for the net.PacketConn of a Go Transport.
+class TransportUdpSocket
+  : public rtc::AsyncPacketSocket,
+    public TransportSocket {
+ public:
+  explicit TransportUdpSocket(rtc::Thread *network)
+      : TransportSocket(network) {}
+
+  ~TransportUdpSocket() override { Release(); }
+
+  // Binds to |address| with a port in [|min_port|, |max_port|], or any port
+  // if both are 0.
+  bool Bind(int transport, const rtc::SocketAddress& address,
+            uint16_t min_port, uint16_t max_port) {
+    char *local = NULL;
+    goSocket = cgoTransportListenPacket(transport,
+        const_cast<char*>(address.ipaddr().ToString().c_str()),
+        min_port, max_port, static_cast<TransportSocket*>(this), &local);
+    if (!goSocket)
+      return false;
+    local_.FromString(local);
+    free(local);
+    return true;
+  }
+
+  rtc::SocketAddress GetLocalAddress() const override { return local_; }
+  rtc::SocketAddress GetRemoteAddress() const override {
+    return rtc::SocketAddress();
+  }
+
+  int Send(const void *pv, size_t cb,
+           const rtc::PacketOptions& options) override {
+    error_ = ENOTCONN;
+    return -1;
+  }
+
+  int SendTo(const void *pv, size_t cb, const rtc::SocketAddress& addr,
+             const rtc::PacketOptions& options) override {
+    if (!goSocket) {
+      error_ = EBADF;
+      return -1;
+    }
+    rtc::SentPacket sent_packet(options.packet_id, rtc::TimeMillis());
+    int sent = cgoTransportSend(goSocket, const_cast<void*>(pv), cb,
+        const_cast<char*>(goAddress(addr).c_str()));
+    if (sent < 0) {
+      error_ = EWOULDBLOCK;
+      return -1;
+    }
+    SignalSentPacket(this, sent_packet);
+    return sent;
+  }
+
+  int Close() override {
+    Release();
+    return 0;
+  }
+
+  State GetState() const override {
+    return goSocket ? STATE_BOUND : STATE_CLOSED;
+  }
+
+  int GetOption(rtc::Socket::Option opt, int *value) override {
+    auto it = options_.find(opt);
+    if (it == options_.end())
+      return -1;
+    *value = it->second;
+    return 0;
+  }
+
+  // Options are only recorded, since a net.PacketConn has none of them.
+  int SetOption(rtc::Socket::Option opt, int value) override {
+    options_[opt] = value;
+    return 0;
+  }
+
+  int GetError() const override { return error_; }
+  void SetError(int error) override { error_ = error; }
+
+ protected:
+  void OnData(const rtc::Buffer& data,
+              const rtc::SocketAddress& from) override {
+    SignalReadPacket(this, data.data<char>(), data.size(), from,
+                     rtc::CreatePacketTime(0));
+  }
+
+ private:
+  rtc::SocketAddress local_;
+  std::map<rtc::Socket::Option, int> options_;
+};
+
+// A stream socket for the net.Conn of a Go Transport, which libwebrtc's own
+// AsyncTCPSocket and AsyncStunTCPSocket frame packets over.
+class TransportTcpSocket
+  : public rtc::AsyncSocket,
+    public TransportSocket {
+ public:
+  TransportTcpSocket(rtc::Thread *network, int transport)
+      : TransportSocket(network), transport_(transport) {}
+
+  ~TransportTcpSocket() override { Release(); }
+
+  rtc::SocketAddress GetLocalAddress() const override { return local_; }
+  rtc::SocketAddress GetRemoteAddress() const override { return remote_; }
+
+  int Bind(const rtc::SocketAddress& addr) override {
+    local_ = addr;
+    return 0;
+  }
+
+  // Dials asynchronously, so that SignalConnectEvent follows.
+  int Connect(const rtc::SocketAddress& addr) override {
+    remote_ = addr;
+    goSocket = cgoTransportDial(transport_,
+        const_cast<char*>(goAddress(local_).c_str()),
+        const_cast<char*>(goAddress(remote_).c_str()),
+        static_cast<TransportSocket*>(this));
+    if (!goSocket) {
+      error_ = ECONNREFUSED;
+      return -1;
+    }
+    state_ = CS_CONNECTING;
+    return 0;
+  }
+
+  int Send(const void *pv, size_t cb) override {
+    if (CS_CONNECTED != state_) {
+      error_ = ENOTCONN;
+      return -1;
+    }
+    int sent = cgoTransportSend(goSocket, const_cast<void*>(pv), cb, NULL);
+    if (sent < 0) {
+      error_ = EPIPE;
+      return -1;
+    }
+    return sent;
+  }
+
+  int SendTo(const void *pv, size_t cb,
+             const rtc::SocketAddress& addr) override {
+    return Send(pv, cb);
+  }
+
+  int Recv(void *pv, size_t cb, int64_t *timestamp) override {
+    if (timestamp)
+      *timestamp = -1;
+    if (0 == inbuf_.size()) {
+      if (CS_CLOSED == state_)
+        return 0;
+      error_ = EWOULDBLOCK;
+      return -1;
+    }
+    size_t n = std::min(cb, inbuf_.size());
+    memcpy(pv, inbuf_.data(), n);
+    inbuf_.erase(0, n);
+    return n;
+  }
+
+  int RecvFrom(void *pv, size_t cb, rtc::SocketAddress *paddr,
+               int64_t *timestamp) override {
+    if (paddr)
+      *paddr = remote_;
+    return Recv(pv, cb, timestamp);
+  }
+
+  // Only outgoing connections go through a Transport.
+  int Listen(int backlog) override {
+    error_ = EOPNOTSUPP;
+    return -1;
+  }
+
+  rtc::AsyncSocket* Accept(rtc::SocketAddress *paddr) override {
+    error_ = EOPNOTSUPP;
+    return nullptr;
+  }
+
+  int Close() override {
+    Release();
+    state_ = CS_CLOSED;
+    return 0;
+  }
+
+  int GetError() const override { return error_; }
+  void SetError(int error) override { error_ = error; }
+  ConnState GetState() const override { return state_; }
+
+  int GetOption(rtc::Socket::Option opt, int *value) override {
+    auto it = options_.find(opt);
+    if (it == options_.end())
+      return -1;
+    *value = it->second;
+    return 0;
+  }
+
+  // Options are only recorded, since a net.Conn has none of them.
+  int SetOption(rtc::Socket::Option opt, int value) override {
+    options_[opt] = value;
+    return 0;
+  }
+
+ protected:
+  void OnData(const rtc::Buffer& data,
+              const rtc::SocketAddress& from) override {
+    inbuf_.append(data.data<char>(), data.size());
+    SignalReadEvent(this);
+  }
+
+  void OnConnected(const rtc::SocketAddress& local) override {
+    if (!local.IsNil())
+      local_ = local;
+    state_ = CS_CONNECTED;
+    SignalConnectEvent(this);
+  }
+
+  void OnClosed(int error) override {
+    state_ = CS_CLOSED;
+    SignalCloseEvent(this, error);
+  }
+
+ private:
+  int transport_;
+  rtc::SocketAddress local_;
+  rtc::SocketAddress remote_;
+  ConnState state_ = CS_CLOSED;
+  std::string inbuf_;
+  std::map<rtc::Socket::Option, int> options_;
+};
+
+// Creates the sockets of a PortAllocator from a Go Transport.
+//
+// TLS and proxies are not applied to these connections: the Transport itself
+// decides how to reach each address. Host names are still resolved natively.
+class TransportPacketSocketFactory : public rtc::PacketSocketFactory {
+ public:
+  // |transport| indexes Go's transport map.
+  TransportPacketSocketFactory(rtc::Thread *network, int transport)
+      : network_(network), transport_(transport) {}
+
+  rtc::AsyncPacketSocket* CreateUdpSocket(const rtc::SocketAddress& address,
+                                          uint16_t min_port,
+                                          uint16_t max_port) override {
+    std::unique_ptr<TransportUdpSocket> socket(
+        new TransportUdpSocket(network_));
+    if (!socket->Bind(transport_, address, min_port, max_port))
+      return nullptr;
+    return socket.release();
+  }
+
+  rtc::AsyncPacketSocket* CreateServerTcpSocket(
+      const rtc::SocketAddress& local_address, uint16_t min_port,
+      uint16_t max_port, int opts) override {
+    return nullptr;
+  }
+
+  rtc::AsyncPacketSocket* CreateClientTcpSocket(
+      const rtc::SocketAddress& local_address,
+      const rtc::SocketAddress& remote_address,
+      const rtc::ProxyInfo& proxy_info, const std::string& user_agent,
+      int opts) override {
+    if (opts & (OPT_TLS | OPT_TLS_FAKE | OPT_TLS_INSECURE))
+      return nullptr;
+    auto socket = new TransportTcpSocket(network_, transport_);
+    socket->Bind(local_address);
+    if (socket->Connect(remote_address) < 0) {
+      delete socket;
+      return nullptr;
+    }
+    // Both take ownership of |socket|.
+    if (opts & OPT_STUN)
+      return new cricket::AsyncStunTCPSocket(socket, false);
+    return new rtc::AsyncTCPSocket(socket, false);
+  }
+
+  rtc::AsyncResolverInterface* CreateAsyncResolver() override {
+    return new rtc::AsyncResolver();
+  }
+
+ private:
+  rtc::Thread *network_;
+  int transport_;
+};
+
+#endif  // _TRANSPORT_H_
@@ -0,0 +1,338 @@
+package webrtc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTransport(t *testing.T) {
+	SetLoggingVerbosity(0)
+
+	Convey("OptionTransport", t, func() {
+		network := newMemNetwork()
+		config := NewConfiguration(OptionTransport(network.transport("10.0.0.1")))
+		So(config.Transport, ShouldNotBeNil)
+		So(OptionTransport(nil)(config), ShouldNotBeNil)
+	})
+
+	Convey("Gathering through a Transport", t, func() {
+		network := newMemNetwork()
+		pc, err := NewPeerConnection(NewConfiguration(
+			OptionTransport(network.transport("10.0.0.1"))))
+		So(err, ShouldBeNil)
+		candidates := make(chan string, 16)
+		complete := make(chan bool, 1)
+		pc.OnIceCandidate = func(ic IceCandidate) {
+			candidates <- ic.Candidate
+		}
+		pc.OnIceGatheringStateChange = func(s IceGatheringState) {
+			if IceGatheringStateComplete == s {
+				complete <- true
+			}
+		}
+		channel, err := pc.CreateDataChannel("transport")
+		So(err, ShouldBeNil)
+		offer, err := pc.CreateOffer()
+		So(err, ShouldBeNil)
+		So(pc.SetLocalDescription(offer), ShouldBeNil)
+		select {
+		case <-complete:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timed out.")
+		}
+		So(len(candidates), ShouldBeGreaterThan, 0)
+		for 0 < len(candidates) {
+			So(<-candidates, ShouldContainSubstring, " 10.0.0.1 ")
+		}
+		So(network.open(), ShouldBeGreaterThan, 0)
+
+		pc.DeleteDataChannel(channel)
+		So(pc.Destroy(), ShouldBeNil)
+		So(network.open(), ShouldEqual, 0)
+	})
+
+	Convey("PeerConnections connect over an in-memory network", t, func() {
+		network := newMemNetwork()
+		alice, err := NewPeerConnection(NewConfiguration(
+			OptionTransport(network.transport("10.0.0.1"))))
+		So(err, ShouldBeNil)
+		bob, err := NewPeerConnection(NewConfiguration(
+			OptionTransport(network.transport("10.0.0.2"))))
+		So(err, ShouldBeNil)
+
+		received := make(chan string, 1)
+		bob.OnDataChannel = func(channel *DataChannel) {
+			channel.OnMessage = func(msg []byte) {
+				received <- string(msg)
+			}
+		}
+		channel, err := alice.CreateDataChannel("transport")
+		So(err, ShouldBeNil)
+		opened := make(chan bool, 1)
+		channel.OnOpen = func() {
+			opened <- true
+		}
+		exchangeDescriptions(alice, bob)
+
+		select {
+		case <-opened:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timed out waiting for the DataChannel.")
+		}
+		channel.SendText("over Go")
+		select {
+		case msg := <-received:
+			So(msg, ShouldEqual, "over Go")
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a message.")
+		}
+		So(network.delivered(), ShouldBeGreaterThan, 0)
+
+		alice.DeleteDataChannel(channel)
+		So(alice.Destroy(), ShouldBeNil)
+		So(bob.Destroy(), ShouldBeNil)
+		So(network.open(), ShouldEqual, 0)
+	})
+
+	Convey("TURN over TCP dials through a Transport", t, func() {
+		network := newMemNetwork()
+		allocations := make(chan bool, 16)
+		network.serve("10.0.0.9:3478", func(conn net.Conn) {
+			defer conn.Close()
+			header := make([]byte, 20)
+			if _, err := io.ReadFull(conn, header); nil != err {
+				return
+			}
+			if 0x0003 == binary.BigEndian.Uint16(header[0:2]) {
+				allocations <- true
+			}
+		})
+		pc, err := NewPeerConnection(NewConfiguration(
+			OptionTransport(network.transport("10.0.0.1")),
+			OptionIceServer("turn:10.0.0.9:3478?transport=tcp", "user", "secret"),
+			OptionIceTransportPolicy(IceTransportPolicyRelay)))
+		So(err, ShouldBeNil)
+		defer pc.Destroy()
+		channel, err := pc.CreateDataChannel("transport")
+		So(err, ShouldBeNil)
+		defer pc.DeleteDataChannel(channel)
+		offer, err := pc.CreateOffer()
+		So(err, ShouldBeNil)
+		So(pc.SetLocalDescription(offer), ShouldBeNil)
+		select {
+		case <-allocations:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timed out waiting for an allocation.")
+		}
+	})
+}
+
+// Negotiates between |offerer| and |answerer|, which must have a DataChannel
+// or some other reason to connect, passing their candidates across as they
+// come.
+func exchangeDescriptions(offerer, answerer *PeerConnection) {
+	var lock sync.Mutex
+	var pending []IceCandidate
+	ready := false
+	offerer.OnIceCandidate = func(ic IceCandidate) {
+		lock.Lock()
+		defer lock.Unlock()
+		if ready {
+			answerer.AddIceCandidate(ic)
+		} else {
+			pending = append(pending, ic)
+		}
+	}
+	answerer.OnIceCandidate = func(ic IceCandidate) {
+		offerer.AddIceCandidate(ic)
+	}
+	offer, err := offerer.CreateOffer()
+	So(err, ShouldBeNil)
+	So(offerer.SetLocalDescription(offer), ShouldBeNil)
+	So(answerer.SetRemoteDescription(offer), ShouldBeNil)
+	lock.Lock()
+	ready = true
+	for _, ic := range pending {
+		answerer.AddIceCandidate(ic)
+	}
+	lock.Unlock()
+	answer, err := answerer.CreateAnswer()
+	So(err, ShouldBeNil)
+	So(answerer.SetLocalDescription(answer), ShouldBeNil)
+	So(offerer.SetRemoteDescription(answer), ShouldBeNil)
+}
+
+// An in-memory network of UDP sockets, and of TCP servers.
+type memNetwork struct {
+	lock     sync.Mutex
+	conns    map[string]*memPacketConn
+	servers  map[string]func(net.Conn)
+	streams  int
+	nextPort int
+	packets  int
+}
+
+func newMemNetwork() *memNetwork {
+	return &memNetwork{
+		conns:    make(map[string]*memPacketConn),
+		servers:  make(map[string]func(net.Conn)),
+		nextPort: 10000,
+	}
+}
+
+// A Transport for a host at |ip| on this network.
+func (n *memNetwork) transport(ip string) Transport {
+	return &memTransport{n, net.ParseIP(ip)}
+}
+
+// Accept TCP connections to |addr| with |handle|.
+func (n *memNetwork) serve(addr string, handle func(net.Conn)) {
+	n.lock.Lock()
+	n.servers[addr] = handle
+	n.lock.Unlock()
+}
+
+// Number of connections still open.
+func (n *memNetwork) open() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return len(n.conns) + n.streams
+}
+
+// Number of UDP packets delivered so far.
+func (n *memNetwork) delivered() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.packets
+}
+
+type memTransport struct {
+	network *memNetwork
+	ip      net.IP
+}
+
+func (m *memTransport) Addresses() []net.IP {
+	return []net.IP{m.ip}
+}
+
+func (m *memTransport) ListenPacket(laddr *net.UDPAddr) (net.PacketConn, error) {
+	n := m.network
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	addr := &net.UDPAddr{IP: laddr.IP, Port: laddr.Port}
+	if 0 == addr.Port {
+		addr.Port = n.nextPort
+		n.nextPort++
+	}
+	if _, ok := n.conns[addr.String()]; ok {
+		return nil, errors.New("address in use")
+	}
+	conn := &memPacketConn{
+		network: n,
+		addr:    addr,
+		in:      make(chan memPacket, 256),
+		closed:  make(chan struct{}),
+	}
+	n.conns[addr.String()] = conn
+	return conn, nil
+}
+
+func (m *memTransport) DialTCP(laddr, raddr *net.TCPAddr) (net.Conn, error) {
+	n := m.network
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	handle, ok := n.servers[raddr.String()]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	local, remote := net.Pipe()
+	go handle(remote)
+	n.streams++
+	stream := &memStream{Conn: local, network: n, raddr: raddr,
+		laddr: &net.TCPAddr{IP: m.ip, Port: n.nextPort}}
+	n.nextPort++
+	return stream, nil
+}
+
+type memPacket struct {
+	data []byte
+	from net.Addr
+}
+
+// A net.PacketConn on a memNetwork, which drops packets when full.
+type memPacketConn struct {
+	network   *memNetwork
+	addr      *net.UDPAddr
+	in        chan memPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *memPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-c.in:
+		return copy(b, p.data), p.from, nil
+	case <-c.closed:
+		return 0, nil, errors.New("closed")
+	}
+}
+
+func (c *memPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n := c.network
+	n.lock.Lock()
+	to, ok := n.conns[addr.String()]
+	n.lock.Unlock()
+	if !ok {
+		return len(b), nil
+	}
+	select {
+	case to.in <- memPacket{append([]byte(nil), b...), c.addr}:
+		n.lock.Lock()
+		n.packets++
+		n.lock.Unlock()
+	default:
+	}
+	return len(b), nil
+}
+
+func (c *memPacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.network.lock.Lock()
+		delete(c.network.conns, c.addr.String())
+		c.network.lock.Unlock()
+	})
+	return nil
+}
+
+func (c *memPacketConn) LocalAddr() net.Addr                { return c.addr }
+func (c *memPacketConn) SetDeadline(t time.Time) error      { return nil }
+func (c *memPacketConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *memPacketConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// A net.Conn on a memNetwork, which counts itself until closed.
+type memStream struct {
+	net.Conn
+	network      *memNetwork
+	laddr, raddr *net.TCPAddr
+	closeOnce    sync.Once
+}
+
+func (s *memStream) LocalAddr() net.Addr  { return s.laddr }
+func (s *memStream) RemoteAddr() net.Addr { return s.raddr }
+
+func (s *memStream) Close() error {
+	s.closeOnce.Do(func() {
+		s.network.lock.Lock()
+		s.network.streams--
+		s.network.lock.Unlock()
+	})
+	return s.Conn.Close()
+}
@@ -23,7 +23,9 @@ func NewCGOMap() CGOMap {
 }
 
 func (m *CGOMap) Get(index int) interface{} {
+	m.lock.Lock()
 	p, ok := m.pointers[index]
+	m.lock.Unlock()
 	if p == nil || !ok {
 		panic("couldn't retrieve the pointer")
 	}
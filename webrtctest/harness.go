@@ -0,0 +1,155 @@
+package webrtctest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/keroserene/go-webrtc"
+)
+
+/*
+Connect negotiates a DataChannel from |offerer| to |answerer|, passing ICE
+candidates straight across, and waits up to |timeout| for it to open on both
+ends. Returns the offerer's and then the answerer's end.
+
+This replaces the OnIceCandidate handlers of both, and the OnDataChannel
+handler of |answerer|.
+*/
+func Connect(offerer, answerer *webrtc.PeerConnection,
+	timeout time.Duration) (*webrtc.DataChannel, *webrtc.DataChannel, error) {
+	// Each side can only take candidates once it has the other's description.
+	errs := make(chan error, 1)
+	toAnswerer := &candidateRelay{pc: answerer, errs: errs}
+	toOfferer := &candidateRelay{pc: offerer, errs: errs}
+	offerer.OnIceCandidate = toAnswerer.add
+	answerer.OnIceCandidate = toOfferer.add
+
+	opened := make(chan bool, 2)
+	onOpen := func(channel *webrtc.DataChannel) {
+		var once sync.Once
+		open := func() {
+			once.Do(func() { opened <- true })
+		}
+		channel.OnOpen = open
+		if webrtc.DataStateOpen == channel.ReadyState() {
+			open()
+		}
+	}
+	remote := make(chan *webrtc.DataChannel, 1)
+	answerer.OnDataChannel = func(channel *webrtc.DataChannel) {
+		onOpen(channel)
+		remote <- channel
+	}
+	local, err := offerer.CreateDataChannel("webrtctest")
+	if nil != err {
+		return nil, nil, err
+	}
+	onOpen(local)
+
+	offer, err := offerer.CreateOffer()
+	if nil != err {
+		return nil, nil, err
+	}
+	if err = offerer.SetLocalDescription(offer); nil != err {
+		return nil, nil, err
+	}
+	if err = answerer.SetRemoteDescription(offer); nil != err {
+		return nil, nil, err
+	}
+	toAnswerer.start()
+	answer, err := answerer.CreateAnswer()
+	if nil != err {
+		return nil, nil, err
+	}
+	if err = answerer.SetLocalDescription(answer); nil != err {
+		return nil, nil, err
+	}
+	if err = offerer.SetRemoteDescription(answer); nil != err {
+		return nil, nil, err
+	}
+	toOfferer.start()
+
+	deadline := time.After(timeout)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-opened:
+		case err := <-errs:
+			return local, nil, err
+		case <-deadline:
+			return local, nil, fmt.Errorf(
+				"webrtctest: DataChannel did not open within %v", timeout)
+		}
+	}
+	return local, <-remote, nil
+}
+
+// Passes ICE candidates on to |pc|, holding them back until start is called
+// once |pc| has the remote description they belong to.
+type candidateRelay struct {
+	pc      *webrtc.PeerConnection
+	errs    chan error // Takes the first error of AddIceCandidate.
+	lock    sync.Mutex
+	pending []webrtc.IceCandidate
+	started bool
+}
+
+func (r *candidateRelay) add(ic webrtc.IceCandidate) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.started {
+		r.pending = append(r.pending, ic)
+		return
+	}
+	r.addNow(ic)
+}
+
+func (r *candidateRelay) start() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.started = true
+	for _, ic := range r.pending {
+		r.addNow(ic)
+	}
+	r.pending = nil
+}
+
+// Must be called with r.lock held.
+func (r *candidateRelay) addNow(ic webrtc.IceCandidate) {
+	if err := r.pc.AddIceCandidate(ic); nil != err {
+		select {
+		case r.errs <- fmt.Errorf("webrtctest: AddIceCandidate: %v", err):
+		default:
+		}
+	}
+}
+
+// SelectedCandidates returns the local and remote candidates of the pair which
+// |pc| currently sends through.
+func SelectedCandidates(pc *webrtc.PeerConnection) (
+	local, remote *webrtc.IceCandidateStats, err error) {
+	report, err := pc.GetStats(context.Background())
+	if nil != err {
+		return nil, nil, err
+	}
+	pair := report.SelectedCandidatePair()
+	if nil == pair {
+		return nil, nil, errors.New("webrtctest: no candidate pair selected")
+	}
+	for i := range report.LocalCandidates {
+		if report.LocalCandidates[i].ID == pair.LocalCandidateID {
+			local = &report.LocalCandidates[i]
+		}
+	}
+	for i := range report.RemoteCandidates {
+		if report.RemoteCandidates[i].ID == pair.RemoteCandidateID {
+			remote = &report.RemoteCandidates[i]
+		}
+	}
+	if nil == local || nil == remote {
+		return nil, nil, errors.New("webrtctest: selected candidates missing")
+	}
+	return local, remote, nil
+}
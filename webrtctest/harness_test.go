@@ -0,0 +1,129 @@
+package webrtctest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keroserene/go-webrtc"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConnect(t *testing.T) {
+	webrtc.SetLoggingVerbosity(0)
+
+	Convey("Public hosts connect directly", t, func() {
+		network := NewNetwork()
+		alice, err := network.NewHost("203.0.113.1").NewPeerConnection()
+		So(err, ShouldBeNil)
+		defer alice.Destroy()
+		bob, err := network.NewHost("203.0.113.2").NewPeerConnection()
+		So(err, ShouldBeNil)
+		defer bob.Destroy()
+
+		local, remote, err := Connect(alice, bob, 10*time.Second)
+		So(err, ShouldBeNil)
+		received := make(chan string, 1)
+		remote.OnMessage = func(msg []byte) {
+			received <- string(msg)
+		}
+		local.SendText("hello")
+		select {
+		case msg := <-received:
+			So(msg, ShouldEqual, "hello")
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a message.")
+		}
+
+		l, r, err := SelectedCandidates(alice)
+		So(err, ShouldBeNil)
+		So(l.IP, ShouldEqual, "203.0.113.1")
+		So(r.IP, ShouldEqual, "203.0.113.2")
+		So(r.CandidateType, ShouldEqual, "host")
+	})
+
+	// Each pair of NATs, and whether peers behind them can connect.
+	for _, c := range []struct {
+		offerer, answerer NATType
+		connects          bool
+	}{
+		{NATFullCone, NATFullCone, true},
+		{NATPortRestrictedCone, NATPortRestrictedCone, true},
+		{NATSymmetric, NATRestrictedCone, true},
+		{NATSymmetric, NATPortRestrictedCone, false},
+		{NATSymmetric, NATSymmetric, false},
+	} {
+		c := c
+		Convey(c.offerer.String()+" and "+c.answerer.String()+" NATs", t, func() {
+			network := NewNetwork()
+			stun := network.NewSTUNServer("198.51.100.9")
+			aliceNAT := network.NewNAT(c.offerer, "198.51.100.1")
+			bobNAT := network.NewNAT(c.answerer, "198.51.100.2")
+			alice, err := aliceNAT.NewHost("192.168.0.2").NewPeerConnection(
+				webrtc.OptionIceServer(stun))
+			So(err, ShouldBeNil)
+			defer alice.Destroy()
+			bob, err := bobNAT.NewHost("192.168.0.2").NewPeerConnection(
+				webrtc.OptionIceServer(stun))
+			So(err, ShouldBeNil)
+			defer bob.Destroy()
+
+			timeout := 10 * time.Second
+			if !c.connects {
+				timeout = 5 * time.Second
+			}
+			_, _, err = Connect(alice, bob, timeout)
+			if !c.connects {
+				So(err, ShouldNotBeNil)
+				return
+			}
+			So(err, ShouldBeNil)
+
+			// Each reaches the other through its NAT's public address.
+			l, r, err := SelectedCandidates(alice)
+			So(err, ShouldBeNil)
+			So(l.IP, ShouldEqual, "192.168.0.2")
+			So(r.IP, ShouldEqual, "198.51.100.2")
+			So(r.CandidateType, ShouldBeIn, "srflx", "prflx")
+			_, r, err = SelectedCandidates(bob)
+			So(err, ShouldBeNil)
+			So(r.IP, ShouldEqual, "198.51.100.1")
+			So(r.CandidateType, ShouldBeIn, "srflx", "prflx")
+		})
+	}
+
+	Convey("Symmetric NATs connect through TURN", t, func() {
+		network := NewNetwork()
+		turn := network.NewTURNServer("198.51.100.9", "user", "secret")
+		aliceNAT := network.NewNAT(NATSymmetric, "198.51.100.1")
+		bobNAT := network.NewNAT(NATSymmetric, "198.51.100.2")
+		alice, err := aliceNAT.NewHost("192.168.0.2").NewPeerConnection(
+			webrtc.OptionIceServer(turn, "user", "secret"),
+			webrtc.OptionIceTransportPolicy(webrtc.IceTransportPolicyRelay))
+		So(err, ShouldBeNil)
+		defer alice.Destroy()
+		bob, err := bobNAT.NewHost("192.168.0.2").NewPeerConnection(
+			webrtc.OptionIceServer(turn, "user", "secret"))
+		So(err, ShouldBeNil)
+		defer bob.Destroy()
+
+		local, remote, err := Connect(alice, bob, 10*time.Second)
+		So(err, ShouldBeNil)
+		received := make(chan string, 1)
+		remote.OnMessage = func(msg []byte) {
+			received <- string(msg)
+		}
+		local.SendText("relayed")
+		select {
+		case msg := <-received:
+			So(msg, ShouldEqual, "relayed")
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a message.")
+		}
+
+		// Alice only sends from her relayed address on the TURN server.
+		l, _, err := SelectedCandidates(alice)
+		So(err, ShouldBeNil)
+		So(l.CandidateType, ShouldEqual, "relay")
+		So(l.IP, ShouldEqual, "198.51.100.9")
+	})
+}
@@ -0,0 +1,217 @@
+package webrtctest
+
+import (
+	"container/heap"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/keroserene/go-webrtc"
+)
+
+/*
+Impairment describes how a link mistreats the UDP packets sent over it, in
+the manner of netem, but applied to sockets in-process instead of to an
+interface, so it needs neither root nor tc.
+
+It applies to what a Host or ImpairedTransport sends, so for a symmetric link
+impair both ends: 200ms of round trip time is a Delay of 100ms each way.
+
+The zero value passes everything straight through.
+*/
+type Impairment struct {
+	// Fraction of packets dropped, from 0 to 1.
+	Loss float64
+	// Added to every packet, plus or minus up to Jitter chosen uniformly.
+	// Jitter alone reorders packets sent closer together than it.
+	Delay  time.Duration
+	Jitter time.Duration
+	// Bits per second, or 0 for no limit. Packets queue behind the limit for
+	// up to MaxQueueDelay, and past that are dropped, as by a router.
+	Bandwidth int
+	// Fraction of packets, from 0 to 1, sent without the Delay, so that they
+	// overtake those sent before them, as netem's reorder does.
+	Reorder float64
+}
+
+// How long packets queue behind a Bandwidth limit before being dropped.
+const MaxQueueDelay = time.Second
+
+// Holds an Impairment which may change while packets flow.
+type impairer struct {
+	impairmentLock sync.Mutex
+	impairment     Impairment
+}
+
+// SetImpairment changes the Impairment of every packet sent from now on,
+// including through existing sockets.
+func (i *impairer) SetImpairment(impairment Impairment) {
+	i.impairmentLock.Lock()
+	i.impairment = impairment
+	i.impairmentLock.Unlock()
+}
+
+func (i *impairer) current() Impairment {
+	i.impairmentLock.Lock()
+	defer i.impairmentLock.Unlock()
+	return i.impairment
+}
+
+// ImpairedTransport wraps another webrtc.Transport, such as a Host or one over
+// real sockets, and impairs the UDP packets sent through it. TCP connections
+// pass through untouched.
+type ImpairedTransport struct {
+	webrtc.Transport
+	impairer
+}
+
+// Impair wraps |transport| with |impairment|, which SetImpairment can change
+// later.
+func Impair(transport webrtc.Transport,
+	impairment Impairment) *ImpairedTransport {
+	t := &ImpairedTransport{Transport: transport}
+	t.SetImpairment(impairment)
+	return t
+}
+
+func (t *ImpairedTransport) ListenPacket(
+	laddr *net.UDPAddr) (net.PacketConn, error) {
+	conn, err := t.Transport.ListenPacket(laddr)
+	if nil != err {
+		return nil, err
+	}
+	return newImpairedConn(conn, &t.impairer), nil
+}
+
+// A packet waiting for its time to be sent.
+type scheduled struct {
+	at   time.Time
+	seq  uint64 // Breaks ties in order of sending.
+	data []byte
+	addr net.Addr
+}
+
+// A min-heap of scheduled packets, by time.
+type schedule []*scheduled
+
+func (s schedule) Len() int { return len(s) }
+func (s schedule) Less(i, j int) bool {
+	if s[i].at.Equal(s[j].at) {
+		return s[i].seq < s[j].seq
+	}
+	return s[i].at.Before(s[j].at)
+}
+func (s schedule) Swap(i, j int)       { s[i], s[j] = s[j], s[i] }
+func (s *schedule) Push(x interface{}) { *s = append(*s, x.(*scheduled)) }
+func (s *schedule) Pop() interface{} {
+	old := *s
+	p := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return p
+}
+
+// A net.PacketConn whose writes are impaired, and sent on a goroutine of its
+// own once due.
+type impairedConn struct {
+	net.PacketConn
+	impairer *impairer
+
+	lock      sync.Mutex
+	queue     schedule
+	seq       uint64
+	busyUntil time.Time // When the Bandwidth limit frees up.
+
+	wake      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newImpairedConn(conn net.PacketConn, i *impairer) *impairedConn {
+	c := &impairedConn{
+		PacketConn: conn,
+		impairer:   i,
+		wake:       make(chan struct{}, 1),
+		closed:     make(chan struct{}),
+	}
+	go c.send()
+	return c
+}
+
+func (c *impairedConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	impairment := c.impairer.current()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if (Impairment{}) == impairment && 0 == len(c.queue) {
+		return c.PacketConn.WriteTo(b, addr)
+	}
+	if rand.Float64() < impairment.Loss {
+		return len(b), nil
+	}
+	now := time.Now()
+	at := now
+	if 0 < impairment.Bandwidth {
+		if c.busyUntil.Before(now) {
+			c.busyUntil = now
+		}
+		if c.busyUntil.Sub(now) > MaxQueueDelay {
+			return len(b), nil
+		}
+		c.busyUntil = c.busyUntil.Add(time.Duration(len(b)*8) * time.Second /
+			time.Duration(impairment.Bandwidth))
+		at = c.busyUntil
+	}
+	if rand.Float64() >= impairment.Reorder {
+		at = at.Add(impairment.Delay)
+	}
+	if 0 < impairment.Jitter {
+		at = at.Add(time.Duration(rand.Int63n(2*int64(impairment.Jitter)+1)) -
+			impairment.Jitter)
+	}
+	c.seq++
+	heap.Push(&c.queue, &scheduled{at, c.seq, append([]byte(nil), b...), addr})
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+// Sends each packet once due, until closed.
+func (c *impairedConn) send() {
+	for {
+		c.lock.Lock()
+		for 0 < len(c.queue) && !c.queue[0].at.After(time.Now()) {
+			p := heap.Pop(&c.queue).(*scheduled)
+			c.PacketConn.WriteTo(p.data, p.addr)
+		}
+		var timer *time.Timer
+		var due <-chan time.Time
+		if 0 < len(c.queue) {
+			timer = time.NewTimer(time.Until(c.queue[0].at))
+			due = timer.C
+		}
+		c.lock.Unlock()
+		select {
+		case <-c.wake:
+		case <-due:
+		case <-c.closed:
+		}
+		if nil != timer {
+			timer.Stop()
+		}
+		select {
+		case <-c.closed:
+			return
+		default:
+		}
+	}
+}
+
+// Drops whatever is still queued.
+func (c *impairedConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.PacketConn.Close()
+}
@@ -0,0 +1,176 @@
+package webrtctest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/keroserene/go-webrtc"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// Counts the packets arriving on |conn| until none arrive for |quiet|, and
+// returns them in order along with when the last arrived.
+func drain(conn net.PacketConn, quiet time.Duration) ([]byte, time.Time) {
+	var got []byte
+	var last time.Time
+	buf := make([]byte, 1500)
+	for {
+		conn.SetReadDeadline(time.Now().Add(quiet))
+		_, _, err := conn.ReadFrom(buf)
+		if nil != err {
+			return got, last
+		}
+		got = append(got, buf[0])
+		last = time.Now()
+	}
+}
+
+func TestImpairment(t *testing.T) {
+	Convey("Impaired hosts", t, func() {
+		network := NewNetwork()
+		host := network.NewHost("203.0.113.1")
+		a := listen(host)
+		b := listen(network.NewHost("203.0.113.2"))
+		defer a.Close()
+		defer b.Close()
+
+		Convey("Pass everything straight through when unimpaired", func() {
+			for i := 0; i < 100; i++ {
+				a.WriteTo([]byte{byte(i)}, b.LocalAddr())
+			}
+			got, _ := drain(b, 50*time.Millisecond)
+			So(len(got), ShouldEqual, 100)
+			for i := range got {
+				So(got[i], ShouldEqual, byte(i))
+			}
+		})
+
+		Convey("Lose a fraction of packets", func() {
+			host.SetImpairment(Impairment{Loss: 0.05})
+			for i := 0; i < 1000; i++ {
+				a.WriteTo([]byte{0}, b.LocalAddr())
+			}
+			got, _ := drain(b, 50*time.Millisecond)
+			So(len(got), ShouldBeBetween, 900, 990)
+		})
+
+		Convey("Delay packets", func() {
+			host.SetImpairment(Impairment{Delay: 100 * time.Millisecond})
+			start := time.Now()
+			a.WriteTo([]byte{0}, b.LocalAddr())
+			So(receive(b), ShouldBeNil)
+			got, last := drain(b, 100*time.Millisecond)
+			So(len(got), ShouldEqual, 1)
+			So(last.Sub(start), ShouldBeGreaterThanOrEqualTo,
+				100*time.Millisecond)
+
+			Convey("Until unimpaired again", func() {
+				host.SetImpairment(Impairment{})
+				a.WriteTo([]byte{0}, b.LocalAddr())
+				So(receive(b), ShouldNotBeNil)
+			})
+		})
+
+		Convey("Limit bandwidth", func() {
+			// 100 packets of 1000 bytes at 1Mbps take 0.8s.
+			host.SetImpairment(Impairment{Bandwidth: 1000000})
+			start := time.Now()
+			for i := 0; i < 100; i++ {
+				a.WriteTo(make([]byte, 1000), b.LocalAddr())
+			}
+			got, last := drain(b, 100*time.Millisecond)
+			So(len(got), ShouldEqual, 100)
+			So(last.Sub(start), ShouldBeBetween, 700*time.Millisecond,
+				1200*time.Millisecond)
+
+			Convey("Dropping what would queue for too long", func() {
+				for i := 0; i < 200; i++ {
+					a.WriteTo(make([]byte, 1000), b.LocalAddr())
+				}
+				got, _ := drain(b, 100*time.Millisecond)
+				So(len(got), ShouldBeBetween, 120, 130)
+			})
+		})
+
+		Convey("Reorder packets", func() {
+			host.SetImpairment(Impairment{
+				Delay:   50 * time.Millisecond,
+				Reorder: 0.25,
+			})
+			for i := 0; i < 100; i++ {
+				a.WriteTo([]byte{byte(i)}, b.LocalAddr())
+			}
+			got, _ := drain(b, 100*time.Millisecond)
+			So(len(got), ShouldEqual, 100)
+			reordered := 0
+			for i := 1; i < len(got); i++ {
+				if got[i] < got[i-1] {
+					reordered++
+				}
+			}
+			So(reordered, ShouldBeGreaterThan, 0)
+		})
+
+		Convey("Drop what is queued once closed", func() {
+			host.SetImpairment(Impairment{Delay: 50 * time.Millisecond})
+			a.WriteTo([]byte{0}, b.LocalAddr())
+			a.Close()
+			got, _ := drain(b, 100*time.Millisecond)
+			So(len(got), ShouldEqual, 0)
+		})
+	})
+
+	Convey("ImpairedTransport wraps another Transport", t, func() {
+		network := NewNetwork()
+		transport := Impair(network.NewHost("203.0.113.1"),
+			Impairment{Loss: 1})
+		So(transport.Addresses()[0].String(), ShouldEqual, "203.0.113.1")
+		a, err := transport.ListenPacket(
+			&net.UDPAddr{IP: net.ParseIP("203.0.113.1")})
+		So(err, ShouldBeNil)
+		defer a.Close()
+		b := listen(network.NewHost("203.0.113.2"))
+		defer b.Close()
+		a.WriteTo([]byte{0}, b.LocalAddr())
+		So(receive(b), ShouldBeNil)
+	})
+}
+
+func TestImpairedPeerConnections(t *testing.T) {
+	webrtc.SetLoggingVerbosity(0)
+
+	Convey("PeerConnections over impaired hosts", t, func() {
+		network := NewNetwork()
+		aliceHost := network.NewHost("203.0.113.1")
+		bobHost := network.NewHost("203.0.113.2")
+		alice, err := aliceHost.NewPeerConnection()
+		So(err, ShouldBeNil)
+		defer alice.Destroy()
+		bob, err := bobHost.NewPeerConnection()
+		So(err, ShouldBeNil)
+		defer bob.Destroy()
+
+		Convey("Still connect through loss", func() {
+			aliceHost.SetImpairment(Impairment{Loss: 0.05})
+			bobHost.SetImpairment(Impairment{Loss: 0.05})
+			_, _, err := Connect(alice, bob, 20*time.Second)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Measure the round trip time of the delay", func() {
+			aliceHost.SetImpairment(Impairment{Delay: 100 * time.Millisecond})
+			bobHost.SetImpairment(Impairment{Delay: 100 * time.Millisecond})
+			_, _, err := Connect(alice, bob, 20*time.Second)
+			So(err, ShouldBeNil)
+			// Wait for a few connectivity checks to measure it.
+			time.Sleep(3 * time.Second)
+			report, err := alice.GetStats(context.Background())
+			So(err, ShouldBeNil)
+			pair := report.SelectedCandidatePair()
+			So(pair, ShouldNotBeNil)
+			So(pair.CurrentRoundTripTime, ShouldBeBetween, 0.19, 0.3)
+		})
+	})
+}
@@ -0,0 +1,132 @@
+package webrtctest
+
+import (
+	"net"
+
+	"github.com/keroserene/go-webrtc"
+)
+
+// NATType is the behavior of a NAT, in the terms of RFC 4787, and in the order
+// of rtc::NATType.
+type NATType int
+
+const (
+	// Endpoint-independent mapping and filtering: once a host sends anything
+	// out, anyone may reach it through its mapping.
+	NATFullCone NATType = iota
+	// Endpoint-independent mapping, and address-dependent filtering.
+	NATRestrictedCone
+	// Endpoint-independent mapping, and address and port-dependent filtering.
+	NATPortRestrictedCone
+	// Address and port-dependent mapping and filtering, so that a STUN server
+	// sees a different port than any peer does.
+	NATSymmetric
+)
+
+func (t NATType) String() string {
+	return webrtc.EnumToStringSafe(int(t), []string{
+		"FullCone",
+		"RestrictedCone",
+		"PortRestrictedCone",
+		"Symmetric",
+	})
+}
+
+// NAT connects the Hosts behind it to its Network through one public address.
+// Hosts behind the same NAT reach each other directly, but not through the
+// public address, as there is no hairpinning.
+type NAT struct {
+	Type     NATType
+	network  *Network
+	public   net.IP
+	sockets  map[string]*packetConn // Of Hosts behind it, by "ip:port".
+	mappings map[string]*natMapping // By mappingKey.
+	ports    map[int]*natMapping    // By public port.
+	nextPort int
+}
+
+// A public port, and where it may receive from.
+type natMapping struct {
+	private *net.UDPAddr
+	port    int
+	allowed map[string]bool // By filterKey.
+}
+
+// NewNAT adds a NAT of type |t| at the public address |ip|. Panics if |ip| is
+// not an IP address, or already has a NAT.
+func (n *Network) NewNAT(t NATType, ip string) *NAT {
+	nat := &NAT{
+		Type:     t,
+		network:  n,
+		public:   mustParseIP(ip),
+		sockets:  make(map[string]*packetConn),
+		mappings: make(map[string]*natMapping),
+		ports:    make(map[int]*natMapping),
+		nextPort: 40000,
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if _, ok := n.nats[nat.public.String()]; ok {
+		panic("webrtctest: there is already a NAT at " + ip)
+	}
+	n.nats[nat.public.String()] = nat
+	return nat
+}
+
+// NewHost adds a Host at the private address |ip| behind |nat|. Panics if |ip|
+// is not an IP address.
+func (nat *NAT) NewHost(ip string) *Host {
+	return &Host{network: nat.network, nat: nat, ip: mustParseIP(ip)}
+}
+
+// PublicIP returns the address which the Hosts behind |nat| share.
+func (nat *NAT) PublicIP() net.IP {
+	return nat.public
+}
+
+func (nat *NAT) mappingKey(private, to *net.UDPAddr) string {
+	if NATSymmetric == nat.Type {
+		return private.String() + " " + to.String()
+	}
+	return private.String()
+}
+
+func (nat *NAT) filterKey(remote *net.UDPAddr) string {
+	switch nat.Type {
+	case NATFullCone:
+		return ""
+	case NATRestrictedCone:
+		return remote.IP.String()
+	}
+	return remote.String()
+}
+
+// Returns the public address for a packet from |private| to |to|, and lets
+// replies through. Must be called with the Network's lock held.
+func (nat *NAT) outbound(private, to *net.UDPAddr) *net.UDPAddr {
+	key := nat.mappingKey(private, to)
+	m, ok := nat.mappings[key]
+	if !ok {
+		m = &natMapping{
+			private: private,
+			port:    nat.nextPort,
+			allowed: make(map[string]bool),
+		}
+		nat.nextPort++
+		nat.mappings[key] = m
+		nat.ports[m.port] = m
+	}
+	m.allowed[nat.filterKey(to)] = true
+	return &net.UDPAddr{IP: nat.public, Port: m.port}
+}
+
+// Returns the socket behind |nat| for a packet from |remote| to |port|, or nil
+// if there is none or the packet is filtered. Must be called with the
+// Network's lock held.
+func (nat *NAT) inbound(remote *net.UDPAddr, port int) *packetConn {
+	m, ok := nat.ports[port]
+	if !ok || !m.allowed[nat.filterKey(remote)] {
+		return nil
+	}
+	return nat.sockets[m.private.String()]
+}
@@ -0,0 +1,265 @@
+/*
+Package webrtctest runs PeerConnections on a virtual network, for tests which
+need more than the host's real interfaces can offer: peers behind simulated
+NATs of each type, without root or network access.
+
+Each Host on a Network is a webrtc.Transport, through which everything its
+PeerConnections send is routed in memory:
+
+	network := webrtctest.NewNetwork()
+	stun := network.NewSTUNServer("198.51.100.1")
+	alice := network.NewNAT(webrtctest.NATSymmetric, "203.0.113.1").
+		NewHost("192.168.0.2")
+	bob := network.NewHost("203.0.113.2")
+	offerer, _ := alice.NewPeerConnection(webrtc.OptionIceServer(stun))
+	answerer, _ := bob.NewPeerConnection(webrtc.OptionIceServer(stun))
+	_, _, err := webrtctest.Connect(offerer, answerer, 10*time.Second)
+
+Hosts can also lose, delay and throttle what they send, as described by an
+Impairment, and ImpairedTransport does the same to any other Transport.
+
+Peers which can't reach each other directly, such as behind two symmetric
+NATs, can connect through a TURN server from NewTURNServer. Only UDP is
+simulated, including between a TURN server and its clients.
+*/
+package webrtctest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/keroserene/go-webrtc"
+)
+
+// Network is a virtual internet, of Hosts with public addresses and of NATs
+// with Hosts behind them.
+type Network struct {
+	lock     sync.Mutex
+	sockets  map[string]*packetConn // Of public Hosts, by "ip:port".
+	nats     map[string]*NAT        // By public IP.
+	nextPort int
+}
+
+func NewNetwork() *Network {
+	return &Network{
+		sockets:  make(map[string]*packetConn),
+		nats:     make(map[string]*NAT),
+		nextPort: 10000,
+	}
+}
+
+// NewHost adds a Host at the public address |ip|. Panics if |ip| is not an IP
+// address.
+func (n *Network) NewHost(ip string) *Host {
+	return &Host{network: n, ip: mustParseIP(ip)}
+}
+
+// Host is a machine on a Network, and the webrtc.Transport for its
+// PeerConnections.
+type Host struct {
+	network *Network
+	nat     *NAT // Or nil, for a public address.
+	ip      net.IP
+	impairer
+}
+
+// NewPeerConnection creates a PeerConnection which sends and receives through
+// |h|, with |options| applied on top.
+func (h *Host) NewPeerConnection(
+	options ...webrtc.ConfigurationOption) (*webrtc.PeerConnection, error) {
+	options = append([]webrtc.ConfigurationOption{webrtc.OptionTransport(h)},
+		options...)
+	return webrtc.NewPeerConnection(webrtc.NewConfiguration(options...))
+}
+
+// IP returns the address of |h| on its own side of any NAT.
+func (h *Host) IP() net.IP {
+	return h.ip
+}
+
+func (h *Host) Addresses() []net.IP {
+	return []net.IP{h.ip}
+}
+
+// Sockets are subject to the Host's Impairment, as set by SetImpairment.
+func (h *Host) ListenPacket(laddr *net.UDPAddr) (net.PacketConn, error) {
+	conn, err := h.listenPacket(laddr)
+	if nil != err {
+		return nil, err
+	}
+	return newImpairedConn(conn, &h.impairer), nil
+}
+
+func (h *Host) listenPacket(laddr *net.UDPAddr) (*packetConn, error) {
+	n := h.network
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if !laddr.IP.Equal(h.ip) {
+		return nil, fmt.Errorf("%s is not an address of %s", laddr.IP, h.ip)
+	}
+	sockets := n.sockets
+	if nil != h.nat {
+		sockets = h.nat.sockets
+	}
+	addr := &net.UDPAddr{IP: h.ip, Port: laddr.Port}
+	if 0 == addr.Port {
+		addr.Port = n.nextPort
+		n.nextPort++
+	}
+	if _, ok := sockets[addr.String()]; ok {
+		return nil, fmt.Errorf("%s is in use", addr)
+	}
+	conn := &packetConn{
+		host:   h,
+		addr:   addr,
+		in:     make(chan packet, packetQueueSize),
+		closed: make(chan struct{}),
+	}
+	sockets[addr.String()] = conn
+	return conn, nil
+}
+
+func (h *Host) DialTCP(laddr, raddr *net.TCPAddr) (net.Conn, error) {
+	return nil, errors.New("webrtctest: TCP is not simulated")
+}
+
+// Sends |b| from |from| to |to|, through any NAT on the way. Must be called
+// with n.lock held.
+func (n *Network) send(from *packetConn, b []byte, to *net.UDPAddr) {
+	src := from.addr
+	if nat := from.host.nat; nil != nat {
+		if dst, ok := nat.sockets[to.String()]; ok {
+			dst.deliver(b, src)
+			return
+		}
+		if to.IP.Equal(nat.public) {
+			// There is no hairpinning back in through the public address.
+			return
+		}
+		src = nat.outbound(src, to)
+	}
+	if nat, ok := n.nats[to.IP.String()]; ok {
+		if dst := nat.inbound(src, to.Port); nil != dst {
+			dst.deliver(b, src)
+		}
+		return
+	}
+	if dst, ok := n.sockets[to.String()]; ok {
+		dst.deliver(b, src)
+	}
+}
+
+// How many packets a socket holds before dropping more, as a real one would.
+const packetQueueSize = 1024
+
+type packet struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// A UDP socket of a Host.
+type packetConn struct {
+	host      *Host
+	addr      *net.UDPAddr
+	in        chan packet
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	deadlineLock sync.Mutex
+	readDeadline time.Time
+}
+
+// Returned by ReadFrom once the read deadline passes.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "webrtctest: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Queues a copy of |b|, unless the queue is full.
+func (c *packetConn) deliver(b []byte, from *net.UDPAddr) {
+	select {
+	case c.in <- packet{append([]byte(nil), b...), from}:
+	default:
+	}
+}
+
+// A read deadline set while already reading only applies to the next read.
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.deadlineLock.Lock()
+	deadline := c.readDeadline
+	c.deadlineLock.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p := <-c.in:
+		return copy(b, p.data), p.from, nil
+	case <-c.closed:
+		return 0, nil, errors.New("webrtctest: use of closed connection")
+	case <-timeout:
+		return 0, nil, timeoutError{}
+	}
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	to, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("webrtctest: %s is not a UDP address", addr)
+	}
+	n := c.host.network
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	select {
+	case <-c.closed:
+		return 0, errors.New("webrtctest: use of closed connection")
+	default:
+	}
+	n.send(c, b, to)
+	return len(b), nil
+}
+
+func (c *packetConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		n := c.host.network
+		n.lock.Lock()
+		if nil != c.host.nat {
+			delete(c.host.nat.sockets, c.addr.String())
+		} else {
+			delete(n.sockets, c.addr.String())
+		}
+		n.lock.Unlock()
+	})
+	return nil
+}
+
+func (c *packetConn) LocalAddr() net.Addr { return c.addr }
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.deadlineLock.Lock()
+	c.readDeadline = t
+	c.deadlineLock.Unlock()
+	return nil
+}
+
+// Writes never block, so they need no deadline.
+func (c *packetConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func mustParseIP(s string) net.IP {
+	ip := net.ParseIP(s)
+	if nil == ip {
+		panic(fmt.Sprintf("webrtctest: %q is not an IP address", s))
+	}
+	return ip
+}
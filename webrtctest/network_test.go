@@ -0,0 +1,234 @@
+package webrtctest
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// Listens on any port of |host|.
+func listen(host *Host) net.PacketConn {
+	conn, err := host.ListenPacket(&net.UDPAddr{IP: host.IP()})
+	So(err, ShouldBeNil)
+	return conn
+}
+
+// Returns where the next packet on |conn| came from, or nil if none arrives.
+func receive(conn net.PacketConn) net.Addr {
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, addr, err := conn.ReadFrom(buf)
+	if nil != err {
+		return nil
+	}
+	return addr
+}
+
+func TestNetwork(t *testing.T) {
+	Convey("Public hosts reach each other", t, func() {
+		network := NewNetwork()
+		a := listen(network.NewHost("203.0.113.1"))
+		b := listen(network.NewHost("203.0.113.2"))
+		defer a.Close()
+		defer b.Close()
+		a.WriteTo([]byte("hi"), b.LocalAddr())
+		So(receive(b).String(), ShouldEqual, a.LocalAddr().String())
+
+		Convey("Until closed", func() {
+			b.Close()
+			a.WriteTo([]byte("hi"), b.LocalAddr())
+			_, err := b.WriteTo([]byte("hi"), a.LocalAddr())
+			So(err, ShouldNotBeNil)
+			_, err = network.NewHost("203.0.113.2").ListenPacket(
+				b.LocalAddr().(*net.UDPAddr))
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Hosts only listen on their own address", t, func() {
+		host := NewNetwork().NewHost("203.0.113.1")
+		_, err := host.ListenPacket(&net.UDPAddr{IP: net.ParseIP("203.0.113.9")})
+		So(err, ShouldNotBeNil)
+		_, err = host.DialTCP(&net.TCPAddr{}, &net.TCPAddr{})
+		So(err, ShouldNotBeNil)
+	})
+
+	// Behind each type of NAT, a host sends to a peer at one port, and is
+	// then sent to from elsewhere.
+	for _, c := range []struct {
+		NATType
+		sameMapping, samePeerOtherPort, otherPeer bool
+	}{
+		{NATFullCone, true, true, true},
+		{NATRestrictedCone, true, true, false},
+		{NATPortRestrictedCone, true, false, false},
+		{NATSymmetric, false, false, false},
+	} {
+		c := c
+		Convey(c.NATType.String()+" NAT", t, func() {
+			network := NewNetwork()
+			nat := network.NewNAT(c.NATType, "198.51.100.1")
+			inside := listen(nat.NewHost("192.168.0.2"))
+			peer := network.NewHost("203.0.113.1")
+			p1, p2 := listen(peer), listen(peer)
+			other := listen(network.NewHost("203.0.113.2"))
+
+			inside.WriteTo([]byte("out"), p1.LocalAddr())
+			mapped := receive(p1).(*net.UDPAddr)
+			So(mapped.IP.String(), ShouldEqual, "198.51.100.1")
+
+			inside.WriteTo([]byte("out"), other.LocalAddr())
+			So(receive(other).String() == mapped.String(), ShouldEqual,
+				c.sameMapping)
+
+			// Back through the mapping of the first packet.
+			p1.WriteTo([]byte("in"), mapped)
+			So(receive(inside).String(), ShouldEqual, p1.LocalAddr().String())
+			p2.WriteTo([]byte("in"), mapped)
+			So(nil != receive(inside), ShouldEqual, c.samePeerOtherPort)
+			other3 := listen(network.NewHost("203.0.113.3"))
+			other3.WriteTo([]byte("in"), mapped)
+			So(nil != receive(inside), ShouldEqual, c.otherPeer)
+
+			Convey("Hosts behind it reach each other directly", func() {
+				neighbor := listen(nat.NewHost("192.168.0.3"))
+				neighbor.WriteTo([]byte("lan"), inside.LocalAddr())
+				So(receive(inside).String(), ShouldEqual,
+					neighbor.LocalAddr().String())
+				// But not through the public address, without hairpinning.
+				neighbor.WriteTo([]byte("hairpin"), mapped)
+				So(receive(inside), ShouldBeNil)
+			})
+		})
+	}
+
+	Convey("STUN server", t, func() {
+		network := NewNetwork()
+		url := network.NewSTUNServer("198.51.100.9")
+		So(url, ShouldEqual, "stun:198.51.100.9:3478")
+		server, _ := net.ResolveUDPAddr("udp", "198.51.100.9:3478")
+		nat := network.NewNAT(NATPortRestrictedCone, "198.51.100.1")
+		conn := listen(nat.NewHost("192.168.0.2"))
+
+		request := make([]byte, 20)
+		binary.BigEndian.PutUint16(request[0:2], stunBindingRequest)
+		binary.BigEndian.PutUint32(request[4:8], stunMagicCookie)
+		copy(request[8:], "transaction!")
+		conn.WriteTo(request, server)
+
+		response := make([]byte, 1500)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		size, _, err := conn.ReadFrom(response)
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, 32)
+		So(binary.BigEndian.Uint16(response[0:2]), ShouldEqual, stunBindingResponse)
+		So(string(response[8:20]), ShouldEqual, "transaction!")
+		So(binary.BigEndian.Uint16(response[20:22]), ShouldEqual,
+			stunXorMappedAddress)
+		port := binary.BigEndian.Uint16(response[26:28]) ^ 0x2112
+		So(port, ShouldEqual, 40000)
+		ip := make(net.IP, 4)
+		for i := range ip {
+			ip[i] = response[28+i] ^ response[4+i]
+		}
+		So(ip.String(), ShouldEqual, "198.51.100.1")
+	})
+
+	Convey("TURN server", t, func() {
+		network := NewNetwork()
+		url := network.NewTURNServer("198.51.100.9", "user", "secret")
+		So(url, ShouldEqual, "turn:198.51.100.9:3478?transport=udp")
+		server, _ := net.ResolveUDPAddr("udp", "198.51.100.9:3478")
+		nat := network.NewNAT(NATSymmetric, "198.51.100.1")
+		client := listen(nat.NewHost("192.168.0.2"))
+		peer := listen(network.NewHost("203.0.113.1"))
+		key := md5.Sum([]byte("user:webrtctest:secret"))
+		transaction := []byte("transaction!")
+
+		// Returns the next packet on |conn|.
+		read := func(conn net.PacketConn) []byte {
+			buf := make([]byte, 1500)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			size, _, err := conn.ReadFrom(buf)
+			So(err, ShouldBeNil)
+			return buf[:size]
+		}
+		// Sends a request, authenticated with |key| unless it is nil, and
+		// returns the response.
+		request := func(method uint16, key []byte,
+			attributes ...stunAttribute) *stunMessage {
+			msg := &stunMessage{typ: method, transaction: transaction,
+				attributes: attributes}
+			if nil != key {
+				msg.add(stunUsername, []byte("user"))
+				msg.add(stunRealm, []byte("webrtctest"))
+				msg.add(stunNonce, []byte("webrtctest"))
+			}
+			client.WriteTo(msg.encode(key), server)
+			response := parseSTUN(read(client))
+			So(response, ShouldNotBeNil)
+			return response
+		}
+
+		udp := stunAttribute{turnRequestedTransport, []byte{turnProtocolUDP, 0, 0, 0}}
+		response := request(turnAllocate, nil, udp)
+		So(response.typ, ShouldEqual, turnAllocate|stunErrorResponse)
+		So(response.get(stunErrorCode)[2:4], ShouldResemble, []byte{4, 1})
+		So(string(response.get(stunRealm)), ShouldEqual, "webrtctest")
+		response = request(turnAllocate, []byte("wrong"), udp)
+		So(response.typ, ShouldEqual, turnAllocate|stunErrorResponse)
+
+		response = request(turnAllocate, key[:], udp)
+		So(response.typ, ShouldEqual, turnAllocate|stunSuccess)
+		So(response.verify(key[:]), ShouldBeTrue)
+		relayed := parseXORAddress(response.get(turnXorRelayedAddress),
+			transaction)
+		So(relayed.IP.String(), ShouldEqual, "198.51.100.9")
+		mapped := parseXORAddress(response.get(stunXorMappedAddress),
+			transaction)
+		So(mapped.IP.String(), ShouldEqual, "198.51.100.1")
+
+		// Nothing is relayed without a permission.
+		peer.WriteTo([]byte("early"), relayed)
+		So(receive(client), ShouldBeNil)
+		toPeer := stunAttribute{turnXorPeerAddress,
+			xorAddress(peer.LocalAddr().(*net.UDPAddr), transaction)}
+		response = request(turnCreatePermission, key[:], toPeer)
+		So(response.typ, ShouldEqual, turnCreatePermission|stunSuccess)
+
+		// Through Send and Data indications.
+		send := &stunMessage{typ: turnSend | stunIndication,
+			transaction: transaction}
+		send.add(toPeer.typ, toPeer.value)
+		send.add(turnDataAttribute, []byte("out"))
+		client.WriteTo(send.encode(nil), server)
+		So(string(read(peer)), ShouldEqual, "out")
+		peer.WriteTo([]byte("in"), relayed)
+		data := parseSTUN(read(client))
+		So(data, ShouldNotBeNil)
+		So(data.typ, ShouldEqual, turnData|stunIndication)
+		So(string(data.get(turnDataAttribute)), ShouldEqual, "in")
+		So(parseXORAddress(data.get(turnXorPeerAddress), data.transaction).
+			String(), ShouldEqual, peer.LocalAddr().String())
+
+		// Through a channel.
+		response = request(turnChannelBind, key[:],
+			stunAttribute{turnChannelNumber, []byte{0x40, 0x01, 0, 0}}, toPeer)
+		So(response.typ, ShouldEqual, turnChannelBind|stunSuccess)
+		client.WriteTo([]byte{0x40, 0x01, 0, 3, 'o', 'u', 't'}, server)
+		So(string(read(peer)), ShouldEqual, "out")
+		peer.WriteTo([]byte("in"), relayed)
+		So(read(client), ShouldResemble, []byte{0x40, 0x01, 0, 2, 'i', 'n'})
+
+		// Until the allocation is released.
+		response = request(turnRefresh, key[:],
+			stunAttribute{turnLifetime, []byte{0, 0, 0, 0}})
+		So(response.typ, ShouldEqual, turnRefresh|stunSuccess)
+		peer.WriteTo([]byte("late"), relayed)
+		So(receive(client), ShouldBeNil)
+	})
+}
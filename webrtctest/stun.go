@@ -0,0 +1,214 @@
+package webrtctest
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+)
+
+const (
+	stunPort             = 3478
+	stunHeaderSize       = 20
+	stunMagicCookie      = 0x2112A442
+	stunBindingRequest   = 0x0001
+	stunBindingResponse  = 0x0101
+	stunXorMappedAddress = 0x0020
+
+	// Added to a method for each class of message but requests, per RFC 5389.
+	stunIndication    = 0x0010
+	stunSuccess       = 0x0100
+	stunErrorResponse = 0x0110
+
+	stunUsername         = 0x0006
+	stunMessageIntegrity = 0x0008
+	stunErrorCode        = 0x0009
+	stunRealm            = 0x0014
+	stunNonce            = 0x0015
+)
+
+// NewSTUNServer starts a STUN server on a new public Host at |ip|, which
+// answers Binding requests so that peers behind NATs learn their server
+// reflexive candidates. Returns its URL, for webrtc.OptionIceServer.
+func (n *Network) NewSTUNServer(ip string) string {
+	host := n.NewHost(ip)
+	conn, err := host.ListenPacket(&net.UDPAddr{IP: host.ip, Port: stunPort})
+	if nil != err {
+		panic("webrtctest: " + err.Error())
+	}
+	go serveSTUN(conn)
+	return "stun:" + conn.LocalAddr().String()
+}
+
+func serveSTUN(conn net.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		size, addr, err := conn.ReadFrom(buf)
+		if nil != err {
+			return
+		}
+		request := buf[:size]
+		if size < stunHeaderSize ||
+			stunBindingRequest != binary.BigEndian.Uint16(request[0:2]) ||
+			stunMagicCookie != binary.BigEndian.Uint32(request[4:8]) {
+			continue
+		}
+		conn.WriteTo(stunBindingSuccess(request[8:20], addr.(*net.UDPAddr)),
+			addr)
+	}
+}
+
+// Returns a Binding success response for |transaction|, with the
+// XOR-MAPPED-ADDRESS of |mapped|, per RFC 5389.
+func stunBindingSuccess(transaction []byte, mapped *net.UDPAddr) []byte {
+	response := &stunMessage{typ: stunBindingResponse, transaction: transaction}
+	response.add(stunXorMappedAddress, xorAddress(mapped, transaction))
+	return response.encode(nil)
+}
+
+// A STUN message, as parsed by parseSTUN or to be sent with encode.
+type stunMessage struct {
+	typ         uint16
+	transaction []byte
+	attributes  []stunAttribute
+	// As received, and where its MESSAGE-INTEGRITY starts, or 0 if it has
+	// none.
+	raw       []byte
+	integrity int
+}
+
+type stunAttribute struct {
+	typ   uint16
+	value []byte
+}
+
+// Parses the STUN message in |b|, which it keeps referring to. Returns nil if
+// |b| is not one.
+func parseSTUN(b []byte) *stunMessage {
+	if len(b) < stunHeaderSize || 0 != b[0]&0xc0 ||
+		stunMagicCookie != binary.BigEndian.Uint32(b[4:8]) {
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if stunHeaderSize+length > len(b) {
+		return nil
+	}
+	m := &stunMessage{
+		typ:         binary.BigEndian.Uint16(b[0:2]),
+		transaction: b[8:20],
+		raw:         b[:stunHeaderSize+length],
+	}
+	for offset := stunHeaderSize; offset+4 <= len(m.raw); {
+		typ := binary.BigEndian.Uint16(m.raw[offset : offset+2])
+		size := int(binary.BigEndian.Uint16(m.raw[offset+2 : offset+4]))
+		if offset+4+size > len(m.raw) {
+			return nil
+		}
+		if stunMessageIntegrity == typ {
+			m.integrity = offset
+		}
+		m.attributes = append(m.attributes,
+			stunAttribute{typ, m.raw[offset+4 : offset+4+size]})
+		// Attributes are padded to a multiple of 4 bytes.
+		offset += 4 + (size+3)&^3
+	}
+	return m
+}
+
+// Returns the value of the first attribute of type |typ|, or nil.
+func (m *stunMessage) get(typ uint16) []byte {
+	for _, a := range m.attributes {
+		if typ == a.typ {
+			return a.value
+		}
+	}
+	return nil
+}
+
+func (m *stunMessage) add(typ uint16, value []byte) {
+	m.attributes = append(m.attributes, stunAttribute{typ, value})
+}
+
+// Encodes |m|, ending in a MESSAGE-INTEGRITY made with |key| unless it is nil.
+func (m *stunMessage) encode(key []byte) []byte {
+	b := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(b[0:2], m.typ)
+	binary.BigEndian.PutUint32(b[4:8], stunMagicCookie)
+	copy(b[8:20], m.transaction)
+	for _, a := range m.attributes {
+		b = appendAttribute(b, a.typ, a.value)
+	}
+	if nil != key {
+		b = appendAttribute(b, stunMessageIntegrity, messageIntegrity(b, key))
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)-stunHeaderSize))
+	return b
+}
+
+// Whether |m| ends in a MESSAGE-INTEGRITY made with |key|, not counting any
+// FINGERPRINT after it.
+func (m *stunMessage) verify(key []byte) bool {
+	if 0 == m.integrity {
+		return false
+	}
+	signed := append([]byte(nil), m.raw[:m.integrity]...)
+	return hmac.Equal(messageIntegrity(signed, key),
+		m.get(stunMessageIntegrity))
+}
+
+// Returns the HMAC-SHA1 of the message |b|, whose length is set to include
+// the MESSAGE-INTEGRITY about to be appended, as RFC 5389 has it.
+func messageIntegrity(b, key []byte) []byte {
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)-stunHeaderSize+4+sha1.Size))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+func appendAttribute(b []byte, typ uint16, value []byte) []byte {
+	var header [4]byte
+	binary.BigEndian.PutUint16(header[0:2], typ)
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(value)))
+	b = append(append(b, header[:]...), value...)
+	for 0 != len(b)%4 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// Returns the value of an XOR-MAPPED-ADDRESS, or of TURN's other XOR'd
+// addresses, for |addr| in a message with |transaction|.
+func xorAddress(addr *net.UDPAddr, transaction []byte) []byte {
+	ip := addr.IP.To4()
+	family := byte(0x01)
+	if nil == ip {
+		ip = addr.IP.To16()
+		family = 0x02
+	}
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:4], uint16(addr.Port)^(stunMagicCookie>>16))
+	xorIP(value[4:], ip, transaction)
+	return value
+}
+
+// Parses the value of an XOR'd address attribute. Returns nil if malformed.
+func parseXORAddress(value, transaction []byte) *net.UDPAddr {
+	if 4+net.IPv4len != len(value) && 4+net.IPv6len != len(value) {
+		return nil
+	}
+	ip := make(net.IP, len(value)-4)
+	xorIP(ip, value[4:], transaction)
+	port := binary.BigEndian.Uint16(value[2:4]) ^ (stunMagicCookie >> 16)
+	return &net.UDPAddr{IP: ip, Port: int(port)}
+}
+
+// Addresses are XORed with the magic cookie, then the transaction ID.
+func xorIP(dst, ip, transaction []byte) {
+	var mask [16]byte
+	binary.BigEndian.PutUint32(mask[0:4], stunMagicCookie)
+	copy(mask[4:], transaction)
+	for i := range ip {
+		dst[i] = ip[i] ^ mask[i]
+	}
+}
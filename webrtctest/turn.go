@@ -0,0 +1,335 @@
+package webrtctest
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+const (
+	turnAllocate         = 0x0003
+	turnRefresh          = 0x0004
+	turnSend             = 0x0006
+	turnData             = 0x0007
+	turnCreatePermission = 0x0008
+	turnChannelBind      = 0x0009
+
+	turnChannelNumber      = 0x000C
+	turnLifetime           = 0x000D
+	turnXorPeerAddress     = 0x0012
+	turnDataAttribute      = 0x0013
+	turnXorRelayedAddress  = 0x0016
+	turnRequestedTransport = 0x0019
+
+	turnRealm          = "webrtctest"
+	turnNonce          = "webrtctest"
+	turnLifetimeSecs   = 600
+	turnProtocolUDP    = 17
+	turnChannelMin     = 0x4000
+	turnChannelMax     = 0x7FFE
+	turnChannelHeader  = 4
+	turnMaxMessageSize = 1500
+)
+
+/*
+NewTURNServer starts a TURN server on a new public Host at |ip|, which relays
+UDP for clients authenticating as |username| with |credential|. Returns its
+URL, for webrtc.OptionIceServer along with the same username and credential.
+
+It implements as much of RFC 5766 as libwebrtc uses: allocations, permissions,
+Send and Data indications, and channels, none of which expire. It answers
+Binding requests too, like a STUN server.
+*/
+func (n *Network) NewTURNServer(ip, username, credential string) string {
+	host := n.NewHost(ip)
+	conn, err := host.ListenPacket(&net.UDPAddr{IP: host.ip, Port: stunPort})
+	if nil != err {
+		panic("webrtctest: " + err.Error())
+	}
+	key := md5.Sum([]byte(username + ":" + turnRealm + ":" + credential))
+	s := &turnServer{
+		host:        host,
+		conn:        conn,
+		username:    username,
+		key:         key[:],
+		allocations: make(map[string]*turnAllocation),
+	}
+	go s.serve()
+	return fmt.Sprintf("turn:%s?transport=udp", conn.LocalAddr())
+}
+
+type turnServer struct {
+	host     *Host
+	conn     net.PacketConn
+	username string
+	key      []byte // The long-term credential of |username|.
+
+	lock        sync.Mutex
+	allocations map[string]*turnAllocation // By client "ip:port".
+}
+
+// A relayed address, and who may send through it.
+type turnAllocation struct {
+	client      *net.UDPAddr
+	relay       net.PacketConn
+	permissions map[string]bool         // By peer IP.
+	channels    map[uint16]*net.UDPAddr // Peers, by channel number.
+	peers       map[string]uint16       // Channel numbers, by peer "ip:port".
+}
+
+func (s *turnServer) serve() {
+	buf := make([]byte, turnMaxMessageSize)
+	for {
+		size, addr, err := s.conn.ReadFrom(buf)
+		if nil != err {
+			return
+		}
+		client := addr.(*net.UDPAddr)
+		if size >= turnChannelHeader && turnChannelMin>>8 == buf[0]&0xc0 {
+			s.relayChannelData(client, buf[:size])
+			continue
+		}
+		msg := parseSTUN(buf[:size])
+		if nil == msg {
+			continue
+		}
+		if response := s.handle(client, msg); nil != response {
+			s.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+// Returns the response to |msg| from |client|, or nil if there is none.
+func (s *turnServer) handle(client *net.UDPAddr, msg *stunMessage) []byte {
+	switch msg.typ {
+	case stunBindingRequest:
+		return stunBindingSuccess(msg.transaction, client)
+	case turnSend | stunIndication:
+		s.relaySend(client, msg)
+		return nil
+	case turnAllocate, turnRefresh, turnCreatePermission, turnChannelBind:
+	default:
+		return nil
+	}
+	// Requests are first refused, with the realm and nonce to authenticate
+	// them with.
+	if s.username != string(msg.get(stunUsername)) ||
+		turnRealm != string(msg.get(stunRealm)) ||
+		turnNonce != string(msg.get(stunNonce)) || !msg.verify(s.key) {
+		response := &stunMessage{
+			typ: msg.typ | stunErrorResponse, transaction: msg.transaction}
+		response.add(stunErrorCode, errorCode(401, "Unauthorized"))
+		response.add(stunRealm, []byte(turnRealm))
+		response.add(stunNonce, []byte(turnNonce))
+		return response.encode(nil)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	response := &stunMessage{
+		typ: msg.typ | stunSuccess, transaction: msg.transaction}
+	var code int
+	var reason string
+	switch msg.typ {
+	case turnAllocate:
+		code, reason = s.allocate(client, msg, response)
+	case turnRefresh:
+		code, reason = s.refresh(client, msg, response)
+	case turnCreatePermission:
+		code, reason = s.createPermission(client, msg)
+	case turnChannelBind:
+		code, reason = s.bindChannel(client, msg)
+	}
+	if 0 != code {
+		response = &stunMessage{
+			typ: msg.typ | stunErrorResponse, transaction: msg.transaction}
+		response.add(stunErrorCode, errorCode(code, reason))
+	}
+	return response.encode(s.key)
+}
+
+// Each of the below returns the code and reason of an error response, or 0 on
+// success, and must be called with s.lock held.
+
+func (s *turnServer) allocate(client *net.UDPAddr, msg *stunMessage,
+	response *stunMessage) (int, string) {
+	a, ok := s.allocations[client.String()]
+	if !ok {
+		transport := msg.get(turnRequestedTransport)
+		if 4 != len(transport) || turnProtocolUDP != transport[0] {
+			return 442, "Unsupported Transport Protocol"
+		}
+		relay, err := s.host.ListenPacket(&net.UDPAddr{IP: s.host.ip})
+		if nil != err {
+			return 508, "Insufficient Capacity"
+		}
+		a = &turnAllocation{
+			client:      client,
+			relay:       relay,
+			permissions: make(map[string]bool),
+			channels:    make(map[uint16]*net.UDPAddr),
+			peers:       make(map[string]uint16),
+		}
+		s.allocations[client.String()] = a
+		go s.relayFromPeers(a)
+	}
+	// A retransmitted request gets the same allocation again.
+	response.add(turnXorRelayedAddress, xorAddress(
+		a.relay.LocalAddr().(*net.UDPAddr), msg.transaction))
+	response.add(stunXorMappedAddress, xorAddress(client, msg.transaction))
+	response.add(turnLifetime, lifetime(turnLifetimeSecs))
+	return 0, ""
+}
+
+func (s *turnServer) refresh(client *net.UDPAddr, msg *stunMessage,
+	response *stunMessage) (int, string) {
+	a, ok := s.allocations[client.String()]
+	if !ok {
+		return 437, "Allocation Mismatch"
+	}
+	requested := msg.get(turnLifetime)
+	if 4 == len(requested) && 0 == binary.BigEndian.Uint32(requested) {
+		a.relay.Close()
+		delete(s.allocations, client.String())
+		response.add(turnLifetime, lifetime(0))
+		return 0, ""
+	}
+	response.add(turnLifetime, lifetime(turnLifetimeSecs))
+	return 0, ""
+}
+
+func (s *turnServer) createPermission(client *net.UDPAddr,
+	msg *stunMessage) (int, string) {
+	a, ok := s.allocations[client.String()]
+	if !ok {
+		return 437, "Allocation Mismatch"
+	}
+	var peers []*net.UDPAddr
+	for _, attr := range msg.attributes {
+		if turnXorPeerAddress != attr.typ {
+			continue
+		}
+		peer := parseXORAddress(attr.value, msg.transaction)
+		if nil == peer {
+			return 400, "Bad Request"
+		}
+		peers = append(peers, peer)
+	}
+	if 0 == len(peers) {
+		return 400, "Bad Request"
+	}
+	for _, peer := range peers {
+		a.permissions[peer.IP.String()] = true
+	}
+	return 0, ""
+}
+
+func (s *turnServer) bindChannel(client *net.UDPAddr,
+	msg *stunMessage) (int, string) {
+	a, ok := s.allocations[client.String()]
+	if !ok {
+		return 437, "Allocation Mismatch"
+	}
+	number := msg.get(turnChannelNumber)
+	peer := parseXORAddress(msg.get(turnXorPeerAddress), msg.transaction)
+	if 4 != len(number) || nil == peer {
+		return 400, "Bad Request"
+	}
+	channel := binary.BigEndian.Uint16(number)
+	if channel < turnChannelMin || channel > turnChannelMax {
+		return 400, "Bad Request"
+	}
+	if bound, ok := a.channels[channel]; ok && bound.String() != peer.String() {
+		return 400, "Bad Request"
+	}
+	if bound, ok := a.peers[peer.String()]; ok && bound != channel {
+		return 400, "Bad Request"
+	}
+	a.channels[channel] = peer
+	a.peers[peer.String()] = channel
+	a.permissions[peer.IP.String()] = true
+	return 0, ""
+}
+
+// Sends the data of a Send indication from |client| on to its peer.
+func (s *turnServer) relaySend(client *net.UDPAddr, msg *stunMessage) {
+	peer := parseXORAddress(msg.get(turnXorPeerAddress), msg.transaction)
+	if nil == peer {
+		return
+	}
+	s.lock.Lock()
+	a, ok := s.allocations[client.String()]
+	permitted := ok && a.permissions[peer.IP.String()]
+	s.lock.Unlock()
+	if permitted {
+		a.relay.WriteTo(msg.get(turnDataAttribute), peer)
+	}
+}
+
+// Sends the data of a ChannelData message from |client| on to its peer.
+func (s *turnServer) relayChannelData(client *net.UDPAddr, b []byte) {
+	channel := binary.BigEndian.Uint16(b[0:2])
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if turnChannelHeader+length > len(b) {
+		return
+	}
+	s.lock.Lock()
+	a, ok := s.allocations[client.String()]
+	var peer *net.UDPAddr
+	if ok {
+		peer = a.channels[channel]
+	}
+	s.lock.Unlock()
+	if nil != peer {
+		a.relay.WriteTo(b[turnChannelHeader:turnChannelHeader+length], peer)
+	}
+}
+
+// Passes what peers with permission send to the relayed address of |a| back
+// to its client, through a channel if the peer has one.
+func (s *turnServer) relayFromPeers(a *turnAllocation) {
+	buf := make([]byte, turnMaxMessageSize)
+	for {
+		size, addr, err := a.relay.ReadFrom(buf)
+		if nil != err {
+			return
+		}
+		peer := addr.(*net.UDPAddr)
+		s.lock.Lock()
+		permitted := a.permissions[peer.IP.String()]
+		channel, bound := a.peers[peer.String()]
+		s.lock.Unlock()
+		if !permitted {
+			continue
+		}
+		if bound {
+			b := make([]byte, turnChannelHeader+size)
+			binary.BigEndian.PutUint16(b[0:2], channel)
+			binary.BigEndian.PutUint16(b[2:4], uint16(size))
+			copy(b[turnChannelHeader:], buf[:size])
+			s.conn.WriteTo(b, a.client)
+			continue
+		}
+		transaction := make([]byte, 12)
+		rand.Read(transaction)
+		indication := &stunMessage{
+			typ: turnData | stunIndication, transaction: transaction}
+		indication.add(turnXorPeerAddress, xorAddress(peer, transaction))
+		indication.add(turnDataAttribute, buf[:size])
+		s.conn.WriteTo(indication.encode(nil), a.client)
+	}
+}
+
+// Returns the value of an ERROR-CODE attribute, per RFC 5389.
+func errorCode(code int, reason string) []byte {
+	return append([]byte{0, 0, byte(code / 100), byte(code % 100)}, reason...)
+}
+
+func lifetime(seconds uint32) []byte {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, seconds)
+	return value
+}